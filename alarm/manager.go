@@ -0,0 +1,371 @@
+package alarm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	klog "kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/tool"
+	"kenmec/jimmy/charge_core/types"
+
+	"go.uber.org/zap"
+)
+
+// Disconnected 是閘道器 TCP 斷線的告警, 不在故障位元表內
+var Disconnected = tool.Fault{Code: "tcp_disconnected", Name: "閘道器 TCP 斷線", Severity: tool.SeverityMajor}
+
+// ErrNoAlarm 表示要確認的告警不存在或已經解除
+var ErrNoAlarm = errors.New("no such active alarm")
+
+// Manager 依遙測的故障位元與 connection.tcp 維護各站的 active 告警,
+// 只在告警產生 / 解除 / 確認時記 log 與歷史, 並發佈 alarm.list
+type Manager struct {
+	mu          sync.Mutex
+	pubMu       sync.Mutex                         // 讓 alarm.list 依狀態變化的順序發佈
+	stations    map[string]*zap.Logger             // station -> 站點 logger
+	active      map[string]map[string]*types.Alarm // station -> code -> alarm
+	history     []types.AlarmRecord
+	historySize int
+	file        *os.File // nil 表示不寫歷史檔
+	subs        map[string]int
+	eb          *eventbus.EventBus
+	reqEb       *eventbus.RequestResponseBus
+}
+
+func NewManager(cfg config.Alarm, stations []config.Station, eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus) *Manager {
+	m := &Manager{
+		stations:    make(map[string]*zap.Logger),
+		active:      make(map[string]map[string]*types.Alarm),
+		historySize: cfg.HistorySize,
+		subs:        make(map[string]int),
+		eb:          eb,
+		reqEb:       reqEb,
+	}
+	if m.historySize <= 0 {
+		m.historySize = 1000
+	}
+	for _, st := range stations {
+		m.stations[st.ID] = klog.StationLogger(st.ID, st.Addr())
+	}
+
+	if err := m.openHistory(cfg.HistoryFile); err != nil {
+		klog.Logger.Error(fmt.Sprintf("❌ 告警歷史檔無法開啟, 只保留在記憶體: %v", err))
+	}
+
+	// 依發佈順序處理, 故障產生與解除不會對調; 所有站點共用同一個佇列,
+	// 丟掉最舊的可能丟掉別的站點的故障或斷線, 所以佇列滿時讓發佈者等待
+	ordered := eventbus.SubscribeOptions{Delivery: eventbus.DeliverOrdered}
+	m.subs[events.ChargerTelemetry.Name()] = events.ChargerTelemetry.SubscribeWith(eb, m.onTelemetry, ordered)
+	m.subs[events.ConnectionTCP.Name()] = events.ConnectionTCP.SubscribeWith(eb, m.onConnection, ordered)
+	m.subs[events.AlarmAck.Name()] = events.AlarmAck.SubscribeWith(eb, func(ack types.AlarmAck) {
+		if _, err := m.Ack(ack); err != nil {
+			m.mu.Lock()
+			logger := m.logger(ack.StationId)
+			m.mu.Unlock()
+			logger.Warn(fmt.Sprintf("⚠️ station %s 告警確認失敗 (%s): %v", ack.StationId, ack.Code, err))
+		}
+	}, ordered)
+
+	// 蓋掉上次執行留下的 retained 告警
+	for _, st := range stations {
+		m.publish(st.ID)
+	}
+	return m
+}
+
+// SetStations 設定熱更新時呼叫, 移除的站點告警會解除並發佈空清單
+func (m *Manager) SetStations(stations []config.Station) {
+	next := make(map[string]*zap.Logger, len(stations))
+	for _, st := range stations {
+		next[st.ID] = klog.StationLogger(st.ID, st.Addr())
+	}
+
+	m.mu.Lock()
+	var removed []string
+	now := time.Now()
+	for id := range m.stations {
+		if next[id] != nil {
+			continue
+		}
+		for code := range m.active[id] {
+			m.clear(id, code, now)
+		}
+		delete(m.active, id)
+		removed = append(removed, id)
+	}
+	m.stations = next
+	m.mu.Unlock()
+
+	for _, id := range removed {
+		m.publish(id)
+	}
+}
+
+// Active 回傳 stationId 目前的告警, stationId 空白時回傳全部站點, 依產生時間排序
+func (m *Manager) Active(stationId string) []types.Alarm {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alarms := []types.Alarm{}
+	for id, codes := range m.active {
+		if stationId != "" && id != stationId {
+			continue
+		}
+		for _, a := range codes {
+			alarms = append(alarms, *a)
+		}
+	}
+	sort.Slice(alarms, func(i, j int) bool {
+		if alarms[i].RaisedAt.Equal(alarms[j].RaisedAt) {
+			return alarms[i].StationId+alarms[i].Code < alarms[j].StationId+alarms[j].Code
+		}
+		return alarms[i].RaisedAt.Before(alarms[j].RaisedAt)
+	})
+	return alarms
+}
+
+// History 回傳最近 limit 筆歷史 (新的在後), stationId 空白時不過濾
+func (m *Manager) History(stationId string, limit int) []types.AlarmRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := []types.AlarmRecord{}
+	for _, r := range m.history {
+		if stationId == "" || r.Alarm.StationId == stationId {
+			records = append(records, r)
+		}
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records
+}
+
+// Ack 確認告警, Code 空白或 "all" 確認該站全部未確認的告警, 回傳確認的筆數
+func (m *Manager) Ack(ack types.AlarmAck) (int, error) {
+	m.mu.Lock()
+	if m.stations[ack.StationId] == nil {
+		m.mu.Unlock()
+		return 0, fmt.Errorf("unknown station %q", ack.StationId)
+	}
+
+	all := ack.Code == "" || ack.Code == "all"
+	if !all && m.active[ack.StationId][ack.Code] == nil {
+		m.mu.Unlock()
+		return 0, ErrNoAlarm
+	}
+
+	now := time.Now()
+	n := 0
+	for code, a := range m.active[ack.StationId] {
+		if a.Acked || (!all && code != ack.Code) {
+			continue
+		}
+		a.Acked = true
+		a.AckedAt = &now
+		a.AckedBy = ack.By
+		m.record("acked", now, *a)
+		m.logger(a.StationId).Info(fmt.Sprintf("✅ station %s 告警已確認: %s (%s)", a.StationId, a.Name, ack.By))
+		n++
+	}
+	m.mu.Unlock()
+
+	if n > 0 {
+		m.publish(ack.StationId)
+	}
+	return n, nil
+}
+
+// Close 取消 event bus 訂閱並關閉歷史檔
+func (m *Manager) Close() {
+	for event, id := range m.subs {
+		m.eb.Unsubscribe(event, id)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.file != nil {
+		m.file.Close()
+		m.file = nil
+	}
+}
+
+func (m *Manager) onTelemetry(t types.ChargerTelemetry) {
+	m.mu.Lock()
+	if m.stations[t.StationId] == nil {
+		m.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	changed := false
+	for _, f := range tool.Faults {
+		changed = m.set(t.StationId, f, t.FaultBits&f.Bit != 0, now) || changed
+	}
+	m.mu.Unlock()
+
+	if changed {
+		m.publish(t.StationId)
+	}
+}
+
+// onConnection 處理時站點可能已經又重連或斷線, 所以以目前的連線狀態為準
+func (m *Manager) onConnection(c types.ConnectionTcp) {
+	res, err := m.reqEb.Request(events.TCPStatus(c.StationId), types.ReqTCPStatus{})
+	if err != nil {
+		return // 站點已移除
+	}
+	status, ok := res.Data.(types.ResTCPStatus)
+	if res.Error != nil || !ok {
+		m.mu.Lock()
+		logger := m.logger(c.StationId)
+		m.mu.Unlock()
+		logger.Error(fmt.Sprintf("❌ station %s 連線狀態查詢失敗: %v", c.StationId, res.Error))
+		return
+	}
+	connected := status.IsConnect
+
+	m.mu.Lock()
+	if m.stations[c.StationId] == nil {
+		m.mu.Unlock()
+		return
+	}
+	changed := m.set(c.StationId, Disconnected, !connected, time.Now())
+	m.mu.Unlock()
+
+	if changed {
+		m.publish(c.StationId)
+	}
+}
+
+// set 依條件產生或解除告警, 狀態沒變時回傳 false; 呼叫時需持有 mu
+func (m *Manager) set(stationId string, f tool.Fault, on bool, now time.Time) bool {
+	_, exists := m.active[stationId][f.Code]
+	switch {
+	case on && !exists:
+		if m.active[stationId] == nil {
+			m.active[stationId] = make(map[string]*types.Alarm)
+		}
+		a := &types.Alarm{
+			StationId: stationId,
+			Code:      f.Code,
+			Name:      f.Name,
+			Severity:  f.Severity,
+			RaisedAt:  now,
+		}
+		m.active[stationId][f.Code] = a
+		m.record("raised", now, *a)
+		m.logger(stationId).Warn(fmt.Sprintf("🚨 station %s 告警 [%s] %s", stationId, f.Severity, f.Name))
+		return true
+	case !on && exists:
+		m.clear(stationId, f.Code, now)
+		return true
+	}
+	return false
+}
+
+// clear 解除告警並記錄歷史; 呼叫時需持有 mu
+func (m *Manager) clear(stationId, code string, now time.Time) {
+	a := m.active[stationId][code]
+	delete(m.active[stationId], code)
+
+	a.ClearedAt = &now
+	m.record("cleared", now, *a)
+	m.logger(stationId).Info(fmt.Sprintf("✅ station %s 告警解除: %s", stationId, a.Name))
+}
+
+// logger 回傳站點的 logger, 不認識的站號用全域 logger; 呼叫時需持有 mu
+func (m *Manager) logger(stationId string) *zap.Logger {
+	if logger := m.stations[stationId]; logger != nil {
+		return logger
+	}
+	return klog.Logger
+}
+
+// publish 發佈站點目前的告警清單, pubMu 確保最後送出的是最新狀態
+func (m *Manager) publish(stationId string) {
+	m.pubMu.Lock()
+	defer m.pubMu.Unlock()
+
+	events.AlarmList.With(stationId).PublishSync(m.eb, types.AlarmList{
+		StationId: stationId,
+		Alarms:    m.Active(stationId),
+		Timestamp: time.Now(),
+	})
+}
+
+// record 加入歷史並寫入歷史檔; 呼叫時需持有 mu
+func (m *Manager) record(event string, now time.Time, a types.Alarm) {
+	r := types.AlarmRecord{Event: event, Time: now, Alarm: a}
+
+	m.history = append(m.history, r)
+	if len(m.history) > m.historySize {
+		m.history = m.history[len(m.history)-m.historySize:]
+	}
+
+	if m.file == nil {
+		return
+	}
+	line, _ := json.Marshal(r)
+	if _, err := m.file.Write(append(line, '\n')); err != nil {
+		klog.Logger.Error(fmt.Sprintf("❌ 告警歷史寫入失敗: %v", err))
+	}
+}
+
+// openHistory 讀回歷史檔最後 historySize 筆, 檔案過大時只保留這些再接著寫
+func (m *Manager) openHistory(path string) error {
+	if path == "" {
+		kenmecPath, err := klog.GetKenmecFilePath()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(kenmecPath, "_logs/charge_station/alarms.jsonl")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	total := 0
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r types.AlarmRecord
+			if json.Unmarshal(scanner.Bytes(), &r) != nil {
+				continue
+			}
+			total++
+			m.history = append(m.history, r)
+			if len(m.history) > m.historySize {
+				m.history = m.history[1:]
+			}
+		}
+		f.Close()
+	}
+
+	flags := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	if total > 2*m.historySize {
+		flags = os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+	}
+	f, err := os.OpenFile(path, flags, 0666)
+	if err != nil {
+		return err
+	}
+	if flags&os.O_TRUNC != 0 {
+		for _, r := range m.history {
+			line, _ := json.Marshal(r)
+			f.Write(append(line, '\n'))
+		}
+	}
+	m.file = f
+	return nil
+}
@@ -1,29 +1,52 @@
 package api
 
 import (
+	"fmt"
+	"kenmec/jimmy/charge_core/capture"
+	"kenmec/jimmy/charge_core/config"
 	eventbus "kenmec/jimmy/charge_core/infra"
+	klog "kenmec/jimmy/charge_core/log"
+	"path/filepath"
 	"sync"
 )
 
 type CANManager struct {
-	mu     sync.RWMutex
-	client map[string]*CANClient
+	mu      sync.RWMutex
+	client  map[string]*CANClient
+	capture config.Capture
 }
 
-func NewCANManager() *CANManager {
+func NewCANManager(captureCfg config.Capture) *CANManager {
 	return &CANManager{
-		client: make(map[string]*CANClient),
+		client:  make(map[string]*CANClient),
+		capture: captureCfg,
 	}
 }
 
-func (m *CANManager) Add(stationId, ip, port string, eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus) *CANClient {
+// Add 建立站點連線並等到第一次連上
+func (m *CANManager) Add(st config.Station, eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus) *CANClient {
+	client := m.Start(st, eb, reqEb)
+	client.WaitForConnection()
+	return client
+}
+
+// Start 建立站點連線但不等待, 熱更新新增站點時用, 連不上也不會卡住其他站點
+func (m *CANManager) Start(st config.Station, eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus) *CANClient {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	client := NewCANClient(stationId, ip, port, eb, reqEb)
-	m.client[stationId] = client
+	var cw *capture.Writer
+	if st.Capture {
+		w, err := m.openCapture(st.ID)
+		if err != nil {
+			klog.Logger.Error(fmt.Sprintf("station %s capture disabled: %v", st.ID, err))
+		} else {
+			cw = w
+		}
+	}
 
-	client.WaitForConnection()
+	client := NewCANClient(st, cw, eb, reqEb)
+	m.client[st.ID] = client
 	return client
 }
 
@@ -67,3 +90,26 @@ func (m *CANManager) CloseAll() {
 		delete(m.client, id)
 	}
 }
+
+// openCapture 開啟站點的 capture 檔 <dir>/capture-<id>.jsonl
+func (m *CANManager) openCapture(stationId string) (*capture.Writer, error) {
+	dir := m.capture.Dir
+	if dir == "" {
+		kenmecPath, err := klog.GetKenmecFilePath()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(kenmecPath, "_logs/charge_station/capture")
+	}
+
+	maxSize := m.capture.MaxSizeMB
+	if maxSize <= 0 {
+		maxSize = 10
+	}
+	maxFiles := m.capture.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = 10
+	}
+
+	return capture.NewWriter(filepath.Join(dir, "capture-"+stationId+".jsonl"), int64(maxSize)<<20, maxFiles)
+}
@@ -4,44 +4,105 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"kenmec/jimmy/charge_core/capture"
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
 	"kenmec/jimmy/charge_core/infra"
 	eventbus "kenmec/jimmy/charge_core/infra"
 	klog "kenmec/jimmy/charge_core/log"
 	"kenmec/jimmy/charge_core/tool"
+	"kenmec/jimmy/charge_core/transport"
 	"kenmec/jimmy/charge_core/types"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.uber.org/zap"
 )
 
 type CANClient struct {
+	mu           sync.RWMutex
 	stationId    string
 	isConnect    bool
-	conn         net.Conn
+	conn         io.ReadWriteCloser // 以 mu 保護, run 重連時換掉
 	addr         string
+	transport    transport.Transport
+	aliveTimeout time.Duration // datagram transport 多久沒收到 frame 視為斷線
+	aliveTimer   *time.Timer   // 以 mu 保護
 	writeQueue   chan []byte
 	ctx          context.Context
 	cancel       context.CancelFunc
 	isReady      chan struct{}
 	intervalStop chan struct{}
+	pollInterval time.Duration
+	driver       tool.ProtocolDriver
+	splitter     tool.FrameSplitter
+	capture      *capture.Writer // nil 表示不記錄
+	telemetry    types.ChargerTelemetry
+	cmdSub       int          // qams.command 的訂閱 id, Close 時取消
+	pending      atomic.Int32 // 已排入 writeQueue 還沒寫出的指令, Drain 用
+	lastLoop     atomic.Int64 // writeLoop 最後一次執行的時間 (UnixNano), watchdog 用
+	logger       *zap.Logger  // 帶 station_id / addr 欄位
+	attempt      int          // 上次連上之後的連線嘗試次數, 只在 run 中使用
 	eb           *eventbus.EventBus
 	reqEb        *eventbus.RequestResponseBus
 }
 
-func NewCANClient(stationId string, ip string, port string, eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus) *CANClient {
+// NewCANClient 建立並啟動一個站點的連線, cw 不為 nil 時記錄收送的原始 frame
+func NewCANClient(st config.Station, cw *capture.Writer, eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus) *CANClient {
 	ctx, cancel := context.WithCancel(context.Background())
+	remote := net.JoinHostPort(st.IP, st.Port)
+	addr := st.Addr()
+	logger := klog.StationLogger(st.ID, addr)
+
+	driver, err := tool.NewDriver(st.Protocol, st.CANAddress())
+	if err != nil {
+		// config.Validate 已檢查過, 這裡只是保險
+		klog.Logger.Error(fmt.Sprintf("station %s: %v, 使用預設格式", st.ID, err))
+		driver, _ = tool.NewDriver("", st.CANAddress())
+	}
+
+	opts := transport.Options{Kind: st.Transport, Remote: remote, Listen: st.Listen, Interface: st.Interface, Driver: driver}
+	if st.Transport == transport.KindTCPServer {
+		opts.Remote = st.IP
+		opts.StationId, opts.Identify, opts.RegisterID = st.ID, st.Identify, st.RegisterID
+	}
+	tr, err := transport.New(opts, logger)
+	if err != nil {
+		klog.Logger.Error(fmt.Sprintf("station %s: %v, 使用 tcp", st.ID, err))
+		tr, _ = transport.New(transport.Options{Remote: remote}, logger)
+	}
+
+	// 沒指定時等三次輪詢, 至少 10 秒
+	aliveTimeout := time.Duration(st.AliveTimeout) * time.Second
+	if aliveTimeout == 0 {
+		aliveTimeout = max(3*time.Duration(st.PollInterval)*time.Second, 10*time.Second)
+	}
 
 	client := &CANClient{
-		stationId:  stationId,
-		isConnect:  false,
-		addr:       net.JoinHostPort(ip, port),
-		writeQueue: make(chan []byte, 100), // buffered channel
-		ctx:        ctx,
-		cancel:     cancel,
-		isReady:    make(chan struct{}),
-		eb:         eb,
-		reqEb:      reqEb,
+		stationId:    st.ID,
+		isConnect:    false,
+		addr:         addr,
+		transport:    tr,
+		aliveTimeout: aliveTimeout,
+		writeQueue:   make(chan []byte, 100), // buffered channel
+		ctx:          ctx,
+		cancel:       cancel,
+		isReady:      make(chan struct{}),
+		pollInterval: time.Duration(st.PollInterval) * time.Second,
+		driver:       driver,
+		splitter:     tool.FrameSplitter{Driver: driver},
+		telemetry:    types.ChargerTelemetry{StationId: st.ID},
+		capture:      cw,
+		logger:       logger,
+		eb:           eb,
+		reqEb:        reqEb,
 	}
 
+	client.sub()
 	go client.run() // main control goroutine
 	go client.writeLoop()
 	return client
@@ -49,52 +110,86 @@ func NewCANClient(stationId string, ip string, port string, eb *eventbus.EventBu
 
 func (c *CANClient) run() {
 	for {
+		// Close 後讀取端也會報錯, 不要再重連
+		if c.ctx.Err() != nil {
+			return
+		}
+
 		err := c.connect()
 		if err != nil {
+			// Close 時 Open 會被取消, 不要發佈斷線
+			if c.ctx.Err() != nil {
+				return
+			}
 
-			c.eb.Publish("connection.tcp", types.ConnectionTcp{
+			events.ConnectionTCP.With(c.stationId).Publish(c.eb, types.ConnectionTcp{
 				StationId: c.stationId,
 				IsConnect: false,
 				Msg:       err.Error(),
 			})
-			c.isConnect = false
-			klog.Logger.Error("Reconnect in 3 seconds...")
-			klog.Logger.Error(fmt.Sprintf("can connect error: %e", err))
-			time.Sleep(3 * time.Second)
-			continue
+			c.setConnect(false)
+			c.logger.Error("Reconnect in 3 seconds...", zap.Int("attempt", c.attempt), zap.Error(err))
+			select {
+			case <-time.After(3 * time.Second):
+				continue
+			case <-c.ctx.Done():
+				return
+			}
 		}
 
 		// ---- 連線成功就啟動 interval ----
-		// c.startInterval()
-		c.isConnect = true
+		if c.pollInterval > 0 {
+			c.startInterval()
+		}
+		c.splitter.Reset()
+		conn := c.currentConn()
 		readDone := make(chan struct{})
-		go c.readLoop(readDone)
-		go c.sub()
+		go c.readLoop(conn, readDone)
 
 		select {
 		case <-readDone:
-			klog.Logger.Info("Connection lost, reconnecting...")
-			//	c.stopInterval() // <-- 斷線必須停掉 interval
-			c.conn.Close()
+			c.stopInterval() // <-- 斷線必須停掉 interval
+			conn.Close()
+			c.stopAlive()
+			// Close 也會讓讀取結束 (例如 tcp_server 解除綁定), 和 ctx.Done 同時成立時可能先選到這裡;
+			// 已關閉的 client 不能再發佈斷線, 熱更新重啟時會蓋掉新 client 的已連線
+			if c.ctx.Err() != nil {
+				c.logger.Info("Shutting down CAN client...")
+				return
+			}
+			c.logger.Info("Connection lost, reconnecting...")
+			// tcp_server 要等閘道器連回來, 這段時間要回報斷線
+			if c.IsConnected() {
+				c.setConnect(false)
+				events.ConnectionTCP.With(c.stationId).Publish(c.eb, types.ConnectionTcp{
+					StationId: c.stationId,
+					IsConnect: false,
+					Msg:       "connection lost",
+				})
+			}
 
 		case <-c.ctx.Done():
-			klog.Logger.Info("Shutting down CAN client...")
-			//c.stopInterval() // <-- 關閉也必須停掉 interval
-			c.conn.Close()
+			c.logger.Info("Shutting down CAN client...")
+			c.stopInterval() // <-- 關閉也必須停掉 interval
+			conn.Close()
+			c.stopAlive()
 			return
 		}
 	}
 }
 
 func (c *CANClient) connect() error {
-	klog.Logger.Info(fmt.Sprintf("%v try to connect", c.addr))
-	// 1. 使用 net.Dial。這會自動選擇一個本地的隨機埠來發送和接收數據。
-	// c.addr 必須是遠端目標的 IP:Port (例如 "192.168.1.100:8080" 或 "127.0.0.1:8080")
-	conn, err := net.Dial("tcp", c.addr)
+	c.attempt++
+	c.logger.Info("try to connect", zap.Int("attempt", c.attempt), zap.Stringer("transport", c.transport))
+	// tcp 主動連到閘道器, tcp_server 阻塞到閘道器連進來, udp 只是開 socket
+	conn, err := c.transport.Open(c.ctx)
 	if err != nil {
-		klog.Logger.Error(fmt.Sprintf("Dial failed: %v", err))
-		c.isConnect = false
-		c.eb.Publish("connection.tcp", types.ConnectionTcp{
+		if c.ctx.Err() != nil {
+			return err
+		}
+		c.logger.Error("Dial failed", zap.Int("attempt", c.attempt), zap.Error(err))
+		c.setConnect(false)
+		events.ConnectionTCP.With(c.stationId).Publish(c.eb, types.ConnectionTcp{
 			StationId: c.stationId,
 			IsConnect: false,
 			Msg:       err.Error(),
@@ -103,8 +198,27 @@ func (c *CANClient) connect() error {
 		return err
 	}
 
+	c.mu.Lock()
 	c.conn = conn
-	c.eb.Publish("connection.tcp", types.ConnectionTcp{
+	c.mu.Unlock()
+	if c.transport.Datagram() {
+		// 無連線的 transport 收到第一個 frame 才算連上, 見 alive
+		c.logger.Info("Socket opened, waiting for frames", zap.Int("attempt", c.attempt), zap.Duration("alive_timeout", c.aliveTimeout))
+		c.attempt = 0
+		return nil
+	}
+
+	c.markConnected()
+	c.logger.Info("Connected to device", zap.Int("attempt", c.attempt))
+	c.attempt = 0
+	return nil
+}
+
+// markConnected 更新狀態並發佈已連線, 第一次連上時通知 WaitForConnection
+func (c *CANClient) markConnected() {
+	// 先更新狀態再發佈, 收到事件後查詢 tcp.<id>.status 才會是已連線
+	c.setConnect(true)
+	events.ConnectionTCP.With(c.stationId).Publish(c.eb, types.ConnectionTcp{
 		StationId: c.stationId,
 		IsConnect: true,
 		Msg:       "",
@@ -120,53 +234,144 @@ func (c *CANClient) connect() error {
 		// 第一次連線成功，關閉頻道
 		close(c.isReady)
 	}
+}
 
-	klog.Logger.Info(fmt.Sprintf("Connected to device: %s", c.addr))
-	return nil
+// alive 在 datagram transport 收到 frame 時呼叫, aliveTimeout 內沒再收到就視為斷線
+func (c *CANClient) alive() {
+	c.mu.Lock()
+	if c.aliveTimer == nil {
+		c.aliveTimer = time.AfterFunc(c.aliveTimeout, c.lost)
+	} else {
+		c.aliveTimer.Reset(c.aliveTimeout)
+	}
+	c.mu.Unlock()
+
+	if !c.IsConnected() {
+		c.markConnected()
+		c.logger.Info("Receiving frames from device")
+	}
+}
+
+func (c *CANClient) lost() {
+	if !c.IsConnected() {
+		return
+	}
+	c.setConnect(false)
+	events.ConnectionTCP.With(c.stationId).Publish(c.eb, types.ConnectionTcp{
+		StationId: c.stationId,
+		IsConnect: false,
+		Msg:       fmt.Sprintf("no frames for %s", c.aliveTimeout),
+	})
+	c.logger.Warn("No frames received, marking disconnected", zap.Duration("alive_timeout", c.aliveTimeout))
+}
+
+func (c *CANClient) stopAlive() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.aliveTimer != nil {
+		c.aliveTimer.Stop()
+		c.aliveTimer = nil
+	}
 }
 
 // 新增：等待連線建立完成
 func (c *CANClient) WaitForConnection() {
-	<-c.isReady
-	klog.Logger.Info("CAN client is ready for commands.")
+	select {
+	case <-c.isReady:
+		c.logger.Info("CAN client is ready for commands.")
+	case <-c.ctx.Done():
+	}
 }
 
-func (c *CANClient) readLoop(done chan struct{}) {
+func (c *CANClient) readLoop(conn io.Reader, done chan struct{}) {
 	buffer := make([]byte, 1024)
 
 	for {
-		n, err := c.conn.Read(buffer)
+		n, err := conn.Read(buffer)
 		if err != nil {
-			klog.Logger.Error(fmt.Sprintf("Read error: %v", err))
+			c.logger.Error("Read error", zap.Error(err))
 			close(done)
 			return
 		}
 
-		c.handlePacket(buffer[:n])
+		c.record(capture.DirRx, buffer[:n])
+		if !c.transport.Datagram() {
+			c.handlePacket(buffer[:n])
+			continue
+		}
+
+		// 每個 datagram 各自成 frame, 不完整的不要和下一個接在一起
+		c.splitter.Reset()
+		if c.handlePacket(buffer[:n]) > 0 {
+			c.alive()
+		}
 	}
 }
 
-func (c *CANClient) handlePacket(pkt []byte) {
-	// if len(pkt) < 6 {
-	//     klog.Logger.Error("packet too short")
-	// 	return
-	// }
+// handlePacket 處理收到的資料, 回傳其中通過檢查的 frame 數
+func (c *CANClient) handlePacket(pkt []byte) int {
+	// TCP 是 stream, 一次 Read 可能含半個或多個 frame
+	frames, dropped := c.splitter.Feed(pkt)
+	if dropped > 0 {
+		c.logger.Warn("dropped bytes to resync frames", zap.Int("bytes", dropped))
+	}
 
-	//fmt.Println(pkt)
+	for _, frame := range frames {
+		if !c.driver.MatchFrame(frame) {
+			c.logger.Debug("ignore frame from other CAN id", zap.String("can_id", fmt.Sprintf("%#x", frame.CANID)), zap.Bool("extended", frame.Extended))
+			continue
+		}
+		if !strings.EqualFold(frame.StationId, c.stationId) {
+			continue
+		}
+
+		c.handleStatus(tool.DecodeStatus(frame))
+	}
+	return len(frames)
+}
 
-	//送到event bus
+// handleStatus 更新遙測並累積充電電量, 送到 event bus
+func (c *CANClient) handleStatus(st tool.Status) {
+	now := time.Now()
+
+	c.mu.Lock()
+	prev := c.telemetry
+	energy := prev.EnergyWh
+	if prev.Charging && !prev.Timestamp.IsZero() {
+		energy += prev.Voltage * prev.Current * now.Sub(prev.Timestamp).Hours()
+	}
+	c.telemetry = types.ChargerTelemetry{
+		StationId:   c.stationId,
+		Charging:    st.Charging,
+		Voltage:     st.Voltage,
+		Current:     st.Current,
+		Temperature: st.Temperature,
+		FaultBits:   st.FaultBits,
+		EnergyWh:    energy,
+		Timestamp:   now,
+	}
+	t := c.telemetry
+	c.mu.Unlock()
 
+	events.ChargerTelemetry.With(c.stationId).Publish(c.eb, t)
 }
 
+// WriteLoopTick 讓閒置的 writeLoop 也定期回報還活著
+const WriteLoopTick = 5 * time.Second
+
 func (c *CANClient) writeLoop() {
+	ticker := time.NewTicker(WriteLoopTick)
+	defer ticker.Stop()
+
 	for {
+		c.lastLoop.Store(time.Now().UnixNano())
+
 		select {
 		case msg := <-c.writeQueue:
-			klog.Logger.Info(fmt.Sprintf("➡️ Send command to station %v, data: %b\n", c.stationId, msg))
-			_, err := c.conn.Write(msg)
-			if err != nil {
-				klog.Logger.Error(fmt.Sprintf("Write error: %v", err))
-			}
+			c.write(msg)
+			c.pending.Add(-1)
+
+		case <-ticker.C:
 
 		case <-c.ctx.Done():
 			return
@@ -174,20 +379,114 @@ func (c *CANClient) writeLoop() {
 	}
 }
 
+func (c *CANClient) write(msg []byte) {
+	conn := c.currentConn()
+	if conn == nil {
+		c.logger.Warn("not connected yet, drop command", zap.String("data", fmt.Sprintf("% x", msg)))
+		return
+	}
+
+	c.logger.Info("➡️ Send command", zap.String("data", fmt.Sprintf("% x", msg)))
+	_, err := conn.Write(msg)
+	if err != nil {
+		c.logger.Error("Write error", zap.Error(err))
+		return
+	}
+	c.record(capture.DirTx, msg)
+}
+
 // Public API method
 func (c *CANClient) SendCommand(cmd string) error {
-	commandBytes, err := tool.Command(c.stationId, cmd)
+	commandBytes, err := c.driver.EncodeCommand(c.stationId, cmd)
 	if err != nil {
 		return err
 	}
 	// fmt.Printf("<<< 送數據 (長度: %d):\n", len(commandBytes))
 
-	c.writeQueue <- commandBytes // send to async goroutine
+	c.pending.Add(1)
+	select {
+	case c.writeQueue <- commandBytes: // send to async goroutine
+		return nil
+	case <-c.ctx.Done():
+		// Close 之後 writeLoop 已經結束, 不要卡在佇列上
+		c.pending.Add(-1)
+		return fmt.Errorf("station %s: client closed", c.stationId)
+	}
+}
+
+// Drain 等 writeQueue 中的指令都寫出, ctx 到期時放棄並回傳錯誤
+func (c *CANClient) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for c.pending.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("station %s: %d commands not sent: %w", c.stationId, c.pending.Load(), ctx.Err())
+		}
+	}
 	return nil
 }
 
+// Close 停止重連並取消 event bus 上的訂閱, 站點從設定移除時使用
 func (c *CANClient) Close() {
 	c.cancel()
+	c.transport.Close()
+	events.QamsCommand.With(c.stationId).Unsubscribe(c.eb, c.cmdSub)
+	c.reqEb.UnregisterHandler(events.TCPStatus(c.stationId))
+	if c.capture != nil {
+		c.capture.Close()
+	}
+}
+
+func (c *CANClient) record(dir string, data []byte) {
+	if c.capture == nil {
+		return
+	}
+	if err := c.capture.Write(dir, c.stationId, data); err != nil {
+		c.logger.Error("capture error", zap.Error(err))
+	}
+}
+
+// LastWriteLoop 回傳 writeLoop 最後一次執行的時間, 卡在寫入時不會更新
+func (c *CANClient) LastWriteLoop() time.Time {
+	return time.Unix(0, c.lastLoop.Load())
+}
+
+// Logger 回傳帶 station_id / addr 欄位的站點 logger, 讓同一站的其他元件共用
+func (c *CANClient) Logger() *zap.Logger {
+	return c.logger
+}
+
+func (c *CANClient) StationId() string {
+	return c.stationId
+}
+
+func (c *CANClient) IsConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.isConnect
+}
+
+// Telemetry 回傳最後一次收到的充電機遙測
+func (c *CANClient) Telemetry() types.ChargerTelemetry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.telemetry
+}
+
+// currentConn 回傳目前的連線, 還沒連上過時為 nil
+func (c *CANClient) currentConn() io.ReadWriteCloser {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+func (c *CANClient) setConnect(isConnect bool) {
+	c.mu.Lock()
+	c.isConnect = isConnect
+	c.mu.Unlock()
 }
 
 func (c *CANClient) SendTextCommandToCAN() {
@@ -197,12 +496,17 @@ func (c *CANClient) SendTextCommandToCAN() {
 	messageBytes, err := hex.DecodeString(messageHex)
 
 	if err != nil {
-		klog.Logger.Error(fmt.Sprintf("%v", err))
+		c.logger.Error(fmt.Sprintf("%v", err))
 	}
 
-	_, err = c.conn.Write(messageBytes)
+	conn := c.currentConn()
+	if conn == nil {
+		c.logger.Warn("not connected yet")
+		return
+	}
+	_, err = conn.Write(messageBytes)
 	if err != nil {
-		klog.Logger.Error(fmt.Sprintf("發送數據失敗: %v", err))
+		c.logger.Error(fmt.Sprintf("發送數據失敗: %v", err))
 		return
 	}
 }
@@ -219,14 +523,12 @@ func (c *CANClient) startInterval() {
 	}
 
 	go func(stop <-chan struct{}) {
-		ticker := time.NewTicker(2 * time.Second)
+		ticker := time.NewTicker(c.pollInterval)
 
 		for {
 			select {
 			case <-ticker.C:
-				// c.SendCommand("read")
-				c.SendCommand("start")
-				//c.SendCommand("stop")
+				c.SendCommand(c.driver.PollCommand())
 			case <-stop:
 				ticker.Stop()
 
@@ -243,25 +545,32 @@ func (c *CANClient) stopInterval() {
 	}
 }
 
+// sub 只在建立時訂閱一次, 重連不會重複註冊
 func (c *CANClient) sub() {
-	c.eb.Subscribe("qams.command", func(data interface{}) {
-		cmd := data.(types.QamsCommand)
-
-		if cmd.StationId != c.stationId {
-			return
+	// 只訂閱自己站號的指令, 依收到的順序送出 (例如 start 之後的 stop), 佇列滿時等待而不是丟掉
+	c.cmdSub = events.QamsCommand.With(c.stationId).SubscribeWith(c.eb, func(cmd types.QamsCommand) {
+		// 這裡只知道指令排進了寫入佇列, 充電機是否執行要看之後的遙測
+		result := types.CommandResult{StationId: c.stationId, Cmd: cmd.Cmd, Ok: true, Status: types.CommandQueued}
+		if err := c.SendCommand(cmd.Cmd); err != nil {
+			c.logger.Error("command rejected", zap.String("cmd", cmd.Cmd), zap.Error(err))
+			result.Ok, result.Status = false, types.CommandRejected
+			result.Error = err.Error()
+		} else if !c.IsConnected() {
+			result.Ok, result.Status = false, types.CommandOffline
+			result.Error = "station not connected"
 		}
 
-		c.SendCommand(cmd.Cmd)
-	})
+		events.CommandResult.With(c.stationId).Publish(c.eb, result)
+	}, eventbus.SubscribeOptions{Delivery: eventbus.DeliverOrdered})
 
-	reqName := "tcp." + c.stationId + ".status"
+	reqName := events.TCPStatus(c.stationId)
 
 	c.reqEb.RegisterHandler(reqName, infra.TypedRequestHandler(
 		func(ctx context.Context, req types.ReqTCPStatus) (types.ResTCPStatus, error) {
 
 			return types.ResTCPStatus{
 				StationId: c.stationId,
-				IsConnect: c.isConnect,
+				IsConnect: c.IsConnected(),
 			}, nil
 		},
 	))
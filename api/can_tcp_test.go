@@ -0,0 +1,143 @@
+package api
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/simulator"
+	"kenmec/jimmy/charge_core/transport"
+	"kenmec/jimmy/charge_core/types"
+)
+
+func TestCANClientCommandResult(t *testing.T) {
+	_, can, eb := newTestStation(t)
+
+	results := make(chan types.CommandResult, 10)
+	events.CommandResult.With("01").Subscribe(eb, func(r types.CommandResult) { results <- r })
+
+	tests := []struct {
+		cmd    string
+		ok     bool
+		status string
+	}{
+		{"start", true, types.CommandQueued},
+		{"set_current_limit 999", false, types.CommandRejected},
+		{"bogus", false, types.CommandRejected},
+	}
+
+	for _, tt := range tests {
+		events.QamsCommand.With("01").Publish(eb, types.QamsCommand{StationId: "01", Cmd: tt.cmd})
+
+		select {
+		case r := <-results:
+			if r.Cmd != tt.cmd || r.Ok != tt.ok || r.Status != tt.status {
+				t.Errorf("%s: got %+v, want ok %v status %s", tt.cmd, r, tt.ok, tt.status)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no result", tt.cmd)
+		}
+	}
+
+	// queued 只表示排進佇列, 充電機是否執行要看遙測
+	waitFor(t, "charging", func() bool { return can.Telemetry().Charging })
+}
+
+func TestCANClientOffline(t *testing.T) {
+	eb := eventbus.New()
+	// 沒有閘道器在 port 1 上, 一直連不上
+	can := NewCANClient(config.Station{ID: "01", IP: "127.0.0.1", Port: "1"}, nil, eb, eventbus.NewReqBus())
+	defer can.Close()
+
+	results := make(chan types.CommandResult, 1)
+	events.CommandResult.With("01").Subscribe(eb, func(r types.CommandResult) { results <- r })
+	events.QamsCommand.With("01").Publish(eb, types.QamsCommand{StationId: "01", Cmd: "stop"})
+
+	select {
+	case r := <-results:
+		if r.Ok || r.Status != types.CommandOffline {
+			t.Fatalf("got %+v, want status offline", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no result")
+	}
+}
+
+func TestCANClientSendAfterClose(t *testing.T) {
+	_, can, _ := newTestStation(t)
+	can.Close()
+
+	// writeLoop 已結束, 塞滿佇列之後也要回錯誤而不是卡住
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for i := 0; i <= cap(can.writeQueue) && err == nil; i++ {
+			err = can.SendCommand("read")
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("SendCommand after Close succeeded")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SendCommand blocked after Close")
+	}
+}
+
+// 熱更新重啟站點時, 舊 client 關閉後不能再發佈斷線, 否則會蓋掉新 client 的狀態
+func TestCANClientCloseDoesNotReportLost(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := ln.Addr().String()
+	ln.Close()
+
+	eb := eventbus.New()
+	var mu sync.Mutex
+	var states []types.ConnectionTcp
+	events.ConnectionTCP.With("01").SubscribeWith(eb, func(d types.ConnectionTcp) {
+		mu.Lock()
+		states = append(states, d)
+		mu.Unlock()
+	}, eventbus.SubscribeOptions{Delivery: eventbus.DeliverSync})
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(states)
+	}
+
+	st := config.Station{ID: "01", Transport: transport.KindTCPServer, Listen: listen}
+
+	// 還在等閘道器連進來時關閉: Open 被取消不是斷線
+	can := NewCANClient(st, nil, eb, eventbus.NewReqBus())
+	time.Sleep(100 * time.Millisecond)
+	can.Close()
+	time.Sleep(100 * time.Millisecond)
+	if n := count(); n != 0 {
+		t.Fatalf("closed client reported %+v", states)
+	}
+
+	// 連上後關閉: tcp_server 解除綁定也會讓讀取結束
+	can = NewCANClient(st, nil, eb, eventbus.NewReqBus())
+	gw, err := simulator.NewGateway(listen, []string{"01"}, simulator.Options{Transport: transport.KindTCPServer, PushInterval: 50 * time.Millisecond, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+	waitFor(t, "station 01 connected", func() bool { return count() == 1 })
+	can.Close()
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != 1 || !states[0].IsConnect {
+		t.Fatalf("events = %+v, want only the connect before Close", states)
+	}
+}
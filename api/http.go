@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"kenmec/jimmy/charge_core/alarm"
+	"kenmec/jimmy/charge_core/config"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	klog "kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/types"
+)
+
+// HTTPServer 提供維護人員查詢與確認告警的 API
+//
+//	GET  /alarms?station=01                   目前的告警, station 空白時列出全部
+//	GET  /alarms/history?station=01&limit=100  告警歷史
+//	POST /alarms/{station}/ack                body {"code": "over_temperature", "by": "alice"}, code 空白確認全部
+//	GET  /bus/stats                            event bus / request bus 的 handler panic 次數
+type HTTPServer struct {
+	srv    *http.Server
+	alarms *alarm.Manager
+	eb     *eventbus.EventBus
+	reqEb  *eventbus.RequestResponseBus
+}
+
+func NewHTTPServer(cfg config.HTTP, alarms *alarm.Manager, eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus) (*HTTPServer, error) {
+	s := &HTTPServer{alarms: alarms, eb: eb, reqEb: reqEb}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /alarms", s.getAlarms)
+	mux.HandleFunc("GET /alarms/history", s.getHistory)
+	mux.HandleFunc("POST /alarms/{station}/ack", s.postAck)
+	mux.HandleFunc("GET /bus/stats", s.getBusStats)
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	s.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	klog.Logger.Info(fmt.Sprintf("✅ HTTP API 監聽 %s", ln.Addr()))
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Logger.Error(fmt.Sprintf("HTTP server error: %v", err))
+		}
+	}()
+	return s, nil
+}
+
+func (s *HTTPServer) getAlarms(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.alarms.Active(r.URL.Query().Get("station")))
+}
+
+func (s *HTTPServer) getHistory(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a non-negative number"})
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, s.alarms.History(r.URL.Query().Get("station"), limit))
+}
+
+func (s *HTTPServer) postAck(w http.ResponseWriter, r *http.Request) {
+	ack := types.AlarmAck{StationId: r.PathValue("station")}
+	if r.ContentLength != 0 {
+		var body struct {
+			Code string `json:"code"`
+			By   string `json:"by"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		ack.Code, ack.By = body.Code, body.By
+	}
+	if ack.By == "" {
+		ack.By = "http " + r.RemoteAddr
+	}
+
+	n, err := s.alarms.Ack(ack)
+	switch {
+	case errors.Is(err, alarm.ErrNoAlarm):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, map[string]int{"acked": n})
+	}
+}
+
+func (s *HTTPServer) getBusStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]eventbus.Stats{
+		"events":   s.eb.Stats(),
+		"requests": s.reqEb.Stats(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Close 停止接受新請求, 最多等 2 秒讓進行中的請求完成
+func (s *HTTPServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	s.srv.Shutdown(ctx)
+}
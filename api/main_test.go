@@ -0,0 +1,15 @@
+package api
+
+import (
+	"os"
+	"testing"
+
+	klog "kenmec/jimmy/charge_core/log"
+
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	klog.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,384 @@
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	klog "kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/types"
+)
+
+// Modbus function code
+const (
+	mbReadCoils              = 0x01
+	mbReadDiscreteInputs     = 0x02
+	mbReadHoldingRegisters   = 0x03
+	mbReadInputRegisters     = 0x04
+	mbWriteSingleCoil        = 0x05
+	mbWriteSingleRegister    = 0x06
+	mbWriteMultipleCoils     = 0x0F
+	mbWriteMultipleRegisters = 0x10
+)
+
+// Modbus exception code
+const (
+	mbIllegalFunction    = 0x01
+	mbIllegalDataAddress = 0x02
+	mbIllegalDataValue   = 0x03
+	mbGatewayTargetFail  = 0x0B
+)
+
+// 每個 unit 的位址表, 詳見 readme 的 Modbus TCP 章節
+const (
+	mbDiscreteInputCount   = 3
+	mbCoilCount            = 3
+	mbHoldingRegisterCount = 1
+)
+
+// mbCommands 依 coil 位址 / holding register 0 的值 (減 1) 對應到 qams.command 的指令
+var mbCommands = []string{"start", "stop", "reset"}
+
+// ModbusServer 讓 PLC 以 Modbus TCP 讀站點狀態與下指令, 每個站點是一個 unit id
+type ModbusServer struct {
+	mu         sync.Mutex
+	cfg        config.Modbus
+	manager    *CANManager
+	eb         *eventbus.EventBus
+	units      map[byte]string // unit id -> station id
+	lastCmd    map[byte]uint16 // holding register 0 最後寫入的值
+	writeAllow map[string]bool
+	listener   net.Listener
+	conns      map[net.Conn]struct{} // 連線中的 PLC, Close 時一併斷開
+}
+
+func NewModbusServer(cfg config.Modbus, stations []config.Station, manager *CANManager, eb *eventbus.EventBus) (*ModbusServer, error) {
+	s := &ModbusServer{
+		cfg:        cfg,
+		manager:    manager,
+		eb:         eb,
+		lastCmd:    make(map[byte]uint16),
+		writeAllow: make(map[string]bool),
+		conns:      make(map[net.Conn]struct{}),
+	}
+
+	if err := s.SetStations(stations); err != nil {
+		return nil, err
+	}
+
+	for _, ip := range cfg.WriteAllow {
+		s.writeAllow[ip] = true
+	}
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	s.listener = ln
+
+	klog.Logger.Info(fmt.Sprintf("✅ Modbus TCP 監聽 %s", ln.Addr()))
+	go s.acceptLoop()
+	return s, nil
+}
+
+// SetStations 重建 unit id 對照表, 有衝突時回傳錯誤並保留原本的對照表
+func (s *ModbusServer) SetStations(stations []config.Station) error {
+	units := make(map[byte]string)
+	for _, st := range stations {
+		unit, err := st.ModbusUnitId()
+		if err != nil {
+			return fmt.Errorf("station %s: %w", st.ID, err)
+		}
+		if other, ok := units[unit]; ok {
+			return fmt.Errorf("modbus unit id %d used by station %s and %s", unit, other, st.ID)
+		}
+		units[unit] = st.ID
+	}
+
+	s.mu.Lock()
+	s.units = units
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *ModbusServer) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			klog.Logger.Error(fmt.Sprintf("Modbus accept error: %v", err))
+			time.Sleep(time.Second)
+			continue
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *ModbusServer) serve(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	canWrite := s.cfg.Writable && (len(s.writeAllow) == 0 || s.writeAllow[host])
+
+	klog.Logger.Info(fmt.Sprintf("Modbus client %s 已連線 (writable: %v)", conn.RemoteAddr(), canWrite))
+
+	header := make([]byte, 7)
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+
+		// MBAP: transaction id(2) protocol id(2) length(2) unit id(1)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if !errors.Is(err, io.EOF) {
+				klog.Logger.Warn(fmt.Sprintf("Modbus client %s: %v", conn.RemoteAddr(), err))
+			}
+			return
+		}
+
+		length := binary.BigEndian.Uint16(header[4:6])
+		if binary.BigEndian.Uint16(header[2:4]) != 0 || length < 2 || length > 254 {
+			klog.Logger.Warn(fmt.Sprintf("Modbus client %s 送了無效的 MBAP header", conn.RemoteAddr()))
+			return
+		}
+
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		res := s.handle(header[6], pdu, canWrite)
+
+		out := make([]byte, 7, 7+len(res))
+		copy(out, header[:4])
+		binary.BigEndian.PutUint16(out[4:6], uint16(len(res)+1))
+		out[6] = header[6]
+		out = append(out, res...)
+
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+func mbException(fc, code byte) []byte {
+	return []byte{fc | 0x80, code}
+}
+
+// handle 處理一個 PDU 並回傳回應的 PDU
+func (s *ModbusServer) handle(unit byte, pdu []byte, canWrite bool) []byte {
+	fc := pdu[0]
+
+	s.mu.Lock()
+	stationId, ok := s.units[unit]
+	s.mu.Unlock()
+	if !ok {
+		return mbException(fc, mbGatewayTargetFail)
+	}
+
+	if len(pdu) < 5 {
+		return mbException(fc, mbIllegalDataValue)
+	}
+	addr := binary.BigEndian.Uint16(pdu[1:3])
+	value := binary.BigEndian.Uint16(pdu[3:5])
+
+	switch fc {
+	case mbReadCoils, mbReadDiscreteInputs:
+		limit := mbCoilCount
+		var bits []bool
+		if fc == mbReadDiscreteInputs {
+			limit = mbDiscreteInputCount
+			bits = s.discreteInputs(stationId)
+		} else {
+			bits = make([]bool, mbCoilCount) // coil 是觸發用, 讀取永遠為 0
+		}
+		if value < 1 || int(addr)+int(value) > limit {
+			return mbException(fc, mbIllegalDataAddress)
+		}
+
+		res := []byte{fc, byte((value + 7) / 8)}
+		res = append(res, make([]byte, res[1])...)
+		for i := 0; i < int(value); i++ {
+			if bits[int(addr)+i] {
+				res[2+i/8] |= 1 << (i % 8)
+			}
+		}
+		return res
+
+	case mbReadHoldingRegisters, mbReadInputRegisters:
+		var regs []uint16
+		if fc == mbReadInputRegisters {
+			regs = s.inputRegisters(stationId)
+		} else {
+			s.mu.Lock()
+			regs = []uint16{s.lastCmd[unit]}
+			s.mu.Unlock()
+		}
+		if value < 1 || int(addr)+int(value) > len(regs) {
+			return mbException(fc, mbIllegalDataAddress)
+		}
+
+		res := []byte{fc, byte(value * 2)}
+		for _, r := range regs[addr : addr+value] {
+			res = binary.BigEndian.AppendUint16(res, r)
+		}
+		return res
+
+	case mbWriteSingleCoil:
+		if !canWrite {
+			return mbException(fc, mbIllegalFunction)
+		}
+		if int(addr) >= mbCoilCount {
+			return mbException(fc, mbIllegalDataAddress)
+		}
+		if value != 0xFF00 && value != 0x0000 {
+			return mbException(fc, mbIllegalDataValue)
+		}
+		if value == 0xFF00 {
+			s.command(stationId, mbCommands[addr])
+		}
+		return pdu[:5]
+
+	case mbWriteMultipleCoils:
+		if !canWrite {
+			return mbException(fc, mbIllegalFunction)
+		}
+		if value < 1 || int(addr)+int(value) > mbCoilCount {
+			return mbException(fc, mbIllegalDataAddress)
+		}
+		// byte count 要剛好放得下 value 個 coil, PDU 也要有那麼多 bytes
+		if len(pdu) < 6 || int(pdu[5]) != int(value+7)/8 || len(pdu) < 6+int(pdu[5]) {
+			return mbException(fc, mbIllegalDataValue)
+		}
+		for i := 0; i < int(value); i++ {
+			if pdu[6+i/8]&(1<<(i%8)) != 0 {
+				s.command(stationId, mbCommands[int(addr)+i])
+			}
+		}
+		return pdu[:5]
+
+	case mbWriteSingleRegister:
+		if !canWrite {
+			return mbException(fc, mbIllegalFunction)
+		}
+		if int(addr) >= mbHoldingRegisterCount {
+			return mbException(fc, mbIllegalDataAddress)
+		}
+		if value < 1 || int(value) > len(mbCommands) {
+			return mbException(fc, mbIllegalDataValue)
+		}
+		s.mu.Lock()
+		s.lastCmd[unit] = value
+		s.mu.Unlock()
+		s.command(stationId, mbCommands[value-1])
+		return pdu[:5]
+
+	case mbWriteMultipleRegisters:
+		if !canWrite {
+			return mbException(fc, mbIllegalFunction)
+		}
+		if value != 1 || int(addr) >= mbHoldingRegisterCount {
+			return mbException(fc, mbIllegalDataAddress)
+		}
+		if len(pdu) < 8 || pdu[5] != 2 {
+			return mbException(fc, mbIllegalDataValue)
+		}
+		cmd := binary.BigEndian.Uint16(pdu[6:8])
+		if cmd < 1 || int(cmd) > len(mbCommands) {
+			return mbException(fc, mbIllegalDataValue)
+		}
+		s.mu.Lock()
+		s.lastCmd[unit] = cmd
+		s.mu.Unlock()
+		s.command(stationId, mbCommands[cmd-1])
+		return pdu[:5]
+
+	default:
+		return mbException(fc, mbIllegalFunction)
+	}
+}
+
+// command 跟 MQTT 一樣走 qams.command
+func (s *ModbusServer) command(stationId, cmd string) {
+	logger := klog.Logger
+	if c, ok := s.manager.Get(stationId); ok {
+		logger = c.Logger()
+	}
+	logger.Info(fmt.Sprintf("📩 Modbus 收到給 [%s] 的命令: %s", stationId, cmd))
+
+	events.QamsCommand.With(stationId).Publish(s.eb, types.QamsCommand{
+		StationId: stationId,
+		Cmd:       cmd,
+	})
+}
+
+func (s *ModbusServer) stationState(stationId string) (bool, types.ChargerTelemetry) {
+	c, ok := s.manager.Get(stationId)
+	if !ok {
+		return false, types.ChargerTelemetry{StationId: stationId}
+	}
+	return c.IsConnected(), c.Telemetry()
+}
+
+func (s *ModbusServer) discreteInputs(stationId string) []bool {
+	isConnect, t := s.stationState(stationId)
+	return []bool{isConnect, t.Charging, t.FaultBits != 0}
+}
+
+func (s *ModbusServer) inputRegisters(stationId string) []uint16 {
+	isConnect, t := s.stationState(stationId)
+
+	boolReg := func(b bool) uint16 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	age := uint16(0xFFFF)
+	if !t.Timestamp.IsZero() {
+		if sec := time.Since(t.Timestamp).Seconds(); sec < 0xFFFF {
+			age = uint16(sec)
+		}
+	}
+
+	energy := uint32(t.EnergyWh)
+
+	return []uint16{
+		boolReg(isConnect),
+		boolReg(t.Charging),
+		uint16(t.Voltage * 10),
+		uint16(t.Current * 10),
+		uint16(int16(t.Temperature)),
+		uint16(t.FaultBits),
+		uint16(energy >> 16),
+		uint16(energy),
+		age,
+	}
+}
+
+func (s *ModbusServer) Close() {
+	s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
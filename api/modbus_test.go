@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+	"testing"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/types"
+)
+
+// newTestModbus 建立只有 unit 1 (站點 01) 的 ModbusServer, 不開 listener;
+// 回傳的函式取出目前為止發佈的指令
+func newTestModbus(t *testing.T) (*ModbusServer, func() []string) {
+	t.Helper()
+
+	eb := eventbus.New()
+	var mu sync.Mutex
+	var cmds []string
+	events.QamsCommand.SubscribeWith(eb, func(cmd types.QamsCommand) {
+		mu.Lock()
+		cmds = append(cmds, cmd.StationId+":"+cmd.Cmd)
+		mu.Unlock()
+	}, eventbus.SubscribeOptions{Delivery: eventbus.DeliverSync})
+
+	s := &ModbusServer{
+		manager: NewCANManager(config.Capture{}),
+		eb:      eb,
+		lastCmd: make(map[byte]uint16),
+	}
+	if err := s.SetStations([]config.Station{{ID: "01"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		out := cmds
+		cmds = nil
+		return out
+	}
+}
+
+func TestModbusHandleExceptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		unit     byte
+		pdu      []byte
+		canWrite bool
+		want     []byte
+	}{
+		{"unknown unit", 9, []byte{0x03, 0, 0, 0, 1}, false, []byte{0x83, mbGatewayTargetFail}},
+		{"short pdu", 1, []byte{0x03}, false, []byte{0x83, mbIllegalDataValue}},
+		{"short read", 1, []byte{0x04, 0, 0, 0}, false, []byte{0x84, mbIllegalDataValue}},
+		{"unknown function", 1, []byte{0x2b, 0, 0, 0, 0}, false, []byte{0xab, mbIllegalFunction}},
+		{"read zero coils", 1, []byte{0x01, 0, 0, 0, 0}, false, []byte{0x81, mbIllegalDataAddress}},
+		{"read past coils", 1, []byte{0x01, 0, 2, 0, 2}, false, []byte{0x81, mbIllegalDataAddress}},
+		{"read past input registers", 1, []byte{0x04, 0, 0, 0, 10}, false, []byte{0x84, mbIllegalDataAddress}},
+		{"oversized read", 1, []byte{0x04, 0, 0, 0xff, 0xff}, false, []byte{0x84, mbIllegalDataAddress}},
+		{"write not allowed", 1, []byte{0x05, 0, 0, 0xff, 0}, false, []byte{0x85, mbIllegalFunction}},
+		{"single coil bad value", 1, []byte{0x05, 0, 0, 0x12, 0x34}, true, []byte{0x85, mbIllegalDataValue}},
+		{"single coil bad address", 1, []byte{0x05, 0, 3, 0xff, 0}, true, []byte{0x85, mbIllegalDataAddress}},
+		{"multiple coils without byte count", 1, []byte{0x0f, 0, 0, 0, 1}, true, []byte{0x8f, mbIllegalDataValue}},
+		{"multiple coils zero byte count", 1, []byte{0x0f, 0, 0, 0, 1, 0}, true, []byte{0x8f, mbIllegalDataValue}},
+		{"multiple coils short data", 1, []byte{0x0f, 0, 0, 0, 3, 1}, true, []byte{0x8f, mbIllegalDataValue}},
+		{"multiple coils wrong byte count", 1, []byte{0x0f, 0, 0, 0, 3, 2, 1, 0}, true, []byte{0x8f, mbIllegalDataValue}},
+		{"multiple coils past end", 1, []byte{0x0f, 0, 1, 0, 3, 1, 7}, true, []byte{0x8f, mbIllegalDataAddress}},
+		{"single register bad command", 1, []byte{0x06, 0, 0, 0, 9}, true, []byte{0x86, mbIllegalDataValue}},
+		{"single register bad address", 1, []byte{0x06, 0, 1, 0, 1}, true, []byte{0x86, mbIllegalDataAddress}},
+		{"multiple registers short", 1, []byte{0x10, 0, 0, 0, 1, 2, 0}, true, []byte{0x90, mbIllegalDataValue}},
+		{"multiple registers wrong byte count", 1, []byte{0x10, 0, 0, 0, 1, 4, 0, 1, 0, 0}, true, []byte{0x90, mbIllegalDataValue}},
+		{"multiple registers count", 1, []byte{0x10, 0, 0, 0, 2, 4, 0, 1, 0, 1}, true, []byte{0x90, mbIllegalDataAddress}},
+		{"multiple registers bad command", 1, []byte{0x10, 0, 0, 0, 1, 2, 0, 0}, true, []byte{0x90, mbIllegalDataValue}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, published := newTestModbus(t)
+			got := s.handle(tt.unit, tt.pdu, tt.canWrite)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("handle(% x) = % x, want % x", tt.pdu, got, tt.want)
+			}
+			if cmds := published(); len(cmds) != 0 {
+				t.Errorf("published %v on an exception", cmds)
+			}
+		})
+	}
+}
+
+func TestModbusHandleReads(t *testing.T) {
+	s, _ := newTestModbus(t)
+
+	// 站點沒有連線: 全部為 0, 距上次遙測 65535
+	got := s.handle(1, []byte{0x04, 0, 0, 0, 9}, false)
+	want := []byte{0x04, 18, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+	if !bytes.Equal(got, want) {
+		t.Errorf("input registers = % x, want % x", got, want)
+	}
+
+	if got := s.handle(1, []byte{0x02, 0, 0, 0, 3}, false); !bytes.Equal(got, []byte{0x02, 1, 0}) {
+		t.Errorf("discrete inputs = % x", got)
+	}
+	if got := s.handle(1, []byte{0x01, 0, 0, 0, 3}, false); !bytes.Equal(got, []byte{0x01, 1, 0}) {
+		t.Errorf("coils = % x", got)
+	}
+}
+
+func TestModbusHandleWrites(t *testing.T) {
+	s, published := newTestModbus(t)
+
+	tests := []struct {
+		pdu  []byte
+		want []string
+	}{
+		{[]byte{0x05, 0, 0, 0xff, 0}, []string{"01:start"}},
+		{[]byte{0x05, 0, 1, 0, 0}, nil},
+		{[]byte{0x0f, 0, 0, 0, 3, 1, 0b110}, []string{"01:stop", "01:reset"}},
+		{[]byte{0x06, 0, 0, 0, 2}, []string{"01:stop"}},
+		{[]byte{0x10, 0, 0, 0, 1, 2, 0, 3}, []string{"01:reset"}},
+	}
+	for _, tt := range tests {
+		got := s.handle(1, tt.pdu, true)
+		if !bytes.Equal(got, tt.pdu[:5]) {
+			t.Errorf("handle(% x) = % x, want echo", tt.pdu, got)
+		}
+		if cmds := published(); !slices.Equal(cmds, tt.want) {
+			t.Errorf("handle(% x) published %v, want %v", tt.pdu, cmds, tt.want)
+		}
+	}
+
+	if got := s.handle(1, []byte{0x03, 0, 0, 0, 1}, false); !bytes.Equal(got, []byte{0x03, 2, 0, 3}) {
+		t.Errorf("holding register = % x, want last command 3", got)
+	}
+}
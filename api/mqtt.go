@@ -1,12 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
 	eventbus "kenmec/jimmy/charge_core/infra"
 	klog "kenmec/jimmy/charge_core/log"
 	"kenmec/jimmy/charge_core/types"
@@ -15,10 +20,15 @@ import (
 )
 
 type MQTT_Client struct {
-	client  mqtt.Client
-	configs MQTT_Config
-	eb      *eventbus.EventBus
-	reqEb   *eventbus.RequestResponseBus
+	mu       sync.Mutex
+	client   mqtt.Client
+	configs  MQTT_Config
+	stations []config.Station          // 重新連線時要補發狀態的站點, 設定熱更新時會換掉
+	subs     map[string]map[string]int // 站號 -> event -> event bus 訂閱 id, Close 時取消
+	quit     chan struct{}
+	lastBeat atomic.Int64 // heartBeat 最後一次執行的時間 (UnixNano), watchdog 用
+	eb       *eventbus.EventBus
+	reqEb    *eventbus.RequestResponseBus
 }
 
 type MQTT_Config struct {
@@ -28,19 +38,45 @@ type MQTT_Config struct {
 	user     string
 	password string
 
+	heartbeatInterval time.Duration
+
 	subscribeTopic []string
 }
 
-func NewMQTTClient(eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus, cfg *config.Config) *MQTT_Client {
+// NewMQTTClient 連線到 broker, 連不上時每 3 秒重試, 直到連上或 ctx 結束才返回;
+// ctx 結束時 client 照樣回傳, 由 Close 停止重試
+func NewMQTTClient(ctx context.Context, eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus, cfg *config.Config) *MQTT_Client {
 
 	configs := MQTT_Config{
-		broker:         "tcp://localhost:1883",
-		clientID:       fmt.Sprintf("go_charger_%d", time.Now().UnixNano()),
-		user:           "admin",
-		password:       "admin",
-		subscribeTopic: []string{"charge_station/+/command"},
+		broker:            "tcp://localhost:1883",
+		clientID:          fmt.Sprintf("go_charger_%d", time.Now().UnixNano()),
+		user:              "admin",
+		password:          "admin",
+		heartbeatInterval: 6 * time.Second,
+		subscribeTopic:    []string{"charge_station/+/command", "charge_station/+/alarms/ack"},
+	}
+	if cfg.MQTT.Broker != "" {
+		configs.broker = cfg.MQTT.Broker
+	}
+	if cfg.MQTT.ClientID != "" {
+		configs.clientID = cfg.MQTT.ClientID
+	}
+	if cfg.MQTT.User != "" {
+		configs.user = cfg.MQTT.User
+	}
+	if cfg.MQTT.Password != "" {
+		configs.password = cfg.MQTT.Password
+	}
+	if cfg.MQTT.HeartbeatInterval > 0 {
+		configs.heartbeatInterval = time.Duration(cfg.MQTT.HeartbeatInterval) * time.Second
+	}
+	m := &MQTT_Client{
+		eb:      eb,
+		reqEb:   reqEb,
+		configs: configs,
+		subs:    make(map[string]map[string]int),
+		quit:    make(chan struct{}),
 	}
-	m := &MQTT_Client{eb: eb, reqEb: reqEb, configs: configs}
 
 	opts := mqtt.NewClientOptions()
 
@@ -60,8 +96,8 @@ func NewMQTTClient(eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus, cf
 	opts.SetOnConnectHandler(func(cli mqtt.Client) {
 		klog.Logger.Info("🔌 MQTT 已連線 / 已重新連線成功")
 
-		for _, v := range cfg.Stations {
-			reqName := "tcp." + v.ID + ".status"
+		for _, v := range m.currentStations() {
+			reqName := events.TCPStatus(v.ID)
 
 			// Check if handler exists before requesting
 			if !reqEb.HasHandler(reqName) {
@@ -70,6 +106,9 @@ func NewMQTTClient(eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus, cf
 			}
 
 			response, err := reqEb.Request(reqName, types.ReqTCPStatus{})
+			if err == nil {
+				err = response.Error
+			}
 			if err != nil {
 				klog.Logger.Error(fmt.Sprintf("❌ Failed to get TCP status: %v", err))
 				continue
@@ -90,19 +129,41 @@ func NewMQTTClient(eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus, cf
 	m.client = mqtt.NewClient(opts)
 
 	token := m.client.Connect()
-	token.Wait()
+	select {
+	case <-token.Done():
+		if token.Error() != nil {
+			klog.Logger.Error(fmt.Sprintf("❌ 連線失敗: %v", token.Error()))
+		} else {
+			klog.Logger.Info("✅ 成功連線到 MQTT Broker")
+		}
+	case <-ctx.Done():
+		klog.Logger.Warn("⚠️ 尚未連上 MQTT Broker, 停止等待")
+	}
 
-	if token.Error() != nil {
-		klog.Logger.Error(fmt.Sprintf("❌ 連線失敗: %v", token.Error()))
+	m.SetStations(cfg.Stations)
+	go m.heartBeat()
+	return m
+}
 
-	} else {
-		klog.Logger.Info("✅ 成功連線到 MQTT Broker")
+// SetStations 更新重新連線時要補發狀態的站點, 並訂閱新站點的事件。
+// 移除的站點保留訂閱, 它停止時的離線狀態與清空的告警清單仍要送到 broker;
+// 設定熱更新時要在站點啟動前呼叫, 才不會漏掉新站點第一次的連線狀態
+func (m *MQTT_Client) SetStations(stations []config.Station) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	for _, st := range stations {
+		if m.subs[st.ID] == nil {
+			m.subStation(st.ID)
+		}
 	}
+	m.stations = stations
+}
 
-	go m.subEb()
-	go m.heartBeat()
-	return m
+func (m *MQTT_Client) currentStations() []config.Station {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stations
 }
 
 func (m *MQTT_Client) Subscribe(topic string) {
@@ -122,9 +183,14 @@ func (m *MQTT_Client) Subscribe(topic string) {
 
 			stationId := parts[1] // 第二段就是 stationId: 01, 02, ...
 
+			if strings.HasSuffix(topic, "/alarms/ack") {
+				m.onAlarmAck(stationId, ms.Payload())
+				return
+			}
+
 			klog.Logger.Info(fmt.Sprintf("📩 MQTT 收到給 [%s] 的命令: %s", stationId, payload))
 
-			m.eb.Publish("qams.command", types.QamsCommand{
+			events.QamsCommand.With(stationId).Publish(m.eb, types.QamsCommand{
 				StationId: stationId,
 				Cmd:       payload,
 			})
@@ -142,34 +208,81 @@ func (m *MQTT_Client) Subscribe(topic string) {
 
 }
 
-func (m *MQTT_Client) subEb() {
-	m.eb.Subscribe("connection.tcp", func(data interface{}) {
-		d := data.(types.ConnectionTcp)
+// stationQueue 每個站點、每種事件各自一個佇列, 依發佈順序逐一 publish 到 broker,
+// retained 的狀態不會被較舊的事件蓋掉。broker 卡住時丟掉最舊的 (後面的事件帶著最新狀態),
+// 發佈事件的 CANClient 讀取迴圈不會被擋住, 也不會丟掉其他站點的事件
+var stationQueue = eventbus.SubscribeOptions{Delivery: eventbus.DeliverOrdered, Buffer: 16, Overflow: eventbus.DropOldest}
+
+// publishTimeout 是等 broker 確認一次 publish 的上限, 超過就放棄這筆, 佇列才會往下走
+const publishTimeout = 5 * time.Second
 
-		pubData := types.ConnectionTcp{
+// subStation 訂閱一個站點要轉發到 broker 的事件; 呼叫時需持有 mu
+func (m *MQTT_Client) subStation(stationId string) {
+	subs := make(map[string]int)
+
+	conn := events.ConnectionTCP.With(stationId)
+	subs[conn.Name()] = conn.SubscribeWith(m.eb, func(d types.ConnectionTcp) {
+		klog.Logger.Info(fmt.Sprintf(`MQTT Send to QAMS is connect: %v, msg: %s`, d.IsConnect, d.Msg))
+		m.pubJSON("charge_station/"+d.StationId+"/connection/tcp", true, types.ConnectionTcp{
 			StationId: d.StationId,
 			IsConnect: d.IsConnect,
 			Msg:       d.Msg,
-		}
+		})
+	}, stationQueue)
 
-		payload, err := json.Marshal(pubData)
+	telemetry := events.ChargerTelemetry.With(stationId)
+	subs[telemetry.Name()] = telemetry.SubscribeWith(m.eb, func(d types.ChargerTelemetry) {
+		m.pubJSON("charge_station/"+d.StationId+"/telemetry", true, d)
+	}, stationQueue)
 
-		if err != nil {
-			klog.Logger.Error(fmt.Sprintf("❌ Failed to marshal JSON payload: %v", err))
-			return // Stop publish on error
-		}
+	result := events.CommandResult.With(stationId)
+	subs[result.Name()] = result.SubscribeWith(m.eb, func(d types.CommandResult) {
+		m.pubJSON("charge_station/"+d.StationId+"/command/result", false, d)
+	}, stationQueue)
 
-		klog.Logger.Info(fmt.Sprintf(`MQTT Send to QAMS is connect: %v, msg: %s`, d.IsConnect, d.Msg))
+	alarms := events.AlarmList.With(stationId)
+	subs[alarms.Name()] = alarms.SubscribeWith(m.eb, func(d types.AlarmList) {
+		m.pubJSON("charge_station/"+d.StationId+"/alarms", true, d)
+	}, stationQueue)
 
-		prefixTopic := "charge_station/" + d.StationId + "/connection/tcp"
+	m.subs[stationId] = subs
+}
 
-		token := m.client.Publish(prefixTopic, 0, true, payload)
+// onAlarmAck 接受 JSON {"code": "...", "by": "..."} 或直接是告警代碼, 空白表示確認全部
+func (m *MQTT_Client) onAlarmAck(stationId string, payload []byte) {
+	ack := types.AlarmAck{StationId: stationId}
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		ack.Code = strings.TrimSpace(string(payload))
+	}
+	ack.StationId = stationId
+	if ack.By == "" {
+		ack.By = "mqtt"
+	}
 
-		token.Wait()
-		if token.Error() != nil {
-			klog.Logger.Error(fmt.Sprintf("❌ Publish to topic [%s] failed: %v", "charge_station/connection/tcp", token.Error()))
-		}
-	})
+	klog.Logger.Info(fmt.Sprintf("📩 MQTT 收到 [%s] 告警確認: %q", stationId, ack.Code))
+	events.AlarmAck.With(stationId).Publish(m.eb, ack)
+}
+
+func (m *MQTT_Client) pubJSON(topic string, retained bool, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		klog.Logger.Error(fmt.Sprintf("❌ Failed to marshal JSON payload: %v", err))
+		return
+	}
+
+	m.publish(topic, retained, payload)
+}
+
+// publish 最多等 publishTimeout, 失敗或逾時只記 log
+func (m *MQTT_Client) publish(topic string, retained bool, payload interface{}) {
+	token := m.client.Publish(topic, 0, retained, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		klog.Logger.Error(fmt.Sprintf("❌ Publish to topic [%s] timed out after %v", topic, publishTimeout))
+		return
+	}
+	if token.Error() != nil {
+		klog.Logger.Error(fmt.Sprintf("❌ Publish to topic [%s] failed: %v", topic, token.Error()))
+	}
 }
 
 func (m *MQTT_Client) pubTpc(stationId string, isConnect bool) {
@@ -189,25 +302,56 @@ func (m *MQTT_Client) pubTpc(stationId string, isConnect bool) {
 
 	klog.Logger.Info(fmt.Sprintf(`MQTT Send to QAMS is connect: %v`, isConnect))
 
-	prefixTopic := "charge_station/" + stationId + "/connection/tcp"
-
-	token := m.client.Publish(prefixTopic, 0, true, payload)
-	token.Wait()
-	if token.Error() != nil {
-		klog.Logger.Error(fmt.Sprintf("❌ Publish to topic [%s] failed: %v", "charge_station/connection/tcp", token.Error()))
-	}
-
+	m.publish("charge_station/"+stationId+"/connection/tcp", true, payload)
 }
 
 func (m *MQTT_Client) heartBeat() {
+	ticker := time.NewTicker(m.configs.heartbeatInterval)
+	defer ticker.Stop()
+
 	i := 0
-	for range time.Tick(time.Second * 6) {
+	for {
+		m.lastBeat.Store(time.Now().UnixNano())
 
-		token := m.client.Publish("charge_station/heartbeat", 0, true, string(i))
-		token.Wait()
-		if token.Error() != nil {
-			klog.Logger.Error(fmt.Sprintf("❌ Publish to topic [%s] failed: %v", "charge_station/heartbeat", token.Error()))
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			return
 		}
+
+		m.publish("charge_station/heartbeat", true, strconv.Itoa(i))
 		i++
 	}
 }
+
+// LastHeartbeat 回傳心跳迴圈最後一次執行的時間與心跳間隔, 卡在 publish 時不會更新
+func (m *MQTT_Client) LastHeartbeat() (time.Time, time.Duration) {
+	return time.Unix(0, m.lastBeat.Load()), m.configs.heartbeatInterval
+}
+
+// Close 停止心跳與 event bus 訂閱, 把 stationIds 發佈為離線後斷開 broker
+func (m *MQTT_Client) Close(stationIds []string) {
+	close(m.quit)
+	m.mu.Lock()
+	for _, subs := range m.subs {
+		for event, id := range subs {
+			m.eb.Unsubscribe(event, id)
+		}
+	}
+	m.mu.Unlock()
+
+	// 重試連線中 IsConnected 也是 true, 這時 publish 只會等到逾時
+	if !m.client.IsConnectionOpen() {
+		m.client.Disconnect(0) // 停止重試
+		return
+	}
+	for _, id := range stationIds {
+		m.pubJSON("charge_station/"+id+"/connection/tcp", true, types.ConnectionTcp{
+			StationId: id,
+			IsConnect: false,
+			Msg:       "service stopped",
+		})
+	}
+	m.client.Disconnect(250)
+	klog.Logger.Info("🔌 MQTT 已斷線")
+}
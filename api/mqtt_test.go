@@ -0,0 +1,123 @@
+package api
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/types"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// stallToken 在 release 關閉前不會完成, 模擬卡住的 broker
+type stallToken struct {
+	mqtt.Token
+	release chan struct{}
+}
+
+func (t stallToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-t.release:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (t stallToken) Error() error { return nil }
+
+// stallClient 讓 stalled 站點的 publish 卡住, 其他站點的 publish 記錄下來立即完成
+type stallClient struct {
+	mqtt.Client
+	stalled string
+	release chan struct{}
+
+	mu        sync.Mutex
+	published []string
+}
+
+func (c *stallClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if strings.HasPrefix(topic, "charge_station/"+c.stalled+"/") {
+		return stallToken{release: c.release}
+	}
+	c.mu.Lock()
+	c.published = append(c.published, topic)
+	c.mu.Unlock()
+	return stallToken{release: closed}
+}
+
+func (c *stallClient) IsConnected() bool      { return false }
+func (c *stallClient) IsConnectionOpen() bool { return false }
+func (c *stallClient) Disconnect(uint)        {}
+
+func (c *stallClient) count(topic string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, p := range c.published {
+		if p == topic {
+			n++
+		}
+	}
+	return n
+}
+
+var closed = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func TestMQTTStalledBrokerDoesNotBlockPublishers(t *testing.T) {
+	eb := eventbus.New()
+	client := &stallClient{stalled: "01", release: make(chan struct{})}
+	defer close(client.release)
+
+	m := &MQTT_Client{client: client, eb: eb, subs: make(map[string]map[string]int), quit: make(chan struct{})}
+	m.SetStations([]config.Station{{ID: "01"}, {ID: "02"}})
+	defer m.Close(nil)
+
+	// 遠超過佇列大小: 用 Block 時 CANClient 的讀取會卡在這裡
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10*eventbus.DefaultBuffer; i++ {
+			events.ChargerTelemetry.With("01").Publish(eb, types.ChargerTelemetry{StationId: "01"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("publishing telemetry blocked on the stalled broker")
+	}
+
+	// 其他站點照常送出
+	events.ChargerTelemetry.With("02").Publish(eb, types.ChargerTelemetry{StationId: "02"})
+	waitFor(t, "station 02 telemetry", func() bool {
+		return client.count("charge_station/02/telemetry") == 1
+	})
+}
+
+func TestMQTTSetStationsSubscribesNewStations(t *testing.T) {
+	eb := eventbus.New()
+	client := &stallClient{release: make(chan struct{})}
+	defer close(client.release)
+
+	m := &MQTT_Client{client: client, eb: eb, subs: make(map[string]map[string]int), quit: make(chan struct{})}
+	m.SetStations([]config.Station{{ID: "01"}})
+	defer m.Close(nil)
+
+	m.SetStations([]config.Station{{ID: "02"}})
+	events.ConnectionTCP.With("02").Publish(eb, types.ConnectionTcp{StationId: "02", IsConnect: true})
+	// 移除的站點最後的狀態仍要送出
+	events.ConnectionTCP.With("01").Publish(eb, types.ConnectionTcp{StationId: "01", IsConnect: false})
+
+	waitFor(t, "connection state of both stations", func() bool {
+		return client.count("charge_station/02/connection/tcp") == 1 && client.count("charge_station/01/connection/tcp") == 1
+	})
+}
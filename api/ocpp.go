@@ -0,0 +1,349 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// OCPP-J message type id
+const (
+	ocppCall       = 2
+	ocppCallResult = 3
+	ocppCallError  = 4
+)
+
+const ocppCallTimeout = 30 * time.Second
+
+var errOCPPOffline = errors.New("ocpp central system not connected")
+
+// ocppHandler 處理 central system 送來的 CALL, 回傳值會包成 CALLRESULT
+type ocppHandler func(payload json.RawMessage) (interface{}, error)
+
+// ocppError 對應 CALLERROR 的 errorCode / errorDescription
+type ocppError struct {
+	Code        string
+	Description string
+}
+
+func (e *ocppError) Error() string {
+	return fmt.Sprintf("ocpp %s: %s", e.Code, e.Description)
+}
+
+type ocppReply struct {
+	payload json.RawMessage
+	err     error
+}
+
+// ocppConn 封裝 OCPP-J 在 websocket 上的 RPC framing (CALL / CALLRESULT / CALLERROR),
+// 1.6J 與之後的版本共用
+type ocppConn struct {
+	ws       *websocket.Conn
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	pending  map[string]chan ocppReply
+	handlers map[string]ocppHandler
+	done     chan struct{}
+	logger   *zap.Logger // 站點的 logger
+}
+
+func dialOCPP(ctx context.Context, cfg config.OCPP, subprotocol string, handlers map[string]ocppHandler, logger *zap.Logger) (*ocppConn, error) {
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     []string{subprotocol},
+	}
+
+	header, tlsCfg, err := ocppSecurity(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dialer.TLSClientConfig = tlsCfg
+
+	url := strings.TrimRight(cfg.URL, "/") + "/" + cfg.ChargePointID
+	ws, _, err := dialer.DialContext(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if ws.Subprotocol() != subprotocol {
+		ws.Close()
+		return nil, fmt.Errorf("central system did not accept subprotocol %s", subprotocol)
+	}
+
+	return &ocppConn{
+		ws:       ws,
+		pending:  make(map[string]chan ocppReply),
+		handlers: handlers,
+		done:     make(chan struct{}),
+		logger:   logger,
+	}, nil
+}
+
+// ocppSecurity 依 security profile 準備 basic auth header 與 TLS 設定
+//
+//	0 不驗證
+//	1 basic auth (ws)
+//	2 TLS + basic auth (wss)
+//	3 TLS + client 憑證 (wss)
+func ocppSecurity(cfg config.OCPP) (http.Header, *tls.Config, error) {
+	header := http.Header{}
+
+	if cfg.SecurityProfile == 1 || cfg.SecurityProfile == 2 {
+		token := base64.StdEncoding.EncodeToString([]byte(cfg.ChargePointID + ":" + cfg.Password))
+		header.Set("Authorization", "Basic "+token)
+	}
+
+	if cfg.SecurityProfile < 2 {
+		return header, nil, nil
+	}
+
+	if !strings.HasPrefix(cfg.URL, "wss://") {
+		return nil, nil, fmt.Errorf("security profile %d requires a wss:// url", cfg.SecurityProfile)
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificate found in %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.SecurityProfile == 3 {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return header, tlsCfg, nil
+}
+
+// call 送出 CALL 並等待 central system 回覆, res 為 nil 時忽略回覆內容
+func (c *ocppConn) call(ctx context.Context, action string, req, res interface{}) error {
+	id := uuid.NewString()
+	ch := make(chan ocppReply, 1)
+
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write([]interface{}{ocppCall, id, action, req}); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ocppCallTimeout)
+	defer cancel()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return r.err
+		}
+		if res == nil {
+			return nil
+		}
+		return json.Unmarshal(r.payload, res)
+	case <-c.done:
+		return errors.New("ocpp connection closed")
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", action, ctx.Err())
+	}
+}
+
+func (c *ocppConn) write(msg []interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.ws.WriteJSON(msg)
+}
+
+// readLoop 直到連線中斷才回傳
+func (c *ocppConn) readLoop() error {
+	defer close(c.done)
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var msg []json.RawMessage
+		if err := json.Unmarshal(data, &msg); err != nil || len(msg) < 3 {
+			c.logger.Warn(fmt.Sprintf("OCPP 無法解析的訊息: %s", data))
+			continue
+		}
+
+		var msgType int
+		var id string
+		json.Unmarshal(msg[0], &msgType)
+		json.Unmarshal(msg[1], &id)
+
+		switch msgType {
+		case ocppCall:
+			if len(msg) < 4 {
+				continue
+			}
+			var action string
+			json.Unmarshal(msg[2], &action)
+			go c.handleCall(id, action, msg[3])
+
+		case ocppCallResult:
+			c.resolve(id, ocppReply{payload: msg[2]})
+
+		case ocppCallError:
+			e := &ocppError{}
+			json.Unmarshal(msg[2], &e.Code)
+			if len(msg) > 3 {
+				json.Unmarshal(msg[3], &e.Description)
+			}
+			c.resolve(id, ocppReply{err: e})
+		}
+	}
+}
+
+func (c *ocppConn) resolve(id string, r ocppReply) {
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	c.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	select {
+	case ch <- r:
+	default:
+	}
+}
+
+func (c *ocppConn) handleCall(id, action string, payload json.RawMessage) {
+	h, ok := c.handlers[action]
+	if !ok {
+		c.write([]interface{}{ocppCallError, id, "NotImplemented", "unsupported action " + action, struct{}{}})
+		return
+	}
+
+	res, err := h(payload)
+	if err != nil {
+		var oe *ocppError
+		if !errors.As(err, &oe) {
+			oe = &ocppError{Code: "InternalError", Description: err.Error()}
+		}
+		c.write([]interface{}{ocppCallError, id, oe.Code, oe.Description, struct{}{}})
+		return
+	}
+
+	if err := c.write([]interface{}{ocppCallResult, id, res}); err != nil {
+		c.logger.Error(fmt.Sprintf("OCPP 回覆 %s 失敗: %v", action, err))
+	}
+}
+
+func (c *ocppConn) Close() {
+	c.ws.Close()
+}
+
+// ocppTime 是 OCPP 規定的 UTC RFC3339 時間格式
+func ocppTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ocppSession 是各 OCPP 版本共用的充電 session, 對應 connector 上的一次交易
+type ocppSession struct {
+	id         string // 2.0.1 transactionId, 由 charge point 產生
+	remoteId   int    // 1.6J transactionId, 由 central system 配發
+	idTag      string
+	meterStart float64
+	startedAt  time.Time
+	seqNo      int    // 2.0.1 TransactionEvent 序號
+	charging   bool   // 遙測曾回報充電中, 之後停下來才算本地結束
+	stopping   bool   // 已送出 stop 指令, 交易由送指令的那一方結束
+	endReason  string // 1.6J 本地結束的原因, 由 notifyLoop 送出 StopTransaction
+}
+
+func newOCPPSession(idTag string) *ocppSession {
+	return &ocppSession{
+		id:        uuid.NewString(),
+		idTag:     idTag,
+		startedAt: time.Now(),
+	}
+}
+
+// flushOCPP 要 notifyLoop 馬上處理一次狀態變化, 等它處理完或 ctx 結束;
+// 在這之前標記的本地結束都會在這一次送出
+func flushOCPP(ctx context.Context, flush chan chan struct{}) {
+	done := make(chan struct{})
+	select {
+	case flush <- done:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+type connectorState int
+
+const (
+	connectorAvailable connectorState = iota
+	connectorOccupied
+	connectorFaulted
+	connectorUnavailable
+)
+
+// stationConnectorState 由 CAN 連線與遙測推出 connector 狀態, 各 OCPP 版本再轉成自己的列舉
+func stationConnectorState(can *CANClient, inSession bool) connectorState {
+	switch {
+	case !can.IsConnected():
+		return connectorUnavailable
+	case can.Telemetry().FaultBits != 0:
+		return connectorFaulted
+	case inSession:
+		return connectorOccupied
+	default:
+		return connectorAvailable
+	}
+}
+
+func applyOCPPDefaults(cfg config.OCPP, stationId string) config.OCPP {
+	if cfg.ChargePointID == "" {
+		cfg.ChargePointID = "CP" + stationId
+	}
+	if cfg.MeterInterval <= 0 {
+		cfg.MeterInterval = 60
+	}
+	if cfg.OfflineQueueSize <= 0 {
+		cfg.OfflineQueueSize = 1000
+	}
+	return cfg
+}
@@ -0,0 +1,512 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/types"
+
+	"go.uber.org/zap"
+)
+
+// OCPP16ChargePoint 把一個 CAN 站點以 OCPP 1.6J charge point 的身分接到 central system,
+// 站點只有一個 connector (connectorId 1)
+type OCPP16ChargePoint struct {
+	mu            sync.Mutex
+	cfg           config.OCPP
+	can           *CANClient
+	logger        *zap.Logger // 與 CAN 連線共用的站點 logger
+	eb            *eventbus.EventBus
+	ctx           context.Context
+	cancel        context.CancelFunc
+	conn          *ocppConn
+	heartbeat     time.Duration
+	meterInterval time.Duration
+	status        string // central system 已確認的 connector 狀態
+	tx            *ocppSession
+	changed       chan struct{}
+	flush         chan chan struct{} // EndTransaction 要求 notifyLoop 處理一次後回報
+	subs          [2]int             // station.<id>.connection / station.<id>.telemetry 的訂閱 id
+}
+
+type ocpp16IdTagInfo struct {
+	Status string `json:"status"`
+}
+
+type ocpp16SampledValue struct {
+	Value     string `json:"value"`
+	Measurand string `json:"measurand,omitempty"`
+	Unit      string `json:"unit,omitempty"`
+}
+
+type ocpp16MeterValue struct {
+	Timestamp    string               `json:"timestamp"`
+	SampledValue []ocpp16SampledValue `json:"sampledValue"`
+}
+
+func NewOCPP16ChargePoint(cfg config.OCPP, can *CANClient, eb *eventbus.EventBus) *OCPP16ChargePoint {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cfg = applyOCPPDefaults(cfg, can.StationId())
+
+	cp := &OCPP16ChargePoint{
+		cfg:           cfg,
+		can:           can,
+		logger:        can.Logger(),
+		eb:            eb,
+		ctx:           ctx,
+		cancel:        cancel,
+		heartbeat:     5 * time.Minute,
+		meterInterval: time.Duration(cfg.MeterInterval) * time.Second,
+		changed:       make(chan struct{}, 1),
+		flush:         make(chan chan struct{}),
+	}
+
+	cp.subEb()
+	go cp.run()
+	go cp.meterLoop()
+	go cp.notifyLoop()
+	return cp
+}
+
+func (cp *OCPP16ChargePoint) run() {
+	for {
+		conn, err := dialOCPP(cp.ctx, cp.cfg, "ocpp1.6", cp.handlers(), cp.logger)
+		if err != nil {
+			cp.logger.Error(fmt.Sprintf("OCPP %s 連線失敗: %v, Reconnect in 3 seconds...", cp.cfg.ChargePointID, err))
+			select {
+			case <-time.After(3 * time.Second):
+				continue
+			case <-cp.ctx.Done():
+				return
+			}
+		}
+
+		cp.logger.Info(fmt.Sprintf("🔌 OCPP %s 已連線到 %s", cp.cfg.ChargePointID, cp.cfg.URL))
+
+		sessionDone := make(chan struct{})
+		go cp.session(conn, sessionDone)
+
+		readErr := make(chan error, 1)
+		go func() { readErr <- conn.readLoop() }()
+
+		select {
+		case err := <-readErr:
+			cp.logger.Warn(fmt.Sprintf("⚠️ OCPP %s 斷線: %v", cp.cfg.ChargePointID, err))
+		case <-cp.ctx.Done():
+			conn.Close()
+		}
+
+		<-sessionDone
+
+		cp.mu.Lock()
+		cp.conn = nil
+		cp.mu.Unlock()
+
+		if cp.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// session 在連線期間送 BootNotification, 接著定期 Heartbeat
+func (cp *OCPP16ChargePoint) session(conn *ocppConn, done chan struct{}) {
+	defer close(done)
+
+	for {
+		var res struct {
+			Status      string `json:"status"`
+			CurrentTime string `json:"currentTime"`
+			Interval    int    `json:"interval"`
+		}
+		err := conn.call(cp.ctx, "BootNotification", map[string]string{
+			"chargePointVendor": cp.cfg.Vendor,
+			"chargePointModel":  cp.cfg.Model,
+		}, &res)
+		if err != nil {
+			cp.logger.Error(fmt.Sprintf("OCPP %s BootNotification 失敗: %v", cp.cfg.ChargePointID, err))
+			conn.Close()
+			return
+		}
+
+		interval := time.Duration(res.Interval) * time.Second
+		if res.Status == "Accepted" {
+			if interval > 0 {
+				cp.heartbeat = interval
+			}
+			break
+		}
+
+		// Pending / Rejected: 依 central system 給的間隔重送
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		select {
+		case <-time.After(interval):
+		case <-conn.done:
+			return
+		}
+	}
+
+	// Boot 被接受後才開始送其他訊息
+	cp.mu.Lock()
+	cp.conn = conn
+	cp.status = ""
+	cp.mu.Unlock()
+
+	cp.notify()
+
+	ticker := time.NewTicker(cp.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.call(cp.ctx, "Heartbeat", struct{}{}, nil); err != nil {
+				cp.logger.Error(fmt.Sprintf("OCPP %s Heartbeat 失敗: %v", cp.cfg.ChargePointID, err))
+			}
+			// 之前送失敗的 StatusNotification 至少每個 heartbeat 重送一次
+			cp.notify()
+		case <-conn.done:
+			return
+		}
+	}
+}
+
+func (cp *OCPP16ChargePoint) handlers() map[string]ocppHandler {
+	return map[string]ocppHandler{
+		"RemoteStartTransaction": cp.remoteStart,
+		"RemoteStopTransaction":  cp.remoteStop,
+	}
+}
+
+func (cp *OCPP16ChargePoint) remoteStart(payload json.RawMessage) (interface{}, error) {
+	var req struct {
+		ConnectorId int    `json:"connectorId"`
+		IdTag       string `json:"idTag"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &ocppError{Code: "FormationViolation", Description: err.Error()}
+	}
+
+	rejected := map[string]string{"status": "Rejected"}
+
+	if (req.ConnectorId != 0 && req.ConnectorId != 1) || !cp.can.IsConnected() {
+		return rejected, nil
+	}
+
+	// 先佔住 connector, StartTransaction 回來後再補上 transactionId
+	tx := newOCPPSession(req.IdTag)
+	cp.mu.Lock()
+	if cp.tx != nil {
+		cp.mu.Unlock()
+		return rejected, nil
+	}
+	cp.tx = tx
+	cp.mu.Unlock()
+
+	if err := cp.can.SendCommand("start"); err != nil {
+		cp.logger.Error(fmt.Sprintf("OCPP %s RemoteStart 送出 CAN 指令失敗: %v", cp.cfg.ChargePointID, err))
+		cp.mu.Lock()
+		cp.tx = nil
+		cp.mu.Unlock()
+		return rejected, nil
+	}
+
+	go cp.startTransaction(tx)
+	return map[string]string{"status": "Accepted"}, nil
+}
+
+func (cp *OCPP16ChargePoint) remoteStop(payload json.RawMessage) (interface{}, error) {
+	var req struct {
+		TransactionId int `json:"transactionId"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &ocppError{Code: "FormationViolation", Description: err.Error()}
+	}
+
+	cp.mu.Lock()
+	tx := cp.tx
+	matched := tx != nil && tx.remoteId != 0 && tx.remoteId == req.TransactionId
+	if matched {
+		// 遙測看到停止時不要再當成本地結束
+		tx.stopping = true
+	}
+	cp.mu.Unlock()
+
+	if !matched {
+		return map[string]string{"status": "Rejected"}, nil
+	}
+
+	if err := cp.can.SendCommand("stop"); err != nil {
+		cp.logger.Error(fmt.Sprintf("OCPP %s RemoteStop 送出 CAN 指令失敗: %v", cp.cfg.ChargePointID, err))
+		cp.mu.Lock()
+		tx.stopping = false
+		cp.mu.Unlock()
+		return map[string]string{"status": "Rejected"}, nil
+	}
+
+	go cp.stopTransaction(tx, "Remote")
+	return map[string]string{"status": "Accepted"}, nil
+}
+
+func (cp *OCPP16ChargePoint) startTransaction(tx *ocppSession) {
+	tx.meterStart = cp.can.Telemetry().EnergyWh
+
+	var res struct {
+		TransactionId int             `json:"transactionId"`
+		IdTagInfo     ocpp16IdTagInfo `json:"idTagInfo"`
+	}
+
+	err := errOCPPOffline
+	if conn := cp.currentConn(); conn != nil {
+		err = conn.call(cp.ctx, "StartTransaction", map[string]interface{}{
+			"connectorId": 1,
+			"idTag":       tx.idTag,
+			"meterStart":  int(tx.meterStart),
+			"timestamp":   ocppTime(time.Now()),
+		}, &res)
+	}
+	if err != nil {
+		cp.logger.Error(fmt.Sprintf("OCPP %s StartTransaction 失敗: %v, 停止充電", cp.cfg.ChargePointID, err))
+		cp.can.SendCommand("stop")
+		cp.mu.Lock()
+		cp.tx = nil
+		cp.mu.Unlock()
+		cp.notify()
+		return
+	}
+
+	accepted := res.IdTagInfo.Status == "Accepted"
+	cp.mu.Lock()
+	tx.remoteId = res.TransactionId
+	tx.stopping = !accepted
+	cp.mu.Unlock()
+
+	if !accepted {
+		cp.logger.Warn(fmt.Sprintf("OCPP %s idTag %s 未被接受 (%s), 停止充電", cp.cfg.ChargePointID, tx.idTag, res.IdTagInfo.Status))
+		cp.can.SendCommand("stop")
+		cp.stopTransaction(tx, "DeAuthorized")
+		return
+	}
+	cp.notify()
+}
+
+// stopTransaction 結束 tx 並送出 StopTransaction, tx 已由別處結束時不做事
+func (cp *OCPP16ChargePoint) stopTransaction(tx *ocppSession, reason string) {
+	cp.mu.Lock()
+	if cp.tx != tx {
+		cp.mu.Unlock()
+		return
+	}
+	cp.tx = nil
+	cp.mu.Unlock()
+
+	conn := cp.currentConn()
+	if conn != nil {
+		err := conn.call(cp.ctx, "StopTransaction", map[string]interface{}{
+			"transactionId": tx.remoteId,
+			"idTag":         tx.idTag,
+			"meterStop":     int(cp.can.Telemetry().EnergyWh),
+			"timestamp":     ocppTime(time.Now()),
+			"reason":        reason,
+		}, nil)
+		if err != nil {
+			cp.logger.Error(fmt.Sprintf("OCPP %s StopTransaction 失敗: %v", cp.cfg.ChargePointID, err))
+		}
+	}
+
+	cp.notify()
+}
+
+// meterLoop 在交易進行中依 meter_interval 送 MeterValues
+func (cp *OCPP16ChargePoint) meterLoop() {
+	ticker := time.NewTicker(cp.meterInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cp.mu.Lock()
+			var txId int
+			if cp.tx != nil {
+				txId = cp.tx.remoteId
+			}
+			conn := cp.conn
+			cp.mu.Unlock()
+
+			if txId == 0 || conn == nil {
+				continue
+			}
+
+			t := cp.can.Telemetry()
+			err := conn.call(cp.ctx, "MeterValues", map[string]interface{}{
+				"connectorId":   1,
+				"transactionId": txId,
+				"meterValue": []ocpp16MeterValue{{
+					Timestamp: ocppTime(time.Now()),
+					SampledValue: []ocpp16SampledValue{
+						{Value: fmt.Sprintf("%.0f", t.EnergyWh), Measurand: "Energy.Active.Import.Register", Unit: "Wh"},
+						{Value: fmt.Sprintf("%.1f", t.Voltage), Measurand: "Voltage", Unit: "V"},
+						{Value: fmt.Sprintf("%.1f", t.Current), Measurand: "Current.Import", Unit: "A"},
+						{Value: fmt.Sprintf("%d", t.Temperature), Measurand: "Temperature", Unit: "Celsius"},
+					},
+				}},
+			}, nil)
+			if err != nil {
+				cp.logger.Error(fmt.Sprintf("OCPP %s MeterValues 失敗: %v", cp.cfg.ChargePointID, err))
+			}
+
+		case <-cp.ctx.Done():
+			return
+		}
+	}
+}
+
+func (cp *OCPP16ChargePoint) subEb() {
+	stationId := cp.can.StationId()
+
+	// 依序處理, 本地結束與狀態變化才會照順序記下來。handler 只更新狀態, OCPP 呼叫交給 notifyLoop,
+	// central system 回應再慢也不會卡住 CANClient 的讀取
+	ordered := eventbus.SubscribeOptions{Delivery: eventbus.DeliverOrdered}
+
+	cp.subs[0] = events.ConnectionTCP.With(stationId).SubscribeWith(cp.eb, func(conn types.ConnectionTcp) {
+		// 閘道器斷線時充電機已不受控制, 不代表車端拔槍, 所以用 Other
+		if !conn.IsConnect {
+			cp.localStop("Other")
+		}
+		cp.notify()
+	}, ordered)
+
+	cp.subs[1] = events.ChargerTelemetry.With(stationId).SubscribeWith(cp.eb, func(t types.ChargerTelemetry) {
+		switch {
+		case t.FaultBits != 0:
+			cp.localStop("Other")
+		case !t.Charging:
+			cp.localStop("Local")
+		default:
+			cp.mu.Lock()
+			if cp.tx != nil {
+				cp.tx.charging = true
+			}
+			cp.mu.Unlock()
+		}
+		cp.notify()
+	}, ordered)
+}
+
+// localStop 記下不是由 central system 要求停止的交易, 例如 MQTT / Modbus 送 stop、充電機充滿或故障,
+// StopTransaction 由 notifyLoop 送出。剛 RemoteStart 還沒看到充電的交易只有斷線或故障時才結束
+func (cp *OCPP16ChargePoint) localStop(reason string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	tx := cp.tx
+	if tx != nil && !tx.stopping && (tx.charging || reason == "Other") {
+		tx.stopping = true
+		tx.endReason = reason
+	}
+}
+
+// notify 通知 notifyLoop 狀態有變化, 不會阻塞
+func (cp *OCPP16ChargePoint) notify() {
+	select {
+	case cp.changed <- struct{}{}:
+	default:
+	}
+}
+
+// notifyLoop 依序送出本地結束的 StopTransaction 與 StatusNotification
+func (cp *OCPP16ChargePoint) notifyLoop() {
+	for {
+		var done chan struct{}
+		select {
+		case <-cp.changed:
+		case done = <-cp.flush:
+		case <-cp.ctx.Done():
+			return
+		}
+
+		cp.mu.Lock()
+		tx := cp.tx
+		var reason string
+		if tx != nil {
+			reason = tx.endReason
+		}
+		cp.mu.Unlock()
+
+		if reason != "" {
+			cp.logger.Info(fmt.Sprintf("OCPP %s 充電已在本地結束 (%s), 送出 StopTransaction", cp.cfg.ChargePointID, reason))
+			cp.stopTransaction(tx, reason)
+		}
+		cp.updateStatus()
+		if done != nil {
+			close(done)
+		}
+	}
+}
+
+// EndTransaction 是 stop_policy 為 stop 時關機前呼叫, 結束進行中的交易並等 StopTransaction 送出或 ctx 結束
+func (cp *OCPP16ChargePoint) EndTransaction(ctx context.Context) {
+	cp.localStop("Other")
+	flushOCPP(ctx, cp.flush)
+}
+
+// updateStatus 由連線狀態與遙測推出 connector 狀態, 和 central system 已確認的不同才送 StatusNotification,
+// 送失敗時下次再送
+func (cp *OCPP16ChargePoint) updateStatus() {
+	cp.mu.Lock()
+	status, errorCode := "Available", "NoError"
+	switch stationConnectorState(cp.can, cp.tx != nil) {
+	case connectorUnavailable:
+		status = "Unavailable"
+	case connectorFaulted:
+		status, errorCode = "Faulted", "OtherError"
+	case connectorOccupied:
+		status = "Charging"
+	}
+
+	conn := cp.conn
+	if conn == nil || status == cp.status {
+		cp.mu.Unlock()
+		return
+	}
+	cp.mu.Unlock()
+
+	err := conn.call(cp.ctx, "StatusNotification", map[string]interface{}{
+		"connectorId": 1,
+		"errorCode":   errorCode,
+		"status":      status,
+		"timestamp":   ocppTime(time.Now()),
+	}, nil)
+	if err != nil {
+		cp.logger.Error(fmt.Sprintf("OCPP %s StatusNotification 失敗: %v", cp.cfg.ChargePointID, err))
+		return
+	}
+
+	cp.mu.Lock()
+	// 重連後 session 會清掉 status, 舊連線送的不算
+	if cp.conn == conn {
+		cp.status = status
+	}
+	cp.mu.Unlock()
+}
+
+func (cp *OCPP16ChargePoint) currentConn() *ocppConn {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.conn
+}
+
+func (cp *OCPP16ChargePoint) Close() {
+	cp.cancel()
+	stationId := cp.can.StationId()
+	events.ConnectionTCP.With(stationId).Unsubscribe(cp.eb, cp.subs[0])
+	events.ChargerTelemetry.With(stationId).Unsubscribe(cp.eb, cp.subs[1])
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/simulator"
+	"kenmec/jimmy/charge_core/types"
+)
+
+var ocpp16Replies = map[string]interface{}{
+	"BootNotification": map[string]interface{}{"status": "Accepted", "currentTime": ocppTime(time.Now()), "interval": 1},
+	"Heartbeat":        map[string]string{"currentTime": ocppTime(time.Now())},
+	"StartTransaction": map[string]interface{}{"transactionId": 42, "idTagInfo": map[string]string{"status": "Accepted"}},
+	"StopTransaction":  map[string]interface{}{"idTagInfo": map[string]string{"status": "Accepted"}},
+}
+
+type ocpp16Status struct {
+	ConnectorId int    `json:"connectorId"`
+	Status      string `json:"status"`
+	ErrorCode   string `json:"errorCode"`
+}
+
+type ocpp16Stop struct {
+	TransactionId int    `json:"transactionId"`
+	IdTag         string `json:"idTag"`
+	Reason        string `json:"reason"`
+}
+
+// expectStatus 等到 connector 回報指定的狀態
+func expectStatus(t *testing.T, csms *testCSMS, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var st ocpp16Status
+		csms.expect("StatusNotification", &st)
+		if st.Status == want {
+			return
+		}
+	}
+	t.Fatalf("connector never reported %s", want)
+}
+
+// startOCPP16 連上 CSMS 並由 RemoteStartTransaction 開始一筆充電中的交易
+func startOCPP16(t *testing.T) (*testCSMS, *simulator.Gateway, *OCPP16ChargePoint) {
+	t.Helper()
+
+	csms := newTestCSMS(t, "ocpp1.6", ocpp16Replies)
+	gw, can, eb := newTestStation(t)
+
+	cp := NewOCPP16ChargePoint(config.OCPP{URL: csms.URL(), Vendor: "kenmec", Model: "sim", MeterInterval: 1}, can, eb)
+	t.Cleanup(cp.Close)
+
+	var boot map[string]string
+	csms.expect("BootNotification", &boot)
+	if boot["chargePointVendor"] != "kenmec" || boot["chargePointModel"] != "sim" {
+		t.Fatalf("BootNotification = %v", boot)
+	}
+	expectStatus(t, csms, "Available")
+
+	var res map[string]string
+	json.Unmarshal(csms.call("RemoteStartTransaction", map[string]interface{}{"connectorId": 1, "idTag": "TAG1"}), &res)
+	if res["status"] != "Accepted" {
+		t.Fatalf("RemoteStartTransaction = %v", res)
+	}
+
+	var start map[string]interface{}
+	csms.expect("StartTransaction", &start)
+	if start["idTag"] != "TAG1" || start["connectorId"] != 1.0 {
+		t.Fatalf("StartTransaction = %v", start)
+	}
+	expectStatus(t, csms, "Charging")
+	waitFor(t, "charging", func() bool { return can.Telemetry().Charging })
+
+	return csms, gw, cp
+}
+
+func TestOCPP16BootAndHeartbeat(t *testing.T) {
+	csms := newTestCSMS(t, "ocpp1.6", ocpp16Replies)
+	_, can, eb := newTestStation(t)
+
+	cp := NewOCPP16ChargePoint(config.OCPP{URL: csms.URL()}, can, eb)
+	defer cp.Close()
+
+	csms.expect("BootNotification", nil)
+	expectStatus(t, csms, "Available")
+	// BootNotification 回的 interval 是 1 秒
+	csms.expect("Heartbeat", nil)
+
+	var res map[string]string
+	json.Unmarshal(csms.call("RemoteStopTransaction", map[string]int{"transactionId": 7}), &res)
+	if res["status"] != "Rejected" {
+		t.Fatalf("RemoteStopTransaction without transaction = %v", res)
+	}
+}
+
+func TestOCPP16RemoteStartStop(t *testing.T) {
+	csms, _, _ := startOCPP16(t)
+
+	var res map[string]string
+	json.Unmarshal(csms.call("RemoteStartTransaction", map[string]interface{}{"connectorId": 1, "idTag": "TAG2"}), &res)
+	if res["status"] != "Rejected" {
+		t.Fatalf("second RemoteStartTransaction = %v", res)
+	}
+
+	// 交易中依 meter_interval 送 MeterValues
+	var meter map[string]interface{}
+	csms.expect("MeterValues", &meter)
+	if meter["transactionId"] != 42.0 {
+		t.Fatalf("MeterValues = %v", meter)
+	}
+
+	json.Unmarshal(csms.call("RemoteStopTransaction", map[string]int{"transactionId": 42}), &res)
+	if res["status"] != "Accepted" {
+		t.Fatalf("RemoteStopTransaction = %v", res)
+	}
+
+	var stop ocpp16Stop
+	csms.expect("StopTransaction", &stop)
+	if stop != (ocpp16Stop{TransactionId: 42, IdTag: "TAG1", Reason: "Remote"}) {
+		t.Fatalf("StopTransaction = %+v", stop)
+	}
+	expectStatus(t, csms, "Available")
+}
+
+func TestOCPP16LocalStop(t *testing.T) {
+	tests := []struct {
+		name   string
+		stop   func(gw *simulator.Gateway, cp *OCPP16ChargePoint)
+		reason string
+		status string
+	}{
+		// 例如 MQTT / Modbus 送的 stop
+		{"stop command", func(_ *simulator.Gateway, cp *OCPP16ChargePoint) { cp.can.SendCommand("stop") }, "Local", "Available"},
+		{"fault", func(gw *simulator.Gateway, _ *OCPP16ChargePoint) { gw.Charger("01").SetFault(time.Now(), 0x01, nil) }, "Other", "Faulted"},
+		{"gateway disconnect", func(gw *simulator.Gateway, _ *OCPP16ChargePoint) { gw.Offline(time.Minute) }, "Other", "Unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csms, gw, cp := startOCPP16(t)
+
+			tt.stop(gw, cp)
+
+			var stop ocpp16Stop
+			csms.expect("StopTransaction", &stop)
+			if stop.TransactionId != 42 || stop.Reason != tt.reason {
+				t.Fatalf("StopTransaction = %+v, want reason %s", stop, tt.reason)
+			}
+			expectStatus(t, csms, tt.status)
+
+			cp.mu.Lock()
+			defer cp.mu.Unlock()
+			if cp.tx != nil {
+				t.Fatal("transaction still open")
+			}
+		})
+	}
+}
+
+func TestOCPP16StatusResentAfterFailure(t *testing.T) {
+	var failed atomic.Bool
+	csms := newTestCSMS(t, "ocpp1.6", withReplies(ocpp16Replies, map[string]interface{}{
+		// 第一個 StatusNotification 回 CALLERROR
+		"StatusNotification": csmsReply(func(json.RawMessage) interface{} {
+			if failed.CompareAndSwap(false, true) {
+				return &ocppError{Code: "InternalError", Description: "try again"}
+			}
+			return struct{}{}
+		}),
+	}))
+	_, can, eb := newTestStation(t)
+
+	cp := NewOCPP16ChargePoint(config.OCPP{URL: csms.URL()}, can, eb)
+	defer cp.Close()
+
+	csms.expect("BootNotification", nil)
+	csms.expect("StatusNotification", nil)
+	expectStatus(t, csms, "Available")
+
+	waitFor(t, "status confirmed", func() bool {
+		cp.mu.Lock()
+		defer cp.mu.Unlock()
+		return cp.status == "Available"
+	})
+}
+
+func TestOCPP16SlowCentralSystemDoesNotBlockTelemetry(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	csms := newTestCSMS(t, "ocpp1.6", withReplies(ocpp16Replies, map[string]interface{}{
+		// central system 卡住不回覆
+		"StatusNotification": csmsReply(func(json.RawMessage) interface{} {
+			<-release
+			return struct{}{}
+		}),
+	}))
+	_, can, eb := newTestStation(t)
+
+	cp := NewOCPP16ChargePoint(config.OCPP{URL: csms.URL()}, can, eb)
+	defer cp.Close()
+	csms.expect("StatusNotification", nil)
+
+	// 遠超過訂閱的佇列大小, 在 handler 裡等 OCPP 回覆時這裡會卡住
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10*eventbus.DefaultBuffer; i++ {
+			events.ChargerTelemetry.With("01").Publish(eb, types.ChargerTelemetry{StationId: "01"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("publishing telemetry blocked on the central system")
+	}
+}
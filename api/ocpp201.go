@@ -0,0 +1,706 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/types"
+
+	"go.uber.org/zap"
+)
+
+// OCPP201ChargePoint 把一個 CAN 站點以 OCPP 2.0.1 charging station 的身分接到 CSMS,
+// 站點只有一個 EVSE (evseId 1) 與一個 connector (connectorId 1)
+type OCPP201ChargePoint struct {
+	mu              sync.Mutex
+	cfg             config.OCPP
+	can             *CANClient
+	logger          *zap.Logger // 與 CAN 連線共用的站點 logger
+	eb              *eventbus.EventBus
+	ctx             context.Context
+	cancel          context.CancelFunc
+	conn            *ocppConn
+	heartbeat       time.Duration // OCPPCommCtrlr.HeartbeatInterval
+	txInterval      time.Duration // SampledDataCtrlr.TxUpdatedInterval
+	intervalChanged chan struct{}
+	status          string // CSMS 已確認的 connector 狀態
+	tx              *ocppSession
+	queue           []ocpp201Queued // 還沒送出的 TransactionEvent, 由 notifyLoop 依序送出
+	changed         chan struct{}
+	flush           chan chan struct{} // EndTransaction 要求 notifyLoop 處理一次後回報
+	subs            [2]int             // station.<id>.connection / station.<id>.telemetry 的訂閱 id
+}
+
+type ocpp201Queued struct {
+	action  string
+	payload map[string]interface{}
+	tx      *ocppSession
+}
+
+type ocpp201Component struct {
+	Name string `json:"name"`
+}
+
+type ocpp201Variable struct {
+	Name string `json:"name"`
+}
+
+// ocpp201DeviceVar 是 device model 裡的一個變數, set 為 nil 表示唯讀
+type ocpp201DeviceVar struct {
+	get func() string
+	set func(value string) error
+}
+
+type ocpp201UnitOfMeasure struct {
+	Unit string `json:"unit"`
+}
+
+type ocpp201SampledValue struct {
+	Value         float64              `json:"value"`
+	Measurand     string               `json:"measurand,omitempty"`
+	UnitOfMeasure ocpp201UnitOfMeasure `json:"unitOfMeasure"`
+}
+
+type ocpp201MeterValue struct {
+	Timestamp    string                `json:"timestamp"`
+	SampledValue []ocpp201SampledValue `json:"sampledValue"`
+}
+
+func NewOCPP201ChargePoint(cfg config.OCPP, can *CANClient, eb *eventbus.EventBus) *OCPP201ChargePoint {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cfg = applyOCPPDefaults(cfg, can.StationId())
+
+	cp := &OCPP201ChargePoint{
+		cfg:             cfg,
+		can:             can,
+		logger:          can.Logger(),
+		eb:              eb,
+		ctx:             ctx,
+		cancel:          cancel,
+		heartbeat:       5 * time.Minute,
+		txInterval:      time.Duration(cfg.MeterInterval) * time.Second,
+		intervalChanged: make(chan struct{}, 1),
+		changed:         make(chan struct{}, 1),
+		flush:           make(chan chan struct{}),
+	}
+
+	cp.subEb()
+	go cp.run()
+	go cp.meterLoop()
+	go cp.notifyLoop()
+	return cp
+}
+
+func (cp *OCPP201ChargePoint) run() {
+	for {
+		conn, err := dialOCPP(cp.ctx, cp.cfg, "ocpp2.0.1", cp.handlers(), cp.logger)
+		if err != nil {
+			cp.logger.Error(fmt.Sprintf("OCPP %s 連線失敗: %v, Reconnect in 3 seconds...", cp.cfg.ChargePointID, err))
+			select {
+			case <-time.After(3 * time.Second):
+				continue
+			case <-cp.ctx.Done():
+				return
+			}
+		}
+
+		cp.logger.Info(fmt.Sprintf("🔌 OCPP %s 已連線到 %s", cp.cfg.ChargePointID, cp.cfg.URL))
+
+		sessionDone := make(chan struct{})
+		go cp.session(conn, sessionDone)
+
+		readErr := make(chan error, 1)
+		go func() { readErr <- conn.readLoop() }()
+
+		select {
+		case err := <-readErr:
+			cp.logger.Warn(fmt.Sprintf("⚠️ OCPP %s 斷線: %v", cp.cfg.ChargePointID, err))
+		case <-cp.ctx.Done():
+			conn.Close()
+		}
+
+		<-sessionDone
+
+		cp.mu.Lock()
+		cp.conn = nil
+		cp.mu.Unlock()
+
+		if cp.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// session 在連線期間送 BootNotification, 補送離線佇列, 接著定期 Heartbeat
+func (cp *OCPP201ChargePoint) session(conn *ocppConn, done chan struct{}) {
+	defer close(done)
+
+	for {
+		var res struct {
+			Status      string `json:"status"`
+			CurrentTime string `json:"currentTime"`
+			Interval    int    `json:"interval"`
+		}
+		err := conn.call(cp.ctx, "BootNotification", map[string]interface{}{
+			"reason": "PowerUp",
+			"chargingStation": map[string]string{
+				"model":      cp.cfg.Model,
+				"vendorName": cp.cfg.Vendor,
+			},
+		}, &res)
+		if err != nil {
+			cp.logger.Error(fmt.Sprintf("OCPP %s BootNotification 失敗: %v", cp.cfg.ChargePointID, err))
+			conn.Close()
+			return
+		}
+
+		interval := time.Duration(res.Interval) * time.Second
+		if res.Status == "Accepted" {
+			if interval > 0 {
+				cp.mu.Lock()
+				cp.heartbeat = interval
+				cp.mu.Unlock()
+			}
+			break
+		}
+
+		// Pending / Rejected: 依 CSMS 給的間隔重送
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		select {
+		case <-time.After(interval):
+		case <-conn.done:
+			return
+		}
+	}
+
+	// Boot 被接受後才開始送其他訊息
+	cp.mu.Lock()
+	cp.conn = conn
+	cp.status = ""
+	cp.mu.Unlock()
+
+	cp.notify()
+
+	for {
+		cp.mu.Lock()
+		heartbeat := cp.heartbeat
+		cp.mu.Unlock()
+
+		select {
+		case <-time.After(heartbeat):
+			if err := conn.call(cp.ctx, "Heartbeat", struct{}{}, nil); err != nil {
+				cp.logger.Error(fmt.Sprintf("OCPP %s Heartbeat 失敗: %v", cp.cfg.ChargePointID, err))
+			}
+			// 之前沒送出的訊息至少每個 heartbeat 重送一次
+			cp.notify()
+		case <-cp.intervalChanged:
+		case <-conn.done:
+			return
+		}
+	}
+}
+
+func (cp *OCPP201ChargePoint) handlers() map[string]ocppHandler {
+	return map[string]ocppHandler{
+		"RequestStartTransaction": cp.requestStart,
+		"RequestStopTransaction":  cp.requestStop,
+		"GetVariables":            cp.getVariables,
+		"SetVariables":            cp.setVariables,
+	}
+}
+
+func (cp *OCPP201ChargePoint) requestStart(payload json.RawMessage) (interface{}, error) {
+	var req struct {
+		EvseId  int `json:"evseId"`
+		IdToken struct {
+			IdToken string `json:"idToken"`
+			Type    string `json:"type"`
+		} `json:"idToken"`
+		RemoteStartId int `json:"remoteStartId"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &ocppError{Code: "FormationViolation", Description: err.Error()}
+	}
+
+	rejected := map[string]string{"status": "Rejected"}
+
+	if (req.EvseId != 0 && req.EvseId != 1) || !cp.can.IsConnected() {
+		return rejected, nil
+	}
+
+	tx := newOCPPSession(req.IdToken.IdToken)
+	tx.meterStart = cp.can.Telemetry().EnergyWh
+
+	cp.mu.Lock()
+	if cp.tx != nil {
+		cp.mu.Unlock()
+		return rejected, nil
+	}
+	cp.tx = tx
+	cp.mu.Unlock()
+
+	if err := cp.can.SendCommand("start"); err != nil {
+		cp.logger.Error(fmt.Sprintf("OCPP %s RequestStart 送出 CAN 指令失敗: %v", cp.cfg.ChargePointID, err))
+		cp.mu.Lock()
+		cp.tx = nil
+		cp.mu.Unlock()
+		return rejected, nil
+	}
+
+	cp.sendTransactionEvent("Started", "RemoteStart", tx, map[string]interface{}{
+		"remoteStartId": req.RemoteStartId,
+	}, map[string]interface{}{
+		"idToken": map[string]string{"idToken": req.IdToken.IdToken, "type": req.IdToken.Type},
+	})
+
+	return map[string]interface{}{"status": "Accepted", "transactionId": tx.id}, nil
+}
+
+func (cp *OCPP201ChargePoint) requestStop(payload json.RawMessage) (interface{}, error) {
+	var req struct {
+		TransactionId string `json:"transactionId"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &ocppError{Code: "FormationViolation", Description: err.Error()}
+	}
+
+	cp.mu.Lock()
+	tx := cp.tx
+	if tx == nil || tx.id != req.TransactionId {
+		cp.mu.Unlock()
+		return map[string]string{"status": "Rejected"}, nil
+	}
+	cp.tx = nil
+	cp.mu.Unlock()
+
+	if err := cp.can.SendCommand("stop"); err != nil {
+		cp.logger.Error(fmt.Sprintf("OCPP %s RequestStop 送出 CAN 指令失敗: %v", cp.cfg.ChargePointID, err))
+		cp.mu.Lock()
+		cp.tx = tx
+		cp.mu.Unlock()
+		return map[string]string{"status": "Rejected"}, nil
+	}
+
+	cp.sendTransactionEvent("Ended", "RemoteStop", tx, map[string]interface{}{
+		"stoppedReason": "Remote",
+	}, nil)
+
+	return map[string]string{"status": "Accepted"}, nil
+}
+
+// sendTransactionEvent 組出 TransactionEvent 放進佇列, 由 notifyLoop 送出, 不會阻塞
+func (cp *OCPP201ChargePoint) sendTransactionEvent(eventType, trigger string, tx *ocppSession, txInfo, extra map[string]interface{}) {
+	info := map[string]interface{}{
+		"transactionId": tx.id,
+		"chargingState": "Charging",
+	}
+	if eventType == "Ended" {
+		info["chargingState"] = "Idle"
+	}
+	for k, v := range txInfo {
+		info[k] = v
+	}
+
+	payload := map[string]interface{}{
+		"eventType":       eventType,
+		"timestamp":       ocppTime(time.Now()),
+		"triggerReason":   trigger,
+		"transactionInfo": info,
+		"evse":            map[string]int{"id": 1, "connectorId": 1},
+		"meterValue":      []ocpp201MeterValue{ocpp201Sample(cp.can.Telemetry())},
+	}
+	for k, v := range extra {
+		payload[k] = v
+	}
+
+	// seqNo 與入佇列要在同一個 lock 內, 才能保證佇列順序與序號一致
+	cp.mu.Lock()
+	payload["seqNo"] = tx.seqNo
+	tx.seqNo++
+	if cp.conn == nil {
+		payload["offline"] = true
+	}
+	if len(cp.queue) >= cp.cfg.OfflineQueueSize {
+		cp.logger.Warn(fmt.Sprintf("OCPP %s 離線佇列已滿, 丟棄最舊的訊息", cp.cfg.ChargePointID))
+		cp.queue = cp.queue[1:]
+	}
+	cp.queue = append(cp.queue, ocpp201Queued{action: "TransactionEvent", payload: payload, tx: tx})
+	cp.mu.Unlock()
+
+	cp.notify()
+}
+
+// flushQueue 依序送出佇列, 遇到連線問題就停下等下次再送, 被 CSMS 拒絕的訊息則丟棄。
+// 只由 notifyLoop 呼叫
+func (cp *OCPP201ChargePoint) flushQueue(conn *ocppConn) {
+	for {
+		cp.mu.Lock()
+		if len(cp.queue) == 0 {
+			cp.mu.Unlock()
+			return
+		}
+		msg := cp.queue[0]
+		cp.mu.Unlock()
+
+		var res struct {
+			IdTokenInfo *struct {
+				Status string `json:"status"`
+			} `json:"idTokenInfo"`
+		}
+		err := conn.call(cp.ctx, msg.action, msg.payload, &res)
+
+		var oe *ocppError
+		if err != nil && !errors.As(err, &oe) {
+			cp.logger.Warn(fmt.Sprintf("OCPP %s %s 未送出, 保留在佇列: %v", cp.cfg.ChargePointID, msg.action, err))
+			return
+		}
+		if err != nil {
+			cp.logger.Error(fmt.Sprintf("OCPP %s %s 被拒絕: %v", cp.cfg.ChargePointID, msg.action, err))
+		}
+
+		cp.mu.Lock()
+		cp.queue = cp.queue[1:]
+		cp.mu.Unlock()
+
+		if err == nil && res.IdTokenInfo != nil && res.IdTokenInfo.Status != "Accepted" {
+			cp.deauthorize(msg.tx, res.IdTokenInfo.Status)
+		}
+	}
+}
+
+// deauthorize 結束 idToken 沒被 CSMS 接受的交易: 停止充電並送 TransactionEvent Ended
+func (cp *OCPP201ChargePoint) deauthorize(tx *ocppSession, status string) {
+	cp.mu.Lock()
+	if tx == nil || cp.tx != tx {
+		cp.mu.Unlock()
+		return
+	}
+	cp.tx = nil
+	cp.mu.Unlock()
+
+	cp.logger.Warn(fmt.Sprintf("OCPP %s idToken %s 未被接受 (%s), 停止充電", cp.cfg.ChargePointID, tx.idTag, status))
+	if err := cp.can.SendCommand("stop"); err != nil {
+		cp.logger.Error(fmt.Sprintf("OCPP %s 送出 CAN 停止指令失敗: %v", cp.cfg.ChargePointID, err))
+	}
+	cp.sendTransactionEvent("Ended", "Deauthorized", tx, map[string]interface{}{
+		"stoppedReason": "DeAuthorized",
+	}, nil)
+}
+
+// meterLoop 在交易進行中依 TxUpdatedInterval 送 TransactionEvent Updated
+func (cp *OCPP201ChargePoint) meterLoop() {
+	for {
+		cp.mu.Lock()
+		interval := cp.txInterval
+		cp.mu.Unlock()
+
+		select {
+		case <-time.After(interval):
+			cp.mu.Lock()
+			tx := cp.tx
+			cp.mu.Unlock()
+
+			if tx != nil {
+				cp.sendTransactionEvent("Updated", "MeterValuePeriodic", tx, nil, nil)
+			}
+
+		case <-cp.ctx.Done():
+			return
+		}
+	}
+}
+
+func ocpp201Sample(t types.ChargerTelemetry) ocpp201MeterValue {
+	return ocpp201MeterValue{
+		Timestamp: ocppTime(time.Now()),
+		SampledValue: []ocpp201SampledValue{
+			{Value: t.EnergyWh, Measurand: "Energy.Active.Import.Register", UnitOfMeasure: ocpp201UnitOfMeasure{Unit: "Wh"}},
+			{Value: t.Voltage, Measurand: "Voltage", UnitOfMeasure: ocpp201UnitOfMeasure{Unit: "V"}},
+			{Value: t.Current, Measurand: "Current.Import", UnitOfMeasure: ocpp201UnitOfMeasure{Unit: "A"}},
+			{Value: float64(t.Temperature), Measurand: "Temperature", UnitOfMeasure: ocpp201UnitOfMeasure{Unit: "Celsius"}},
+		},
+	}
+}
+
+// deviceModel 列出支援的 device model 變數, key 為 "Component.Variable"
+func (cp *OCPP201ChargePoint) deviceModel() map[string]ocpp201DeviceVar {
+	seconds := func(d *time.Duration) ocpp201DeviceVar {
+		return ocpp201DeviceVar{
+			get: func() string {
+				cp.mu.Lock()
+				defer cp.mu.Unlock()
+				return strconv.Itoa(int(d.Seconds()))
+			},
+			set: func(value string) error {
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					return fmt.Errorf("invalid interval %q", value)
+				}
+				cp.mu.Lock()
+				*d = time.Duration(n) * time.Second
+				cp.mu.Unlock()
+
+				select {
+				case cp.intervalChanged <- struct{}{}:
+				default:
+				}
+				return nil
+			},
+		}
+	}
+	readOnly := func(get func() string) ocpp201DeviceVar {
+		return ocpp201DeviceVar{get: get}
+	}
+
+	return map[string]ocpp201DeviceVar{
+		"OCPPCommCtrlr.HeartbeatInterval":    seconds(&cp.heartbeat),
+		"SampledDataCtrlr.TxUpdatedInterval": seconds(&cp.txInterval),
+		"SecurityCtrlr.SecurityProfile":      readOnly(func() string { return strconv.Itoa(cp.cfg.SecurityProfile) }),
+		"SecurityCtrlr.Identity":             readOnly(func() string { return cp.cfg.ChargePointID }),
+		"ChargingStation.Model":              readOnly(func() string { return cp.cfg.Model }),
+		"ChargingStation.VendorName":         readOnly(func() string { return cp.cfg.Vendor }),
+		"ChargingStation.AvailabilityState": readOnly(func() string {
+			cp.mu.Lock()
+			defer cp.mu.Unlock()
+			return ocpp201ConnectorStatus(stationConnectorState(cp.can, cp.tx != nil))
+		}),
+	}
+}
+
+// lookupVariable 回傳變數與 OCPP 的 attributeStatus (找不到時為 UnknownComponent / UnknownVariable)
+func (cp *OCPP201ChargePoint) lookupVariable(component, variable string) (ocpp201DeviceVar, string) {
+	model := cp.deviceModel()
+	if v, ok := model[component+"."+variable]; ok {
+		return v, "Accepted"
+	}
+	for key := range model {
+		if strings.HasPrefix(key, component+".") {
+			return ocpp201DeviceVar{}, "UnknownVariable"
+		}
+	}
+	return ocpp201DeviceVar{}, "UnknownComponent"
+}
+
+func (cp *OCPP201ChargePoint) getVariables(payload json.RawMessage) (interface{}, error) {
+	var req struct {
+		GetVariableData []struct {
+			AttributeType string           `json:"attributeType"`
+			Component     ocpp201Component `json:"component"`
+			Variable      ocpp201Variable  `json:"variable"`
+		} `json:"getVariableData"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &ocppError{Code: "FormationViolation", Description: err.Error()}
+	}
+
+	results := []map[string]interface{}{}
+	for _, d := range req.GetVariableData {
+		result := map[string]interface{}{
+			"component": d.Component,
+			"variable":  d.Variable,
+		}
+
+		v, status := cp.lookupVariable(d.Component.Name, d.Variable.Name)
+		if status == "Accepted" && d.AttributeType != "" && d.AttributeType != "Actual" {
+			status = "NotSupportedAttributeType"
+		}
+		if status == "Accepted" {
+			result["attributeValue"] = v.get()
+		}
+		result["attributeStatus"] = status
+		results = append(results, result)
+	}
+
+	return map[string]interface{}{"getVariableResult": results}, nil
+}
+
+func (cp *OCPP201ChargePoint) setVariables(payload json.RawMessage) (interface{}, error) {
+	var req struct {
+		SetVariableData []struct {
+			AttributeType  string           `json:"attributeType"`
+			AttributeValue string           `json:"attributeValue"`
+			Component      ocpp201Component `json:"component"`
+			Variable       ocpp201Variable  `json:"variable"`
+		} `json:"setVariableData"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &ocppError{Code: "FormationViolation", Description: err.Error()}
+	}
+
+	results := []map[string]interface{}{}
+	for _, d := range req.SetVariableData {
+		v, status := cp.lookupVariable(d.Component.Name, d.Variable.Name)
+		switch {
+		case status != "Accepted":
+		case d.AttributeType != "" && d.AttributeType != "Actual":
+			status = "NotSupportedAttributeType"
+		case v.set == nil:
+			status = "Rejected"
+		case v.set(d.AttributeValue) != nil:
+			status = "Rejected"
+		}
+
+		results = append(results, map[string]interface{}{
+			"attributeStatus": status,
+			"component":       d.Component,
+			"variable":        d.Variable,
+		})
+	}
+
+	return map[string]interface{}{"setVariableResult": results}, nil
+}
+
+func (cp *OCPP201ChargePoint) subEb() {
+	stationId := cp.can.StationId()
+
+	// 依序處理, TransactionEvent 才會照狀態變化的順序進佇列。handler 只更新狀態, OCPP 呼叫交給 notifyLoop,
+	// CSMS 回應再慢也不會卡住 CANClient 的讀取
+	ordered := eventbus.SubscribeOptions{Delivery: eventbus.DeliverOrdered}
+
+	cp.subs[0] = events.ConnectionTCP.With(stationId).SubscribeWith(cp.eb, func(conn types.ConnectionTcp) {
+		if !conn.IsConnect {
+			cp.localStop("AbnormalCondition", "Other")
+		}
+		cp.notify()
+	}, ordered)
+
+	cp.subs[1] = events.ChargerTelemetry.With(stationId).SubscribeWith(cp.eb, func(t types.ChargerTelemetry) {
+		switch {
+		case t.FaultBits != 0:
+			cp.localStop("AbnormalCondition", "Other")
+		case !t.Charging:
+			cp.localStop("ChargingStateChanged", "Local")
+		default:
+			cp.mu.Lock()
+			if cp.tx != nil {
+				cp.tx.charging = true
+			}
+			cp.mu.Unlock()
+		}
+		cp.notify()
+	}, ordered)
+}
+
+// localStop 結束不是由 CSMS 要求停止的交易, 例如 MQTT / Modbus 送 stop、充電機充滿、故障或閘道器斷線。
+// 剛 RequestStart 還沒看到充電的交易只有斷線或故障時才結束
+func (cp *OCPP201ChargePoint) localStop(trigger, reason string) {
+	cp.mu.Lock()
+	tx := cp.tx
+	if tx == nil || (!tx.charging && reason == "Local") {
+		cp.mu.Unlock()
+		return
+	}
+	cp.tx = nil
+	cp.mu.Unlock()
+
+	cp.logger.Info(fmt.Sprintf("OCPP %s 充電已在本地結束 (%s), 送出 TransactionEvent Ended", cp.cfg.ChargePointID, reason))
+	cp.sendTransactionEvent("Ended", trigger, tx, map[string]interface{}{
+		"stoppedReason": reason,
+	}, nil)
+}
+
+func ocpp201ConnectorStatus(s connectorState) string {
+	switch s {
+	case connectorUnavailable:
+		return "Unavailable"
+	case connectorFaulted:
+		return "Faulted"
+	case connectorOccupied:
+		return "Occupied"
+	default:
+		return "Available"
+	}
+}
+
+// notify 通知 notifyLoop 狀態有變化, 不會阻塞
+func (cp *OCPP201ChargePoint) notify() {
+	select {
+	case cp.changed <- struct{}{}:
+	default:
+	}
+}
+
+// notifyLoop 依序送出佇列裡的 TransactionEvent 與 StatusNotification
+func (cp *OCPP201ChargePoint) notifyLoop() {
+	for {
+		var done chan struct{}
+		select {
+		case <-cp.changed:
+		case done = <-cp.flush:
+		case <-cp.ctx.Done():
+			return
+		}
+
+		if conn := cp.currentConn(); conn != nil {
+			cp.flushQueue(conn)
+		}
+		cp.updateStatus()
+		if done != nil {
+			close(done)
+		}
+	}
+}
+
+// EndTransaction 是 stop_policy 為 stop 時關機前呼叫, 結束進行中的交易並等 TransactionEvent Ended 送出或 ctx 結束;
+// 與 CSMS 斷線時 Ended 只能留在佇列, 關機後就不會送出
+func (cp *OCPP201ChargePoint) EndTransaction(ctx context.Context) {
+	cp.localStop("AbnormalCondition", "Other")
+	flushOCPP(ctx, cp.flush)
+}
+
+// updateStatus 由連線狀態與遙測推出 connector 狀態, 和 CSMS 已確認的不同才送 StatusNotification,
+// 送失敗時下次再送
+func (cp *OCPP201ChargePoint) updateStatus() {
+	cp.mu.Lock()
+	status := ocpp201ConnectorStatus(stationConnectorState(cp.can, cp.tx != nil))
+
+	conn := cp.conn
+	if conn == nil || status == cp.status {
+		cp.mu.Unlock()
+		return
+	}
+	cp.mu.Unlock()
+
+	err := conn.call(cp.ctx, "StatusNotification", map[string]interface{}{
+		"timestamp":       ocppTime(time.Now()),
+		"connectorStatus": status,
+		"evseId":          1,
+		"connectorId":     1,
+	}, nil)
+	if err != nil {
+		cp.logger.Error(fmt.Sprintf("OCPP %s StatusNotification 失敗: %v", cp.cfg.ChargePointID, err))
+		return
+	}
+
+	cp.mu.Lock()
+	// 重連後 session 會清掉 status, 舊連線送的不算
+	if cp.conn == conn {
+		cp.status = status
+	}
+	cp.mu.Unlock()
+}
+
+func (cp *OCPP201ChargePoint) currentConn() *ocppConn {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.conn
+}
+
+func (cp *OCPP201ChargePoint) Close() {
+	cp.cancel()
+	stationId := cp.can.StationId()
+	events.ConnectionTCP.With(stationId).Unsubscribe(cp.eb, cp.subs[0])
+	events.ChargerTelemetry.With(stationId).Unsubscribe(cp.eb, cp.subs[1])
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/simulator"
+)
+
+var ocpp201Replies = map[string]interface{}{
+	"BootNotification": map[string]interface{}{"status": "Accepted", "currentTime": ocppTime(time.Now()), "interval": 1},
+	"Heartbeat":        map[string]string{"currentTime": ocppTime(time.Now())},
+}
+
+type ocpp201Event struct {
+	EventType       string `json:"eventType"`
+	TriggerReason   string `json:"triggerReason"`
+	SeqNo           int    `json:"seqNo"`
+	TransactionInfo struct {
+		TransactionId string `json:"transactionId"`
+		StoppedReason string `json:"stoppedReason"`
+	} `json:"transactionInfo"`
+}
+
+// expectConnectorStatus 等到 connector 回報指定的狀態
+func expectConnectorStatus(t *testing.T, csms *testCSMS, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var st struct {
+			ConnectorStatus string `json:"connectorStatus"`
+		}
+		csms.expect("StatusNotification", &st)
+		if st.ConnectorStatus == want {
+			return
+		}
+	}
+	t.Fatalf("connector never reported %s", want)
+}
+
+// expectTransactionEvent 等下一個指定 eventType 的 TransactionEvent
+func expectTransactionEvent(t *testing.T, csms *testCSMS, eventType string) ocpp201Event {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var ev ocpp201Event
+		csms.expect("TransactionEvent", &ev)
+		if ev.EventType == eventType {
+			return ev
+		}
+	}
+	t.Fatalf("no TransactionEvent %s", eventType)
+	return ocpp201Event{}
+}
+
+// startOCPP201 連上 CSMS 並由 RequestStartTransaction 開始一筆充電中的交易, 回傳 transactionId
+func startOCPP201(t *testing.T) (*testCSMS, *simulator.Gateway, *OCPP201ChargePoint, string) {
+	t.Helper()
+
+	csms := newTestCSMS(t, "ocpp2.0.1", ocpp201Replies)
+	gw, can, eb := newTestStation(t)
+
+	cp := NewOCPP201ChargePoint(config.OCPP{URL: csms.URL(), Vendor: "kenmec", Model: "sim", MeterInterval: 1}, can, eb)
+	t.Cleanup(cp.Close)
+
+	var boot struct {
+		ChargingStation map[string]string `json:"chargingStation"`
+	}
+	csms.expect("BootNotification", &boot)
+	if boot.ChargingStation["vendorName"] != "kenmec" || boot.ChargingStation["model"] != "sim" {
+		t.Fatalf("BootNotification = %v", boot)
+	}
+	expectConnectorStatus(t, csms, "Available")
+
+	var res struct {
+		Status        string `json:"status"`
+		TransactionId string `json:"transactionId"`
+	}
+	json.Unmarshal(csms.call("RequestStartTransaction", map[string]interface{}{
+		"evseId":        1,
+		"remoteStartId": 5,
+		"idToken":       map[string]string{"idToken": "TAG1", "type": "ISO14443"},
+	}), &res)
+	if res.Status != "Accepted" || res.TransactionId == "" {
+		t.Fatalf("RequestStartTransaction = %+v", res)
+	}
+
+	ev := expectTransactionEvent(t, csms, "Started")
+	if ev.TransactionInfo.TransactionId != res.TransactionId || ev.TriggerReason != "RemoteStart" || ev.SeqNo != 0 {
+		t.Fatalf("TransactionEvent Started = %+v", ev)
+	}
+	expectConnectorStatus(t, csms, "Occupied")
+	waitFor(t, "charging", func() bool { return can.Telemetry().Charging })
+
+	return csms, gw, cp, res.TransactionId
+}
+
+func TestOCPP201RequestStartStop(t *testing.T) {
+	csms, _, _, txId := startOCPP201(t)
+
+	// 交易中依 TxUpdatedInterval 送 Updated
+	ev := expectTransactionEvent(t, csms, "Updated")
+	if ev.TransactionInfo.TransactionId != txId || ev.TriggerReason != "MeterValuePeriodic" {
+		t.Fatalf("TransactionEvent Updated = %+v", ev)
+	}
+
+	var res map[string]string
+	json.Unmarshal(csms.call("RequestStopTransaction", map[string]string{"transactionId": "other"}), &res)
+	if res["status"] != "Rejected" {
+		t.Fatalf("RequestStopTransaction with unknown id = %v", res)
+	}
+
+	json.Unmarshal(csms.call("RequestStopTransaction", map[string]string{"transactionId": txId}), &res)
+	if res["status"] != "Accepted" {
+		t.Fatalf("RequestStopTransaction = %v", res)
+	}
+
+	ev = expectTransactionEvent(t, csms, "Ended")
+	if ev.TransactionInfo.TransactionId != txId || ev.TriggerReason != "RemoteStop" || ev.TransactionInfo.StoppedReason != "Remote" {
+		t.Fatalf("TransactionEvent Ended = %+v", ev)
+	}
+	expectConnectorStatus(t, csms, "Available")
+}
+
+func TestOCPP201LocalStop(t *testing.T) {
+	tests := []struct {
+		name    string
+		stop    func(gw *simulator.Gateway, cp *OCPP201ChargePoint)
+		trigger string
+		reason  string
+		status  string
+	}{
+		// 例如 MQTT / Modbus 送的 stop
+		{"stop command", func(_ *simulator.Gateway, cp *OCPP201ChargePoint) { cp.can.SendCommand("stop") }, "ChargingStateChanged", "Local", "Available"},
+		{"fault", func(gw *simulator.Gateway, _ *OCPP201ChargePoint) { gw.Charger("01").SetFault(time.Now(), 0x01, nil) }, "AbnormalCondition", "Other", "Faulted"},
+		{"gateway disconnect", func(gw *simulator.Gateway, _ *OCPP201ChargePoint) { gw.Offline(time.Minute) }, "AbnormalCondition", "Other", "Unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csms, gw, cp, txId := startOCPP201(t)
+
+			tt.stop(gw, cp)
+
+			ev := expectTransactionEvent(t, csms, "Ended")
+			if ev.TransactionInfo.TransactionId != txId || ev.TriggerReason != tt.trigger || ev.TransactionInfo.StoppedReason != tt.reason {
+				t.Fatalf("TransactionEvent Ended = %+v", ev)
+			}
+			expectConnectorStatus(t, csms, tt.status)
+
+			cp.mu.Lock()
+			defer cp.mu.Unlock()
+			if cp.tx != nil || len(cp.queue) != 0 {
+				t.Fatalf("tx %v, %d queued after Ended", cp.tx, len(cp.queue))
+			}
+		})
+	}
+}
+
+func TestOCPP201IdTokenRejected(t *testing.T) {
+	csms := newTestCSMS(t, "ocpp2.0.1", withReplies(ocpp201Replies, map[string]interface{}{
+		// CSMS 不接受 Started 帶的 idToken
+		"TransactionEvent": csmsReply(func(payload json.RawMessage) interface{} {
+			var ev ocpp201Event
+			json.Unmarshal(payload, &ev)
+			if ev.EventType == "Started" {
+				return map[string]interface{}{"idTokenInfo": map[string]string{"status": "Invalid"}}
+			}
+			return struct{}{}
+		}),
+	}))
+	gw, can, eb := newTestStation(t)
+
+	cp := NewOCPP201ChargePoint(config.OCPP{URL: csms.URL()}, can, eb)
+	defer cp.Close()
+	expectConnectorStatus(t, csms, "Available")
+
+	var res struct {
+		Status        string `json:"status"`
+		TransactionId string `json:"transactionId"`
+	}
+	json.Unmarshal(csms.call("RequestStartTransaction", map[string]interface{}{
+		"evseId":        1,
+		"remoteStartId": 5,
+		"idToken":       map[string]string{"idToken": "BAD", "type": "ISO14443"},
+	}), &res)
+	if res.Status != "Accepted" {
+		t.Fatalf("RequestStartTransaction = %+v", res)
+	}
+
+	expectTransactionEvent(t, csms, "Started")
+	ev := expectTransactionEvent(t, csms, "Ended")
+	if ev.TransactionInfo.TransactionId != res.TransactionId || ev.TriggerReason != "Deauthorized" || ev.TransactionInfo.StoppedReason != "DeAuthorized" {
+		t.Fatalf("TransactionEvent Ended = %+v", ev)
+	}
+
+	waitFor(t, "transaction closed", func() bool {
+		cp.mu.Lock()
+		defer cp.mu.Unlock()
+		return cp.tx == nil
+	})
+
+	// 充電機也要收到 stop, 不然 start 之後會一直充電
+	time.Sleep(300 * time.Millisecond)
+	if gw.Charger("01").Status(time.Now()).Charging {
+		t.Fatal("charger still charging after the idToken was rejected")
+	}
+}
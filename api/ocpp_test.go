@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/simulator"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// csmsCall 是 charge point 送到 stand-in CSMS 的 CALL
+type csmsCall struct {
+	Action  string
+	Payload json.RawMessage
+}
+
+type csmsReply func(payload json.RawMessage) interface{}
+
+// testCSMS 是測試用的 central system, 記錄收到的 CALL 並依 replies 回覆, 沒設定的 action 回 {}。
+// reply 可以是 csmsReply, 依 payload 決定回覆; 回覆 *ocppError 時送 CALLERROR
+type testCSMS struct {
+	t           *testing.T
+	srv         *httptest.Server
+	subprotocol string
+	replies     map[string]interface{}
+	calls       chan csmsCall
+
+	mu      sync.Mutex
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+	results map[string]chan json.RawMessage
+	online  chan struct{}
+}
+
+func newTestCSMS(t *testing.T, subprotocol string, replies map[string]interface{}) *testCSMS {
+	t.Helper()
+
+	c := &testCSMS{
+		t:           t,
+		subprotocol: subprotocol,
+		replies:     replies,
+		calls:       make(chan csmsCall, 100),
+		results:     make(map[string]chan json.RawMessage),
+		online:      make(chan struct{}),
+	}
+	c.srv = httptest.NewServer(http.HandlerFunc(c.serve))
+	t.Cleanup(c.srv.Close)
+	return c
+}
+
+func (c *testCSMS) URL() string {
+	return "ws" + strings.TrimPrefix(c.srv.URL, "http")
+}
+
+func (c *testCSMS) serve(w http.ResponseWriter, r *http.Request) {
+	up := websocket.Upgrader{Subprotocols: []string{c.subprotocol}}
+	ws, err := up.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	c.mu.Lock()
+	if c.ws == nil {
+		close(c.online)
+	}
+	c.ws = ws
+	c.mu.Unlock()
+
+	for {
+		var msg []json.RawMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		var msgType int
+		var id string
+		json.Unmarshal(msg[0], &msgType)
+		json.Unmarshal(msg[1], &id)
+
+		switch msgType {
+		case ocppCall:
+			var action string
+			json.Unmarshal(msg[2], &action)
+			c.calls <- csmsCall{Action: action, Payload: msg[3]}
+
+			res, ok := c.replies[action]
+			if !ok {
+				res = struct{}{}
+			}
+			if f, ok := res.(csmsReply); ok {
+				res = f(msg[3])
+			}
+			if e, ok := res.(*ocppError); ok {
+				c.write([]interface{}{ocppCallError, id, e.Code, e.Description, struct{}{}})
+				continue
+			}
+			c.write([]interface{}{ocppCallResult, id, res})
+
+		case ocppCallResult:
+			c.mu.Lock()
+			ch := c.results[id]
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- msg[2]
+			}
+		}
+	}
+}
+
+func (c *testCSMS) write(msg []interface{}) {
+	c.mu.Lock()
+	ws := c.ws
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	ws.WriteJSON(msg)
+}
+
+// call 由 CSMS 送 CALL 給 charge point 並回傳 CALLRESULT 的 payload
+func (c *testCSMS) call(action string, req interface{}) json.RawMessage {
+	c.t.Helper()
+
+	select {
+	case <-c.online:
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("charge point never connected")
+	}
+
+	id := uuid.NewString()
+	ch := make(chan json.RawMessage, 1)
+	c.mu.Lock()
+	c.results[id] = ch
+	c.mu.Unlock()
+
+	c.write([]interface{}{ocppCall, id, action, req})
+
+	select {
+	case res := <-ch:
+		return res
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("no reply to %s", action)
+		return nil
+	}
+}
+
+// expect 等下一個指定 action 的 CALL, 略過中間其他的 (例如 Heartbeat / MeterValues)
+func (c *testCSMS) expect(action string, v interface{}) {
+	c.t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case call := <-c.calls:
+			if call.Action != action {
+				continue
+			}
+			if v != nil {
+				if err := json.Unmarshal(call.Payload, v); err != nil {
+					c.t.Fatalf("%s payload: %v", action, err)
+				}
+			}
+			return
+		case <-timeout:
+			c.t.Fatalf("no %s received", action)
+		}
+	}
+}
+
+// withReplies 複製 base 並換掉 override 裡的 action
+func withReplies(base, override map[string]interface{}) map[string]interface{} {
+	replies := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		replies[k] = v
+	}
+	for k, v := range override {
+		replies[k] = v
+	}
+	return replies
+}
+
+// newTestStation 建立一台模擬閘道器 (站點 01, 主動推送狀態) 與連到它的 CANClient
+func newTestStation(t *testing.T) (*simulator.Gateway, *CANClient, *eventbus.EventBus) {
+	t.Helper()
+
+	gw, err := simulator.NewGateway("127.0.0.1:0", []string{"01"}, simulator.Options{PushInterval: 50 * time.Millisecond, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(gw.Close)
+
+	_, port, _ := net.SplitHostPort(gw.Addr().String())
+	eb := eventbus.New()
+	can := NewCANClient(config.Station{ID: "01", IP: "127.0.0.1", Port: port}, nil, eb, eventbus.NewReqBus())
+	t.Cleanup(can.Close)
+
+	can.WaitForConnection()
+	return gw, can, eb
+}
+
+// waitFor 輪詢直到 cond 成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
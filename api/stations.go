@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	klog "kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/types"
+)
+
+// ocppChargePoint 是 OCPP16ChargePoint / OCPP201ChargePoint 共同的生命週期
+type ocppChargePoint interface {
+	EndTransaction(ctx context.Context)
+	Close()
+}
+
+// StationRunner 依設定啟動每個站點的 CANClient 與 OCPP charge point,
+// 設定熱更新時只新增、移除或重連有變化的站點
+type StationRunner struct {
+	mu       sync.Mutex
+	manager  *CANManager
+	eb       *eventbus.EventBus
+	reqEb    *eventbus.RequestResponseBus
+	stations map[string]config.Station
+	ocpp     map[string]ocppChargePoint
+	closed   bool // Shutdown 後不再接受熱更新
+}
+
+func NewStationRunner(manager *CANManager, eb *eventbus.EventBus, reqEb *eventbus.RequestResponseBus) *StationRunner {
+	return &StationRunner{
+		manager:  manager,
+		eb:       eb,
+		reqEb:    reqEb,
+		stations: make(map[string]config.Station),
+		ocpp:     make(map[string]ocppChargePoint),
+	}
+}
+
+// Start 是開機時的啟動, 不等連線; 用 WaitReady 依啟動政策等待
+func (r *StationRunner) Start(stations []config.Station) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, st := range stations {
+		r.start(st)
+	}
+}
+
+// WaitReady 依 policy 等站點連上: "all" 全部 (預設), "any" 任一, "none" 不等;
+// ctx 結束時回傳錯誤
+func (r *StationRunner) WaitReady(ctx context.Context, policy string) error {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		connected, total := r.Connected()
+		switch {
+		case policy == "none",
+			policy == "any" && connected > 0,
+			(policy == "all" || policy == "") && connected == total:
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%d/%d stations connected: %w", connected, total, ctx.Err())
+		}
+	}
+}
+
+// Connected 回傳已連線的站點數與站點總數
+func (r *StationRunner) Connected() (int, int) {
+	clients := r.manager.GetAllClient()
+
+	connected := 0
+	for _, c := range clients {
+		if c.IsConnected() {
+			connected++
+		}
+	}
+	return connected, len(clients)
+}
+
+// Apply 比對新舊站點清單: 移除已刪除的、重連 ip/port 或其他設定有變的、啟動新增的,
+// 沒變化的站點不受影響
+func (r *StationRunner) Apply(stations []config.Station) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	next := make(map[string]config.Station, len(stations))
+	for _, st := range stations {
+		next[st.ID] = st
+	}
+
+	for id := range r.stations {
+		if _, ok := next[id]; !ok {
+			klog.Logger.Info(fmt.Sprintf("🔧 站點 %s 已從設定移除", id))
+			r.stop(id, "station removed from config")
+		}
+	}
+
+	for _, st := range stations {
+		old, ok := r.stations[st.ID]
+		switch {
+		case !ok:
+			klog.Logger.Info(fmt.Sprintf("🔧 新增站點 %s (%s)", st.ID, net.JoinHostPort(st.IP, st.Port)))
+			r.start(st)
+		case old.IP != st.IP || old.Port != st.Port:
+			klog.Logger.Info(fmt.Sprintf("🔧 站點 %s 位址變更 %s -> %s, 重新連線", st.ID,
+				net.JoinHostPort(old.IP, old.Port), net.JoinHostPort(st.IP, st.Port)))
+			r.stop(st.ID, "station address changed")
+			r.start(st)
+		case !reflect.DeepEqual(old, st):
+			klog.Logger.Info(fmt.Sprintf("🔧 站點 %s 設定變更, 重新啟動", st.ID))
+			r.stop(st.ID, "station config changed")
+			r.start(st)
+		}
+	}
+}
+
+// Shutdown 停止所有站點: stopCharging 時先對充電中的站點下 stop 並結束 OCPP 交易,
+// 等寫入佇列送完 (最多到 ctx 到期) 再關閉連線, 回傳關閉前的站號
+func (r *StationRunner) Shutdown(ctx context.Context, stopCharging bool) []string {
+	// 等 OCPP 與寫入佇列時不持有 mu, 這時的設定熱更新不會卡住 config watcher; closed 之後 Apply 不會再動站點
+	r.mu.Lock()
+	r.closed = true
+	ids := make([]string, 0, len(r.stations))
+	for id := range r.stations {
+		ids = append(ids, id)
+	}
+	cps := r.ocpp
+	r.ocpp = make(map[string]ocppChargePoint)
+	r.stations = make(map[string]config.Station)
+	r.mu.Unlock()
+
+	clients := r.manager.GetAllClient()
+	if stopCharging {
+		// 各站的 central system 各自回應, 與 CAN stop 指令同時進行
+		var wg sync.WaitGroup
+		for _, cp := range cps {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cp.EndTransaction(ctx)
+			}()
+		}
+
+		for id, c := range clients {
+			if !c.IsConnected() || !c.Telemetry().Charging {
+				continue
+			}
+			klog.Logger.Info(fmt.Sprintf("🛑 關機前停止站點 %s 充電", id))
+			if err := c.SendCommand("stop"); err != nil {
+				klog.Logger.Error(fmt.Sprintf("station %s stop failed: %v", id, err))
+			}
+		}
+		wg.Wait()
+	}
+
+	for _, cp := range cps {
+		cp.Close()
+	}
+
+	for _, c := range clients {
+		if err := c.Drain(ctx); err != nil {
+			klog.Logger.Warn(fmt.Sprintf("⚠️ %v", err))
+		}
+	}
+
+	r.manager.CloseAll()
+	return ids
+}
+
+func (r *StationRunner) start(st config.Station) {
+	client := r.manager.Start(st, r.eb, r.reqEb)
+	r.stations[st.ID] = st
+
+	if st.OCPP != nil {
+		switch st.OCPP.Version {
+		case "2.0.1":
+			r.ocpp[st.ID] = NewOCPP201ChargePoint(*st.OCPP, client, r.eb)
+		default:
+			r.ocpp[st.ID] = NewOCPP16ChargePoint(*st.OCPP, client, r.eb)
+		}
+	}
+}
+
+// stop 關閉站點並發佈離線狀態, 讓 MQTT 的 retained 狀態不會停在已連線
+func (r *StationRunner) stop(stationId, reason string) {
+	if cp, ok := r.ocpp[stationId]; ok {
+		cp.Close()
+		delete(r.ocpp, stationId)
+	}
+	r.manager.Remove(stationId)
+	delete(r.stations, stationId)
+
+	events.ConnectionTCP.With(stationId).Publish(r.eb, types.ConnectionTcp{
+		StationId: stationId,
+		IsConnect: false,
+		Msg:       reason,
+	})
+}
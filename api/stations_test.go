@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"kenmec/jimmy/charge_core/config"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/simulator"
+)
+
+// stop_policy 為 stop 時, 關機要先結束 OCPP 交易, 等 central system 回覆時也不能卡住 runner
+func TestStationRunnerShutdownEndsOCPPTransaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		replies func(release chan struct{}) map[string]interface{}
+		start   func(t *testing.T, csms *testCSMS)
+		ended   func(t *testing.T, payload json.RawMessage)
+	}{
+		{
+			name:    "1.6",
+			version: "1.6",
+			replies: func(release chan struct{}) map[string]interface{} {
+				return withReplies(ocpp16Replies, map[string]interface{}{
+					"StopTransaction": csmsReply(func(json.RawMessage) interface{} {
+						<-release
+						return ocpp16Replies["StopTransaction"]
+					}),
+				})
+			},
+			start: func(t *testing.T, csms *testCSMS) {
+				expectStatus(t, csms, "Available")
+				csms.call("RemoteStartTransaction", map[string]interface{}{"connectorId": 1, "idTag": "TAG1"})
+				csms.expect("StartTransaction", nil)
+			},
+			ended: func(t *testing.T, payload json.RawMessage) {
+				var stop ocpp16Stop
+				json.Unmarshal(payload, &stop)
+				if stop != (ocpp16Stop{TransactionId: 42, IdTag: "TAG1", Reason: "Other"}) {
+					t.Fatalf("StopTransaction = %+v", stop)
+				}
+			},
+		},
+		{
+			name:    "2.0.1",
+			version: "2.0.1",
+			replies: func(release chan struct{}) map[string]interface{} {
+				return withReplies(ocpp201Replies, map[string]interface{}{
+					"TransactionEvent": csmsReply(func(payload json.RawMessage) interface{} {
+						var ev ocpp201Event
+						json.Unmarshal(payload, &ev)
+						if ev.EventType == "Ended" {
+							<-release
+						}
+						return struct{}{}
+					}),
+				})
+			},
+			start: func(t *testing.T, csms *testCSMS) {
+				expectConnectorStatus(t, csms, "Available")
+				csms.call("RequestStartTransaction", map[string]interface{}{
+					"evseId":        1,
+					"remoteStartId": 5,
+					"idToken":       map[string]string{"idToken": "TAG1", "type": "ISO14443"},
+				})
+				expectTransactionEvent(t, csms, "Started")
+			},
+			ended: func(t *testing.T, payload json.RawMessage) {
+				var ev ocpp201Event
+				json.Unmarshal(payload, &ev)
+				if ev.EventType != "Ended" || ev.TriggerReason != "AbnormalCondition" || ev.TransactionInfo.StoppedReason != "Other" {
+					t.Fatalf("TransactionEvent = %+v", ev)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			subprotocol := "ocpp" + tt.version
+			csms := newTestCSMS(t, subprotocol, tt.replies(release))
+
+			gw, err := simulator.NewGateway("127.0.0.1:0", []string{"01"}, simulator.Options{PushInterval: 50 * time.Millisecond, Seed: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(gw.Close)
+			_, port, _ := net.SplitHostPort(gw.Addr().String())
+
+			manager := NewCANManager(config.Capture{})
+			runner := NewStationRunner(manager, eventbus.New(), eventbus.NewReqBus())
+			runner.Start([]config.Station{{
+				ID:   "01",
+				IP:   "127.0.0.1",
+				Port: port,
+				OCPP: &config.OCPP{URL: csms.URL(), Version: tt.version},
+			}})
+
+			csms.expect("BootNotification", nil)
+			tt.start(t, csms)
+			can, _ := manager.Get("01")
+			waitFor(t, "charging", func() bool { return can.Telemetry().Charging })
+
+			done := make(chan []string)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				done <- runner.Shutdown(ctx, true)
+			}()
+
+			// 等 central system 回覆結束交易
+			var ended csmsCall
+			deadline := time.After(5 * time.Second)
+			for ended.Action == "" {
+				select {
+				case call := <-csms.calls:
+					var ev ocpp201Event
+					json.Unmarshal(call.Payload, &ev)
+					// 2.0.1 略過之前的 Updated
+					if call.Action == "StopTransaction" || (call.Action == "TransactionEvent" && ev.EventType == "Ended") {
+						ended = call
+					}
+				case <-deadline:
+					t.Fatal("transaction not ended on shutdown")
+				}
+			}
+			tt.ended(t, ended.Payload)
+
+			// 這時 runner 不能持有 mu, 熱更新直接略過
+			applied := make(chan struct{})
+			go func() {
+				runner.Apply(nil)
+				close(applied)
+			}()
+			select {
+			case <-applied:
+			case <-time.After(time.Second):
+				t.Fatal("Apply blocked while Shutdown waits for the central system")
+			}
+			select {
+			case <-done:
+				t.Fatal("Shutdown returned before the central system replied")
+			default:
+			}
+
+			close(release)
+			select {
+			case ids := <-done:
+				if len(ids) != 1 || ids[0] != "01" {
+					t.Fatalf("Shutdown = %v", ids)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Shutdown did not return")
+			}
+			waitFor(t, "charger stopped", func() bool { return !gw.Charger("01").Status(time.Now()).Charging })
+		})
+	}
+}
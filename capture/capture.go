@@ -0,0 +1,188 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 方向
+const (
+	DirRx = "rx" // 從閘道器收到
+	DirTx = "tx" // 送給閘道器
+)
+
+// Record 是 capture 檔 (JSON Lines) 的一行
+type Record struct {
+	Time    time.Time `json:"t"`
+	Station string    `json:"station"`
+	Dir     string    `json:"dir"`
+	Data    string    `json:"data"` // hex
+}
+
+func (r Record) Bytes() ([]byte, error) {
+	return hex.DecodeString(r.Data)
+}
+
+// Writer 把 frame 寫進 capture 檔, 超過 maxSize 就輪替, 只保留 maxFiles 個舊檔
+type Writer struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func NewWriter(path string, maxSize int64, maxFiles int) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	w := &Writer{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// Write 記錄一個方向為 dir 的 frame
+func (w *Writer) Write(dir, station string, data []byte) error {
+	line, err := json.Marshal(Record{
+		Time:    time.Now(),
+		Station: station,
+		Dir:     dir,
+		Data:    hex.EncodeToString(data),
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return fmt.Errorf("capture %s is closed", w.path)
+	}
+
+	// 輪替失敗時照樣寫進原本的檔案, 不丟 frame, 下次超過上限時再試
+	var rotateErr error
+	if w.maxSize > 0 && w.size+int64(len(line)) > w.maxSize {
+		rotateErr = w.rotate()
+		if w.file == nil {
+			return rotateErr
+		}
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// rotate 把目前的檔案改名為 <name>-<時間>.jsonl 並刪掉過多的舊檔;
+// 改名失敗時重新開啟原本的檔案, w.file 只有在連原檔都開不了時才是 nil
+func (w *Writer) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	ext := filepath.Ext(w.path)
+	base := strings.TrimSuffix(w.path, ext)
+	rotated := rotatedName(base, ext, time.Now())
+	if err := os.Rename(w.path, rotated); err != nil {
+		err = fmt.Errorf("rotate %s: %w", w.path, err)
+		if oerr := w.open(); oerr != nil {
+			return errors.Join(err, oerr)
+		}
+		return err
+	}
+
+	if w.maxFiles > 0 {
+		old, _ := filepath.Glob(base + "-*" + ext)
+		sort.Strings(old)
+		for len(old) > w.maxFiles {
+			os.Remove(old[0])
+			old = old[1:]
+		}
+	}
+
+	return w.open()
+}
+
+// rotatedName 回傳還不存在的輪替檔名; 同一毫秒內輪替多次時加上 _01, _02 ..., 依檔名排序仍是時間順序
+func rotatedName(base, ext string, now time.Time) string {
+	stamp := now.Format("20060102T150405.000")
+	name := fmt.Sprintf("%s-%s%s", base, stamp, ext)
+	for i := 1; exists(name); i++ {
+		name = fmt.Sprintf("%s-%s_%02d%s", base, stamp, i, ext)
+	}
+	return name
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Read 依序讀出 capture 檔的每一筆紀錄
+func Read(r io.Reader, fn func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,101 @@
+package capture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countRecords 數 dir 下所有 capture 檔的紀錄
+func countRecords(t *testing.T, dir string) (files, records int) {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Read(f, func(Record) error {
+			records++
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+	}
+	return len(paths), records
+}
+
+func TestWriterRotateKeepsEveryFile(t *testing.T) {
+	dir := t.TempDir()
+	// 每筆紀錄都會超過上限, 同一毫秒內會輪替很多次
+	w, err := NewWriter(filepath.Join(dir, "capture-01.jsonl"), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		if err := w.Write(DirRx, "01", []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	files, records := countRecords(t, dir)
+	if records != n {
+		t.Fatalf("%d records in %d files, want %d: rotated files were overwritten", records, files, n)
+	}
+}
+
+func TestWriterRotateMaxFiles(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(filepath.Join(dir, "capture-01.jsonl"), 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := w.Write(DirTx, "01", []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	if files, _ := countRecords(t, dir); files != 4 {
+		t.Fatalf("%d files, want 3 rotated + current", files)
+	}
+}
+
+func TestWriterRotateRenameFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture-01.jsonl")
+	w, err := NewWriter(path, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.Write(DirRx, "01", []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 檔案被移走後改名會失敗, 要回到原本的路徑繼續寫
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(DirRx, "01", make([]byte, 40)); err == nil {
+		t.Fatal("rotate error not reported")
+	}
+	if err := w.Write(DirRx, "01", []byte{2}); err != nil {
+		t.Fatalf("Write after failed rotate: %v", err)
+	}
+
+	if _, records := countRecords(t, dir); records != 2 {
+		t.Fatalf("%d records, want the 2 written after the failed rotate", records)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"kenmec/jimmy/charge_core/tool"
+)
+
+// decode 離線解析一個 frame, checksum 錯誤時仍列出各欄位
+func decode(driver tool.ProtocolDriver, s string) error {
+	s = strings.NewReplacer(" ", "", ":", "", "0x", "").Replace(s)
+	pkt, err := hex.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("invalid hex: %w", err)
+	}
+	n := driver.FrameLen()
+	if len(pkt) != n {
+		return fmt.Errorf("frame is %d bytes, want %d for protocol %s", len(pkt), n, driver.Name())
+	}
+
+	fmt.Printf("header     % x\n", pkt[0:2])
+	if info, err := tool.ParseFrameInfo(pkt[2]); err != nil {
+		fmt.Printf("frame info %02x BAD (%v)\n", pkt[2], err)
+	} else {
+		kind, typ := "standard", "data"
+		if info.Extended {
+			kind = "extended"
+		}
+		if info.Remote {
+			typ = "remote"
+		}
+		fmt.Printf("frame info %02x (%s %s frame, DLC %d)\n", pkt[2], kind, typ, info.DLC)
+	}
+	fmt.Printf("can id     % x (%#x)\n", pkt[3:7], binary.BigEndian.Uint32(pkt[3:7]))
+	fmt.Printf("station    %02x\n", pkt[7])
+	fmt.Printf("payload    % x\n", pkt[8:15])
+
+	got, want := pkt[15:], driver.Checksum(pkt)
+	if string(want) == string(got) {
+		fmt.Printf("checksum   % x OK\n", got)
+	} else {
+		fmt.Printf("checksum   % x BAD (want % x)\n", got, want)
+		return nil
+	}
+
+	frame, err := driver.DecodeFrame(pkt)
+	if err != nil {
+		return err
+	}
+
+	if cmd, ok := tool.DecodeCommand(frame); ok {
+		fmt.Printf("as command %s\n", cmd)
+	}
+
+	st := tool.DecodeStatus(frame)
+	fmt.Printf("as status  charging=%v voltage=%.1fV current=%.1fA temperature=%d°C faults=%08b\n",
+		st.Charging, st.Voltage, st.Current, st.Temperature, st.FaultBits)
+	return nil
+}
+
+func encode(driver tool.ProtocolDriver, station, cmd string) error {
+	if b, err := hex.DecodeString(station); err != nil || len(b) != 1 {
+		return fmt.Errorf("station id %q must be two hex characters", station)
+	}
+
+	pkt, err := driver.EncodeCommand(station, cmd)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+
+	fmt.Println(hex.EncodeToString(pkt))
+	return nil
+}
@@ -0,0 +1,143 @@
+// chargectl 是給現場人員用的命令列工具
+//
+//	chargectl list                  列出各站連線與充電狀態
+//	chargectl start <station>       下 start 並等待結果
+//	chargectl stop <station>        下 stop 並等待結果
+//	chargectl send <station> <cmd> [args] 下任一指令並等待結果, 例如 reset、set_current_limit 32
+//	chargectl commands              列出所有指令與參數
+//	chargectl watch [station]       持續顯示遙測
+//	chargectl decode <hex>          解析一個原始 frame (離線)
+//	chargectl encode <station> <cmd> [args] 印出 tool.Command 會送出的 bytes (離線)
+//	chargectl replay [-to addr] <file> 解碼 capture 檔, 或重送給模擬器
+//
+// list / start / stop / send / watch 透過服務的 MQTT topic 溝通。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"kenmec/jimmy/charge_core/tool"
+)
+
+type options struct {
+	broker   string
+	user     string
+	password string
+	timeout  time.Duration
+	protocol string
+	canID    string
+	canRxID  string
+	extended bool
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.broker, "broker", "tcp://localhost:1883", "MQTT broker")
+	flag.StringVar(&opts.user, "user", "admin", "MQTT 帳號")
+	flag.StringVar(&opts.password, "password", "admin", "MQTT 密碼")
+	flag.DurationVar(&opts.timeout, "timeout", 10*time.Second, "等待結果的時間")
+	flag.StringVar(&opts.protocol, "protocol", "", "decode / encode / replay 使用的 frame 格式: "+strings.Join(tool.DriverNames(), " / "))
+	flag.StringVar(&opts.canID, "can-id", "", "encode 使用的 CAN ID, 例如 0x18ff50e5, 空白表示原本的 0xf00")
+	flag.StringVar(&opts.canRxID, "can-rx-id", "", "充電機回覆的 CAN ID, 空白表示同 -can-id")
+	flag.BoolVar(&opts.extended, "extended", false, "使用 29-bit 擴展幀")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	addr, err := tool.ParseCANAddress(opts.canID, opts.canRxID, opts.extended)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+	driver, err := tool.NewDriver(opts.protocol, addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		err = list(opts)
+	case "start", "stop":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		err = command(opts, args[1], args[0])
+	case "send":
+		if len(args) < 3 {
+			usage()
+			os.Exit(2)
+		}
+		err = command(opts, args[1], strings.Join(args[2:], " "))
+	case "commands":
+		printCommands()
+	case "watch":
+		station := "+"
+		if len(args) > 1 {
+			station = args[1]
+		}
+		err = watch(opts, station)
+	case "decode":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		err = decode(driver, args[1])
+	case "encode":
+		if len(args) < 3 {
+			usage()
+			os.Exit(2)
+		}
+		err = encode(driver, args[1], strings.Join(args[2:], " "))
+	case "replay":
+		err = replay(driver, args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: chargectl [flags] <command> [args]
+
+commands:
+  list                     列出各站連線與充電狀態
+  start <station>          下 start 並等待結果
+  stop <station>           下 stop 並等待結果
+  send <station> <cmd> [args]
+                           下任一指令並等待結果, 例如 send 01 set_current_limit 32
+  commands                 列出所有指令與參數
+  watch [station]          持續顯示遙測
+  decode <hex>             解析一個原始 frame
+  encode <station> <cmd> [args]
+                           印出送給充電機的 bytes
+  replay [-to addr] <file> 解碼 capture 檔, 或把送出的 frame 重送給模擬器
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func printCommands() {
+	for _, c := range tool.Commands {
+		fmt.Printf("%-45s %s\n", c.Usage(), c.Doc)
+		if len(c.Aliases) > 0 {
+			fmt.Printf("%-45s 別名: %s\n", "", strings.Join(c.Aliases, ", "))
+		}
+	}
+}
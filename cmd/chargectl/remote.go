@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kenmec/jimmy/charge_core/types"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func connect(opts options) (mqtt.Client, error) {
+	mo := mqtt.NewClientOptions()
+	mo.AddBroker(opts.broker)
+	mo.SetClientID(fmt.Sprintf("chargectl_%d", time.Now().UnixNano()))
+	mo.SetUsername(opts.user)
+	mo.SetPassword(opts.password)
+
+	client := mqtt.NewClient(mo)
+	token := client.Connect()
+	if !token.WaitTimeout(opts.timeout) {
+		return nil, fmt.Errorf("connect to %s timed out", opts.broker)
+	}
+	if token.Error() != nil {
+		return nil, token.Error()
+	}
+	return client, nil
+}
+
+func subscribe(client mqtt.Client, topic string, handler mqtt.MessageHandler) error {
+	token := client.Subscribe(topic, 0, handler)
+	token.Wait()
+	return token.Error()
+}
+
+// stationOf 取出 charge_station/<id>/... 的 id
+func stationOf(topic string) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+type stationRow struct {
+	conn      *types.ConnectionTcp
+	telemetry *types.ChargerTelemetry
+}
+
+// list 收集 retained 的連線狀態與遙測後印出
+func list(opts options) error {
+	client, err := connect(opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(100)
+
+	var mu sync.Mutex
+	rows := make(map[string]*stationRow)
+	row := func(id string) *stationRow {
+		if rows[id] == nil {
+			rows[id] = &stationRow{}
+		}
+		return rows[id]
+	}
+
+	err = subscribe(client, "charge_station/+/connection/tcp", func(c mqtt.Client, m mqtt.Message) {
+		var d types.ConnectionTcp
+		if json.Unmarshal(m.Payload(), &d) == nil {
+			mu.Lock()
+			row(stationOf(m.Topic())).conn = &d
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	err = subscribe(client, "charge_station/+/telemetry", func(c mqtt.Client, m mqtt.Message) {
+		var d types.ChargerTelemetry
+		if json.Unmarshal(m.Payload(), &d) == nil {
+			mu.Lock()
+			row(stationOf(m.Topic())).telemetry = &d
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// retained 訊息訂閱後會立即送達
+	time.Sleep(1500 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	ids := make([]string, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fmt.Printf("%-8s %-10s %-9s %9s %9s %6s %-8s %s\n", "STATION", "CONNECTED", "CHARGING", "VOLTAGE", "CURRENT", "TEMP", "FAULTS", "UPDATED")
+	for _, id := range ids {
+		r := rows[id]
+		connected := "?"
+		if r.conn != nil {
+			connected = fmt.Sprint(r.conn.IsConnect)
+		}
+		if r.telemetry == nil {
+			fmt.Printf("%-8s %-10s %-9s %9s %9s %6s %-8s %s\n", id, connected, "-", "-", "-", "-", "-", "-")
+			continue
+		}
+		t := r.telemetry
+		fmt.Printf("%-8s %-10s %-9v %8.1fV %8.1fA %5d° %08b %s\n", id, connected, t.Charging, t.Voltage, t.Current, t.Temperature, t.FaultBits, t.Timestamp.Local().Format("15:04:05"))
+	}
+	return nil
+}
+
+// command 送出指令, 等服務回覆結果; start / stop 再等遙測確認充電狀態改變
+func command(opts options, station, cmd string) error {
+	client, err := connect(opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(100)
+
+	results := make(chan types.CommandResult, 1)
+	charging := make(chan bool, 16)
+
+	err = subscribe(client, "charge_station/"+station+"/command/result", func(c mqtt.Client, m mqtt.Message) {
+		var d types.CommandResult
+		if json.Unmarshal(m.Payload(), &d) == nil && d.Cmd == cmd {
+			select {
+			case results <- d:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	err = subscribe(client, "charge_station/"+station+"/telemetry", func(c mqtt.Client, m mqtt.Message) {
+		var d types.ChargerTelemetry
+		// 略過訂閱時送來的 retained 遙測, 那是指令送出前的狀態
+		if !m.Retained() && json.Unmarshal(m.Payload(), &d) == nil {
+			select {
+			case charging <- d.Charging:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	token := client.Publish("charge_station/"+station+"/command", 0, false, cmd)
+	token.Wait()
+	if token.Error() != nil {
+		return token.Error()
+	}
+
+	deadline := time.After(opts.timeout)
+
+	select {
+	case r := <-results:
+		if !r.Ok {
+			return fmt.Errorf("station %s rejected %s: %s", station, cmd, r.Error)
+		}
+		fmt.Printf("station %s queued %s\n", station, cmd)
+	case <-deadline:
+		return fmt.Errorf("no result from station %s within %v (is the service running?)", station, opts.timeout)
+	}
+
+	// 只有 start / stop 會改變充電狀態
+	if cmd != "start" && cmd != "stop" {
+		return nil
+	}
+
+	want := cmd == "start"
+	for {
+		select {
+		case c := <-charging:
+			if c == want {
+				fmt.Printf("station %s confirmed charging=%v\n", station, c)
+				return nil
+			}
+		case <-deadline:
+			return fmt.Errorf("station %s did not report charging=%v within %v (is poll_interval set?)", station, want, opts.timeout)
+		}
+	}
+}
+
+// watch 持續印出遙測, Ctrl-C 結束
+func watch(opts options, station string) error {
+	client, err := connect(opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(100)
+
+	err = subscribe(client, "charge_station/"+station+"/telemetry", func(c mqtt.Client, m mqtt.Message) {
+		var t types.ChargerTelemetry
+		if json.Unmarshal(m.Payload(), &t) != nil {
+			return
+		}
+		fmt.Printf("%s station=%s charging=%v voltage=%.1fV current=%.1fA temperature=%d° faults=%08b energy=%.0fWh\n",
+			t.Timestamp.Local().Format("15:04:05"), t.StationId, t.Charging, t.Voltage, t.Current, t.Temperature, t.FaultBits, t.EnergyWh)
+	})
+	if err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"kenmec/jimmy/charge_core/capture"
+	"kenmec/jimmy/charge_core/tool"
+)
+
+// replay 把 capture 檔餵回解碼器, 或加上 -to 時把送出的 frame 依原本的時間間隔重送給模擬器
+func replay(driver tool.ProtocolDriver, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	to := fs.String("to", "", "模擬器位址, 空白表示只解碼")
+	speed := fs.Float64("speed", 1, "重送倍速, 0 表示不等待")
+	station := fs.String("station", "", "只處理這個站號")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chargectl replay [-to addr] [-speed n] [-station id] <capture.jsonl>")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if *to == "" {
+		return replayDecode(driver, f, *station)
+	}
+	return replayTo(driver, f, *to, *speed, *station)
+}
+
+// replayDecode 以與 CANClient 相同的切 frame 方式解碼, 重現當時服務看到的內容
+func replayDecode(driver tool.ProtocolDriver, f *os.File, station string) error {
+	splitters := make(map[string]*tool.FrameSplitter)
+
+	return capture.Read(f, func(rec capture.Record) error {
+		if station != "" && rec.Station != station {
+			return nil
+		}
+
+		data, err := rec.Bytes()
+		if err != nil {
+			return err
+		}
+
+		key := rec.Station + "/" + rec.Dir
+		if splitters[key] == nil {
+			splitters[key] = &tool.FrameSplitter{Driver: driver}
+		}
+
+		frames, dropped := splitters[key].Feed(data)
+		if dropped > 0 {
+			fmt.Printf("%s %s %s dropped %d bytes (bad checksum / framing)\n", rec.Time.Local().Format("15:04:05.000"), rec.Station, rec.Dir, dropped)
+		}
+		for _, fr := range frames {
+			fmt.Printf("%s %s %s %s\n", rec.Time.Local().Format("15:04:05.000"), rec.Station, rec.Dir, describe(rec.Dir, fr))
+		}
+		return nil
+	})
+}
+
+// replayTo 把 tx 紀錄送到模擬器, 印出模擬器的回覆
+func replayTo(driver tool.ProtocolDriver, f *os.File, addr string, speed float64, station string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		splitter := tool.FrameSplitter{Driver: driver}
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			frames, _ := splitter.Feed(buf[:n])
+			for _, fr := range frames {
+				fmt.Printf("%s %s rx %s\n", time.Now().Format("15:04:05.000"), fr.StationId, describe(capture.DirRx, fr))
+			}
+		}
+	}()
+
+	var last time.Time
+	err = capture.Read(f, func(rec capture.Record) error {
+		if rec.Dir != capture.DirTx || (station != "" && rec.Station != station) {
+			return nil
+		}
+
+		if speed > 0 && !last.IsZero() {
+			time.Sleep(time.Duration(float64(rec.Time.Sub(last)) / speed))
+		}
+		last = rec.Time
+
+		data, err := rec.Bytes()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %s tx % x\n", time.Now().Format("15:04:05.000"), rec.Station, data)
+		_, err = conn.Write(data)
+		return err
+	})
+
+	// 等最後一個回覆
+	time.Sleep(time.Second)
+	return err
+}
+
+func describe(dir string, f tool.Frame) string {
+	if dir == capture.DirTx {
+		if cmd, ok := tool.DecodeCommand(f); ok {
+			return "command " + cmd
+		}
+		return fmt.Sprintf("unknown command % x", f.Data)
+	}
+
+	st := tool.DecodeStatus(f)
+	return fmt.Sprintf("status charging=%v voltage=%.1fV current=%.1fA temperature=%d° faults=%08b",
+		st.Charging, st.Voltage, st.Current, st.Temperature, st.FaultBits)
+}
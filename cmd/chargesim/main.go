@@ -0,0 +1,126 @@
+// chargesim 模擬一台 CAN 轉 Ethernet 閘道器與底下的充電機, 讓沒有實體充電機時也能跑 `go run .`
+//
+//	go run ./cmd/chargesim -listen 127.0.0.1:8000 -stations 01,02 -latency 50ms -drop 0.05
+//	go run ./cmd/chargesim -scenario cmd/chargesim/scenarios/over_temperature.yaml -fake-clock -speed 10
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	klog "kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/simulator"
+	"kenmec/jimmy/charge_core/tool"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8000", "閘道器監聽位址; -transport tcp_server 時是要連過去的服務位址")
+	stations := flag.String("stations", "01,02", "模擬的站號, 以逗號分隔")
+
+	var opts simulator.Options
+	flag.DurationVar(&opts.Latency, "latency", 0, "回覆延遲")
+	flag.DurationVar(&opts.Jitter, "jitter", 0, "額外隨機延遲上限")
+	flag.Float64Var(&opts.DropRate, "drop", 0, "不回覆 read 的機率 (0-1)")
+	flag.Float64Var(&opts.CorruptRate, "corrupt", 0, "回覆 checksum 錯誤的機率 (0-1)")
+	flag.DurationVar(&opts.DisconnectAfter, "disconnect", 0, "平均多久突然斷線一次, 0 表示不斷線")
+	flag.Int64Var(&opts.Seed, "seed", 0, "亂數種子, 0 表示用目前時間")
+	scenarioPath := flag.String("scenario", "", "情境 YAML 檔")
+	fakeClock := flag.Bool("fake-clock", false, "使用假時鐘, 情境與充電曲線只依模擬時間推進")
+	speed := flag.Float64("speed", 1, "假時鐘相對於真實時間的倍速")
+	exitAfter := flag.Bool("exit", false, "情境結束後離開")
+	protocol := flag.String("protocol", "", "充電機 frame 格式: "+strings.Join(tool.DriverNames(), " / "))
+	canID := flag.String("can-id", "", "服務送給充電機的 CAN ID, 例如 0x18ff50e5, 空白表示原本的 0xf00")
+	canRxID := flag.String("can-rx-id", "", "充電機回覆的 CAN ID, 空白表示同 -can-id")
+	extended := flag.Bool("extended", false, "使用 29-bit 擴展幀")
+	flag.StringVar(&opts.Transport, "transport", "", "服務端的 transport: tcp (預設, 等服務連進來) / tcp_server (主動連到服務) / udp / socketcan (-listen 為 CAN 介面, 例如 vcan0)")
+	flag.StringVar(&opts.RegisterID, "register", "", "tcp_server 連上後先送的註冊封包")
+	flag.DurationVar(&opts.PushInterval, "push", 0, "充電機主動送出狀態的間隔, 0 表示只回覆 read")
+	flag.Parse()
+
+	logger, _ := zap.NewDevelopment()
+	klog.Logger = logger
+
+	addr, err := tool.ParseCANAddress(*canID, *canRxID, *extended)
+	if err != nil {
+		fail(err)
+	}
+	// 模擬器站在充電機那一端, 收送的 CAN ID 與服務相反
+	driver, err := tool.NewDriver(*protocol, addr.Reverse())
+	if err != nil {
+		fail(err)
+	}
+	opts.Driver = driver
+
+	ids := strings.Split(*stations, ",")
+
+	var scenario *simulator.Scenario
+	if *scenarioPath != "" {
+		s, err := simulator.LoadScenario(*scenarioPath)
+		if err != nil {
+			fail(err)
+		}
+		scenario = s
+		if len(s.Stations) > 0 {
+			ids = s.Stations
+		}
+		if s.Seed != 0 {
+			opts.Seed = s.Seed
+		}
+	}
+
+	var clock *simulator.FakeClock
+	if *fakeClock {
+		clock = simulator.NewFakeClock(time.Now())
+		opts.Clock = clock
+	}
+
+	gw, err := simulator.NewGateway(*listen, ids, opts)
+	if err != nil {
+		fail(err)
+	}
+	klog.Logger.Info(fmt.Sprintf("simulator listening on %s, stations %s", gw.Addr(), strings.Join(ids, ",")))
+
+	var done <-chan struct{}
+	if scenario != nil {
+		run, err := gw.RunScenario(scenario)
+		if err != nil {
+			fail(err)
+		}
+		if *exitAfter {
+			done = run.Done()
+		}
+	}
+
+	if clock != nil {
+		// 每 50ms 真實時間推進 50ms * speed 的模擬時間
+		go func() {
+			step := 50 * time.Millisecond
+			for range time.Tick(step) {
+				clock.Advance(time.Duration(float64(step) * *speed))
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-sig:
+	case <-done:
+		klog.Logger.Info("scenario finished")
+	}
+
+	gw.Close()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
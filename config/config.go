@@ -2,27 +2,163 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 
+	"kenmec/jimmy/charge_core/tool"
+	"kenmec/jimmy/charge_core/transport"
+
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 type Station struct {
-	ID   string `mapstructure:"id"`
-	IP   string `mapstructure:"ip"`
-	Port string `mapstructure:"port"`
+	ID           string `mapstructure:"id"`
+	IP           string `mapstructure:"ip"`
+	Port         string `mapstructure:"port"`
+	PollInterval int    `mapstructure:"poll_interval"` // 狀態輪詢秒數, 0 表示不輪詢 (udp / socketcan 必填)
+	Protocol     string `mapstructure:"protocol"`      // 充電機 frame 格式: "default" (預設) / "crc16"
+	CAN          *CAN   `mapstructure:"can"`           // 沒設定就沿用原本的 00000f00 標準幀
+	Transport    string `mapstructure:"transport"`     // "tcp" (預設, 連到閘道器) / "tcp_server" (閘道器連進來) / "udp" / "socketcan"
+	Interface    string `mapstructure:"interface"`     // socketcan 的 CAN 介面, 例如 "can0"
+	Listen       string `mapstructure:"listen"`        // tcp_server 的監聽位址; udp 的本機綁定位址, 空白表示隨機 port
+	AliveTimeout int    `mapstructure:"alive_timeout"` // udp / socketcan 多少秒沒收到 frame 視為斷線, 預設 3 倍 poll_interval 且至少 10 秒
+	Identify     string `mapstructure:"identify"`      // tcp_server 識別閘道器: "ip" (有設定 ip 時預設) / "station" / "register"
+	RegisterID   string `mapstructure:"register_id"`   // identify 為 register 時閘道器連上後送的註冊封包
+	OCPP         *OCPP  `mapstructure:"ocpp"`          // 沒設定就不連 central system
+	ModbusUnit   int    `mapstructure:"modbus_unit"`   // Modbus unit id, 預設為站號的 hex 值
+	Capture      bool   `mapstructure:"capture"`       // 記錄收送的原始 frame
+}
+
+// CAN 是站點在 CAN bus 上的定址
+type CAN struct {
+	ID       uint32  `mapstructure:"id"`       // 送給充電機的 CAN ID, 例如 0x18ff50e5
+	RxID     *uint32 `mapstructure:"rx_id"`    // 充電機回覆的 CAN ID, 預設同 id
+	Extended bool    `mapstructure:"extended"` // 29-bit 擴展幀, 預設 11-bit 標準幀
+}
+
+// OCPP 是單一站點對 central system 的 charge point 設定
+type OCPP struct {
+	Version          string `mapstructure:"version"`         // "1.6" (預設) 或 "2.0.1"
+	URL              string `mapstructure:"url"`             // 例如 ws://csms:9000/ocpp, 會再接上 charge_point_id
+	ChargePointID    string `mapstructure:"charge_point_id"` // 預設 "CP" + station id
+	Vendor           string `mapstructure:"vendor"`
+	Model            string `mapstructure:"model"`
+	MeterInterval    int    `mapstructure:"meter_interval"`     // MeterValues / TransactionEvent 秒數, 預設 60
+	SecurityProfile  int    `mapstructure:"security_profile"`   // 0 無, 1 basic auth, 2 TLS + basic auth, 3 TLS client 憑證
+	Password         string `mapstructure:"password"`           // basic auth 密碼, 帳號為 charge_point_id
+	CAFile           string `mapstructure:"ca_file"`            // central system 的 CA, 空白則用系統 CA
+	CertFile         string `mapstructure:"cert_file"`          // security profile 3
+	KeyFile          string `mapstructure:"key_file"`           // security profile 3
+	OfflineQueueSize int    `mapstructure:"offline_queue_size"` // 離線時暫存的交易訊息數, 預設 1000
+}
+
+// Modbus 是給 PLC 用的 Modbus TCP server 設定
+type Modbus struct {
+	Enabled    bool     `mapstructure:"enabled"`
+	Listen     string   `mapstructure:"listen"`      // 例如 "0.0.0.0:502"
+	Writable   bool     `mapstructure:"writable"`    // false 時 coil / holding register 唯讀
+	WriteAllow []string `mapstructure:"write_allow"` // 允許寫入的 PLC IP, 空白表示不限制
+}
+
+// MQTT 是 broker 連線設定, 空白欄位沿用預設值
+type MQTT struct {
+	Broker            string `mapstructure:"broker"`             // 預設 tcp://localhost:1883
+	User              string `mapstructure:"user"`               // 預設 admin
+	Password          string `mapstructure:"password"`           // 預設 admin
+	ClientID          string `mapstructure:"client_id"`          // 預設 go_charger_<時間>
+	HeartbeatInterval int    `mapstructure:"heartbeat_interval"` // charge_station/heartbeat 秒數, 預設 6
+}
+
+// Capture 是原始 frame 記錄檔的位置與輪替設定
+type Capture struct {
+	Dir       string `mapstructure:"dir"`         // 預設 ~/kenmec/_logs/charge_station/capture
+	MaxSizeMB int    `mapstructure:"max_size_mb"` // 單檔上限, 預設 10
+	MaxFiles  int    `mapstructure:"max_files"`   // 保留的舊檔數, 預設 10
+}
+
+// Alarm 是告警歷史的保存設定
+type Alarm struct {
+	HistoryFile string `mapstructure:"history_file"` // JSON Lines, 預設 ~/kenmec/_logs/charge_station/alarms.jsonl
+	HistorySize int    `mapstructure:"history_size"` // 保留與查詢的筆數, 預設 1000
+}
+
+// HTTP 是給維護人員查詢 / 確認告警的 HTTP API
+type HTTP struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Listen  string `mapstructure:"listen"` // 例如 "0.0.0.0:8080"
+}
+
+// Startup 決定什麼時候算啟動完成 (送出 systemd READY=1)
+type Startup struct {
+	ReadyPolicy  string `mapstructure:"ready_policy"`  // "all" (預設) 全部站點連上, "any" 任一站點, "none" 不等
+	ReadyTimeout int    `mapstructure:"ready_timeout"` // 最多等待的秒數, 逾時仍送 READY=1 並在 STATUS 標示 degraded, 預設 60
+}
+
+// Shutdown 是收到 SIGTERM / SIGINT 時的處理方式
+type Shutdown struct {
+	StopPolicy string `mapstructure:"stop_policy"` // "leave" (預設) 不動充電中的站點, "stop" 關機前先下 stop
+	Timeout    int    `mapstructure:"timeout"`     // 關機最多等待的秒數, 預設 10
+}
+
+// EventBus 是模組之間 event bus / request bus 的設定
+type EventBus struct {
+	PanicLimit int `mapstructure:"panic_limit"` // handler 連續 panic 幾次後停用, 0 (預設) 表示不停用
+}
+
+// Log 是日誌輸出與輪替設定, 只有 level 可以熱更新
+type Log struct {
+	Dir          string `mapstructure:"dir"`           // 預設 ~/kenmec/_logs/charge_station
+	Level        string `mapstructure:"level"`         // debug (預設) / info / warn / error
+	MaxSizeMB    int    `mapstructure:"max_size_mb"`   // 單檔上限, 預設 100, 0 表示只在午夜輪替
+	MaxAgeDays   int    `mapstructure:"max_age_days"`  // 舊檔保留天數, 預設 30, 0 表示不限
+	MaxTotalMB   int    `mapstructure:"max_total_mb"`  // 舊檔合計上限, 預設 1024, 0 表示不限
+	Compress     bool   `mapstructure:"compress"`      // 舊檔以 gzip 壓縮, 預設 true
+	StationFiles bool   `mapstructure:"station_files"` // 每個站點另外寫一份 <dir>/stations/station-<id>-YYYY-MM-DD.log
 }
 
 type Config struct {
 	Stations []Station `mapstructure:"stations"`
+	Log      Log       `mapstructure:"log"`
+	MQTT     MQTT      `mapstructure:"mqtt"`
+	Modbus   Modbus    `mapstructure:"modbus"`
+	Capture  Capture   `mapstructure:"capture"`
+	Alarm    Alarm     `mapstructure:"alarm"`
+	HTTP     HTTP      `mapstructure:"http"`
+	Startup  Startup   `mapstructure:"startup"`
+	Shutdown Shutdown  `mapstructure:"shutdown"`
+	EventBus EventBus  `mapstructure:"event_bus"`
 }
 
-func LoadConfig() (*Config, error) {
-	viper.SetConfigName("config") // yaml 檔名稱 (config.yaml)
-	viper.SetConfigType("yaml")   //
-	viper.AddConfigPath(".")      // 從root 找file
+// flagKeys 是可以用命令列參數覆寫的設定, flag 名稱 -> 設定路徑
+var flagKeys = map[string]string{
+	"mqtt-broker": "mqtt.broker",
+	"log-dir":     "log.dir",
+	"log-level":   "log.level",
+}
+
+// boundFlags 讓熱更新重新讀檔時套用同一組命令列覆寫
+var boundFlags *pflag.FlagSet
+
+// LoadConfig 讀取設定檔, path 空白時找目前目錄的 config.yaml;
+// flags 中有設定的覆寫參數 (例如 --mqtt-broker) 優先於檔案內容
+func LoadConfig(path string, flags *pflag.FlagSet) (*Config, error) {
+	if path != "" {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName("config") // yaml 檔名稱 (config.yaml)
+		viper.AddConfigPath(".")      // 從root 找file
+	}
+	viper.SetConfigType("yaml")
 
 	// viper.AutomaticEnv()
 
+	boundFlags = flags
+	if err := prepare(viper.GetViper()); err != nil {
+		return nil, err
+	}
+
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
@@ -31,6 +167,92 @@ func LoadConfig() (*Config, error) {
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("unable to decode into struct: %w", err)
 	}
+	config.normalize()
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
+
+// normalize 把站號轉成小寫: frame 解出的站號是小寫, 設定成 "0A" 時
+// station.0A.* 的 topic 收不到 0a 的遙測, MQTT / Modbus 的指令也送不到
+func (c *Config) normalize() {
+	for i := range c.Stations {
+		c.Stations[i].ID = strings.ToLower(c.Stations[i].ID)
+	}
+}
+
+// prepare 設定預設值並綁定命令列覆寫, 讓 print-config 看到的就是實際生效的值
+func prepare(v *viper.Viper) error {
+	v.SetDefault("log.level", "debug")
+	v.SetDefault("log.max_size_mb", 100)
+	v.SetDefault("log.max_age_days", 30)
+	v.SetDefault("log.max_total_mb", 1024)
+	v.SetDefault("log.compress", true)
+	v.SetDefault("mqtt.broker", "tcp://localhost:1883")
+	v.SetDefault("mqtt.user", "admin")
+	v.SetDefault("mqtt.password", "admin")
+	v.SetDefault("mqtt.heartbeat_interval", 6)
+	v.SetDefault("capture.max_size_mb", 10)
+	v.SetDefault("capture.max_files", 10)
+	v.SetDefault("alarm.history_size", 1000)
+	v.SetDefault("http.listen", "0.0.0.0:8080")
+	v.SetDefault("startup.ready_policy", "all")
+	v.SetDefault("startup.ready_timeout", 60)
+	v.SetDefault("shutdown.stop_policy", "leave")
+	v.SetDefault("shutdown.timeout", 10)
+
+	if boundFlags == nil {
+		return nil
+	}
+	for name, key := range flagKeys {
+		if f := boundFlags.Lookup(name); f != nil {
+			if err := v.BindPFlag(key, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Addr 回傳 log 中表示站點連線的位址: tcp_server 是監聽位址, socketcan 是介面名稱, 其他為 ip:port
+func (st Station) Addr() string {
+	switch st.Transport {
+	case transport.KindTCPServer:
+		return st.Listen
+	case transport.KindSocketCAN:
+		return st.Interface
+	}
+	return net.JoinHostPort(st.IP, st.Port)
+}
+
+// CANAddress 回傳站點的 CAN 定址, 沒設定 can 時為 tool.DefaultCANAddress,
+// 沒設定 rx_id 時充電機以同一個 ID 回覆
+func (st Station) CANAddress() tool.CANAddress {
+	if st.CAN == nil {
+		return tool.DefaultCANAddress
+	}
+	addr := tool.CANAddress{ID: st.CAN.ID, RxID: st.CAN.ID, Extended: st.CAN.Extended}
+	if st.CAN.RxID != nil {
+		addr.RxID = *st.CAN.RxID
+	}
+	return addr
+}
+
+// ModbusUnitId 沒設定 modbus_unit 時用站號的 hex 值
+func (st Station) ModbusUnitId() (byte, error) {
+	if st.ModbusUnit != 0 {
+		if st.ModbusUnit < 1 || st.ModbusUnit > 247 {
+			return 0, fmt.Errorf("modbus_unit %d out of range 1-247", st.ModbusUnit)
+		}
+		return byte(st.ModbusUnit), nil
+	}
+
+	n, err := strconv.ParseUint(st.ID, 16, 8)
+	if err != nil || n < 1 || n > 247 {
+		return 0, fmt.Errorf("station %s needs modbus_unit (1-247)", st.ID)
+	}
+	return byte(n), nil
+}
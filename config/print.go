@@ -0,0 +1,45 @@
+package config
+
+import (
+	"io"
+
+	"github.com/spf13/viper"
+)
+
+// secretKeys 的值在 print-config 時以 ****** 取代
+var secretKeys = map[string]bool{
+	"password": true,
+}
+
+// PrintConfig 以 YAML 印出 LoadConfig 後實際生效的設定 (檔案 + 預設值 + 命令列覆寫), 密碼會遮蔽
+func PrintConfig(w io.Writer) error {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.MergeConfigMap(redact(viper.AllSettings()).(map[string]interface{})); err != nil {
+		return err
+	}
+	return v.WriteConfigTo(w)
+}
+
+func redact(value interface{}) interface{} {
+	switch val := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			if s, ok := v.(string); ok && secretKeys[k] && s != "" {
+				out[k] = "******"
+				continue
+			}
+			out[k] = redact(v)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, v := range val {
+			out[i] = redact(v)
+		}
+		return out
+	default:
+		return value
+	}
+}
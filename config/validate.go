@@ -0,0 +1,316 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"kenmec/jimmy/charge_core/tool"
+	"kenmec/jimmy/charge_core/transport"
+)
+
+// Validate 檢查設定內容, 回傳的錯誤會列出所有問題與其路徑, 例如 stations[1].id
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if len(c.Stations) == 0 {
+		add("stations", "at least one station is required")
+	}
+
+	seen := make(map[string]int)
+	units := make(map[byte]int)
+	listens := make(map[string]int)
+	for i, st := range c.Stations {
+		path := fmt.Sprintf("stations[%d]", i)
+
+		if b, err := hex.DecodeString(st.ID); err != nil || len(b) != 1 {
+			add(path+".id", "%q must be two hex characters, e.g. \"01\"", st.ID)
+		} else if j, ok := seen[strings.ToLower(st.ID)]; ok {
+			add(path+".id", "%q duplicates stations[%d].id", st.ID, j)
+		} else {
+			seen[strings.ToLower(st.ID)] = i
+		}
+
+		switch st.Transport {
+		case "", transport.KindTCP, transport.KindUDP:
+			if st.IP == "" {
+				add(path+".ip", "required")
+			} else if net.ParseIP(st.IP) == nil && !validHostname(st.IP) {
+				add(path+".ip", "%q is not an IP address or host name", st.IP)
+			}
+			if err := validPort(st.Port); err != nil {
+				add(path+".port", "%v", err)
+			}
+			if st.Transport == transport.KindUDP && st.Listen != "" {
+				if err := validListen(st.Listen); err != nil {
+					add(path+".listen", "%v", err)
+				}
+			}
+		case transport.KindTCPServer:
+			// ip 選填, 有設定時只接受這個位址連進來
+			if st.IP != "" && net.ParseIP(st.IP) == nil && !validHostname(st.IP) {
+				add(path+".ip", "%q is not an IP address or host name", st.IP)
+			}
+			if err := validListen(st.Listen); err != nil {
+				add(path+".listen", "%v", err)
+			} else if j, ok := listens[st.Listen]; ok && protocolName(c.Stations[j].Protocol) != protocolName(st.Protocol) {
+				// 同一個 listen 的閘道器連線由所有站點共用, 要用同樣的格式切 frame
+				add(path+".protocol", "%q differs from stations[%d] sharing listen %q", st.Protocol, j, st.Listen)
+			} else if !ok {
+				listens[st.Listen] = i
+			}
+			switch st.Identify {
+			case "", transport.IdentifyStation:
+			case transport.IdentifyIP:
+				if st.IP == "" {
+					add(path+".ip", "required when identify is ip")
+				}
+			case transport.IdentifyRegister:
+				if st.RegisterID == "" {
+					add(path+".register_id", "required when identify is register")
+				}
+			default:
+				add(path+".identify", "%q must be one of %s", st.Identify, strings.Join(transport.Identifies, ", "))
+			}
+		case transport.KindSocketCAN:
+			if st.Interface == "" {
+				add(path+".interface", "required, e.g. \"can0\"")
+			}
+			// 預設的 0xf00 不是合法的標準幀 ID, 直接上 CAN bus 時要明確設定
+			if st.CAN == nil {
+				add(path+".can", "required for socketcan")
+			}
+		default:
+			add(path+".transport", "%q must be one of %s", st.Transport, strings.Join(transport.Kinds, ", "))
+		}
+		if st.AliveTimeout < 0 {
+			add(path+".alive_timeout", "must not be negative")
+		}
+
+		if _, err := tool.Driver(st.Protocol); err != nil {
+			add(path+".protocol", "%v", err)
+		}
+		if st.CAN != nil {
+			if err := st.CANAddress().Validate(); err != nil {
+				add(path+".can", "%v", err)
+			}
+		}
+
+		if st.PollInterval < 0 {
+			add(path+".poll_interval", "must not be negative (0 disables polling)")
+		} else if st.PollInterval == 0 && (st.Transport == transport.KindUDP || st.Transport == transport.KindSocketCAN) {
+			// 沒有連線狀態, 收不到回覆就永遠不會算連上, 指令也不會送出
+			add(path+".poll_interval", "required for %s, the station only counts as connected after it replies", st.Transport)
+		}
+
+		if c.Modbus.Enabled {
+			if unit, err := st.ModbusUnitId(); err != nil {
+				add(path+".modbus_unit", "%v", err)
+			} else if j, ok := units[unit]; ok {
+				add(path+".modbus_unit", "unit id %d already used by stations[%d]", unit, j)
+			} else {
+				units[unit] = i
+			}
+		}
+
+		if st.OCPP != nil {
+			errs = append(errs, st.OCPP.validate(path+".ocpp")...)
+		}
+	}
+
+	if !oneOf(c.Log.Level, "", "debug", "info", "warn", "error") {
+		add("log.level", "%q must be \"debug\", \"info\", \"warn\" or \"error\"", c.Log.Level)
+	}
+	if c.Log.MaxSizeMB < 0 {
+		add("log.max_size_mb", "must not be negative")
+	}
+	if c.Log.MaxAgeDays < 0 {
+		add("log.max_age_days", "must not be negative")
+	}
+	if c.Log.MaxTotalMB < 0 {
+		add("log.max_total_mb", "must not be negative")
+	}
+
+	errs = append(errs, c.MQTT.validate("mqtt")...)
+
+	if c.Modbus.Enabled {
+		if err := validListen(c.Modbus.Listen); err != nil {
+			add("modbus.listen", "%v", err)
+		}
+	}
+	for i, ip := range c.Modbus.WriteAllow {
+		if net.ParseIP(ip) == nil {
+			add(fmt.Sprintf("modbus.write_allow[%d]", i), "%q is not an IP address", ip)
+		}
+	}
+
+	if c.Capture.MaxSizeMB < 0 {
+		add("capture.max_size_mb", "must not be negative")
+	}
+	if c.Capture.MaxFiles < 0 {
+		add("capture.max_files", "must not be negative")
+	}
+
+	if c.Alarm.HistorySize < 0 {
+		add("alarm.history_size", "must not be negative")
+	}
+	if c.HTTP.Enabled {
+		if err := validListen(c.HTTP.Listen); err != nil {
+			add("http.listen", "%v", err)
+		}
+	}
+
+	if !oneOf(c.Startup.ReadyPolicy, "", "all", "any", "none") {
+		add("startup.ready_policy", "%q must be \"all\", \"any\" or \"none\"", c.Startup.ReadyPolicy)
+	}
+	if c.Startup.ReadyTimeout < 0 {
+		add("startup.ready_timeout", "must not be negative")
+	}
+	if !oneOf(c.Shutdown.StopPolicy, "", "leave", "stop") {
+		add("shutdown.stop_policy", "%q must be \"leave\" or \"stop\"", c.Shutdown.StopPolicy)
+	}
+	if c.Shutdown.Timeout < 0 {
+		add("shutdown.timeout", "must not be negative")
+	}
+	if c.EventBus.PanicLimit < 0 {
+		add("event_bus.panic_limit", "must not be negative")
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m MQTT) validate(path string) []error {
+	var errs []error
+
+	if m.Broker != "" {
+		u, err := url.Parse(m.Broker)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s.broker: %v", path, err))
+		case !oneOf(u.Scheme, "tcp", "ssl", "tls", "mqtt", "mqtts", "ws", "wss"):
+			errs = append(errs, fmt.Errorf("%s.broker: %q needs a scheme such as tcp://", path, m.Broker))
+		case u.Hostname() == "":
+			errs = append(errs, fmt.Errorf("%s.broker: %q has no host", path, m.Broker))
+		case u.Port() != "":
+			if err := validPort(u.Port()); err != nil {
+				errs = append(errs, fmt.Errorf("%s.broker: %v", path, err))
+			}
+		}
+	}
+
+	if m.HeartbeatInterval < 0 {
+		errs = append(errs, fmt.Errorf("%s.heartbeat_interval: must not be negative", path))
+	}
+	return errs
+}
+
+func (o OCPP) validate(path string) []error {
+	var errs []error
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", path, field, fmt.Sprintf(format, args...)))
+	}
+
+	if !oneOf(o.Version, "", "1.6", "2.0.1") {
+		add("version", "%q must be \"1.6\" or \"2.0.1\"", o.Version)
+	}
+
+	if o.URL == "" {
+		add("url", "required")
+	} else if u, err := url.Parse(o.URL); err != nil {
+		add("url", "%v", err)
+	} else if !oneOf(u.Scheme, "ws", "wss") || u.Host == "" {
+		add("url", "%q must be a ws:// or wss:// URL", o.URL)
+	} else if o.SecurityProfile >= 2 && u.Scheme != "wss" {
+		add("url", "security_profile %d needs a wss:// URL", o.SecurityProfile)
+	}
+
+	if o.MeterInterval < 0 {
+		add("meter_interval", "must not be negative")
+	}
+	if o.OfflineQueueSize < 0 {
+		add("offline_queue_size", "must not be negative")
+	}
+
+	switch o.SecurityProfile {
+	case 0:
+	case 1, 2:
+		if o.Password == "" {
+			add("password", "required for security_profile %d", o.SecurityProfile)
+		}
+	case 3:
+		if o.CertFile == "" {
+			add("cert_file", "required for security_profile 3")
+		}
+		if o.KeyFile == "" {
+			add("key_file", "required for security_profile 3")
+		}
+	default:
+		add("security_profile", "%d must be 0-3", o.SecurityProfile)
+	}
+	return errs
+}
+
+func validPort(port string) error {
+	if port == "" {
+		return errors.New("required")
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q must be a number 1-65535", port)
+	}
+	return nil
+}
+
+// protocolName 把空白的 protocol 視為 default
+func protocolName(p string) string {
+	if p == "" {
+		return "default"
+	}
+	return p
+}
+
+// validListen 檢查 listen 位址, host 可以空白 (例如 ":502")
+func validListen(addr string) error {
+	if addr == "" {
+		return errors.New("required, e.g. \"0.0.0.0:502\"")
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q must be host:port", addr)
+	}
+	return validPort(port)
+}
+
+// validHostname 允許 DNS 名稱, 例如 gateway-01.local; 全是數字的視為寫錯的 IP
+func validHostname(host string) bool {
+	if len(host) > 253 || strings.Trim(host, "0123456789.") == "" {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func oneOf(s string, options ...string) bool {
+	for _, o := range options {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validConfig 是一份可以通過檢查的最小設定
+func validConfig() *Config {
+	return &Config{
+		Stations: []Station{
+			{ID: "01", IP: "127.0.0.1", Port: "8000"},
+			{ID: "02", IP: "127.0.0.1", Port: "8001"},
+		},
+	}
+}
+
+func TestValidateOK(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string // 錯誤訊息要包含的片段, 通常是路徑
+	}{
+		{"no stations", func(c *Config) { c.Stations = nil }, []string{"stations: at least one station is required"}},
+		{"bad id", func(c *Config) { c.Stations[1].ID = "1" }, []string{`stations[1].id: "1" must be two hex characters`}},
+		{"duplicate id", func(c *Config) { c.Stations[1].ID = "01" }, []string{`stations[1].id: "01" duplicates stations[0].id`}},
+		{"duplicate id in another case", func(c *Config) {
+			c.Stations[0].ID, c.Stations[1].ID = "0a", "0A"
+		}, []string{`stations[1].id: "0A" duplicates stations[0].id`}},
+		{"shared listen with another protocol", func(c *Config) {
+			c.Stations[0] = Station{ID: "01", Transport: "tcp_server", Listen: "0.0.0.0:9000"}
+			c.Stations[1] = Station{ID: "02", Transport: "tcp_server", Listen: "0.0.0.0:9000", Protocol: "crc16"}
+		}, []string{`stations[1].protocol: "crc16" differs from stations[0] sharing listen "0.0.0.0:9000"`}},
+		{"shared listen with default protocol spelled out", func(c *Config) {
+			c.Stations[0] = Station{ID: "01", Transport: "tcp_server", Listen: "0.0.0.0:9000"}
+			c.Stations[1] = Station{ID: "02", Transport: "tcp_server", Listen: "0.0.0.0:9000", Protocol: "default"}
+		}, nil},
+		{"tcp_server without listen", func(c *Config) {
+			c.Stations[0] = Station{ID: "01", Transport: "tcp_server"}
+		}, []string{"stations[0].listen: required"}},
+		{"duplicate modbus unit", func(c *Config) {
+			c.Modbus = Modbus{Enabled: true, Listen: "0.0.0.0:502"}
+			c.Stations[1].ModbusUnit = 1
+		}, []string{"stations[1].modbus_unit: unit id 1 already used by stations[0]"}},
+		{"ocpp", func(c *Config) {
+			c.Stations[0].OCPP = &OCPP{URL: "http://csms", SecurityProfile: 1}
+		}, []string{"stations[0].ocpp.url", "stations[0].ocpp.password"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no error, want %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// errors.Join 的結果要列出每一個問題, 一行一個
+func TestValidateReportsEveryPath(t *testing.T) {
+	c := validConfig()
+	c.Stations[0].Port = "0"
+	c.Stations[1].ID = "zz"
+	c.MQTT.Broker = "localhost:1883"
+	c.Log.Level = "trace"
+	c.Shutdown.StopPolicy = "kill"
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("no error")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	want := []string{"stations[0].port:", "stations[1].id:", "log.level:", "mqtt.broker:", "shutdown.stop_policy:"}
+	if len(lines) != len(want) {
+		t.Fatalf("%d errors, want %d:\n%v", len(lines), len(want), err)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("error %d = %q, want prefix %q", i, lines[i], prefix)
+		}
+	}
+}
+
+func TestLoadNormalizesStationIds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+stations:
+  - {id: "0A", ip: "127.0.0.1", port: "8000"}
+  - {id: "1f", ip: "127.0.0.1", port: "8001"}
+`
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Stations[0].ID != "0a" || c.Stations[1].ID != "1f" {
+		t.Fatalf("station ids = %q, %q, want lower case", c.Stations[0].ID, c.Stations[1].ID)
+	}
+}
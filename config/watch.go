@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// watchDebounce 編輯器存檔常連續觸發好幾次寫入, 等檔案穩定後再讀
+const watchDebounce = 500 * time.Millisecond
+
+// Watch 監看 LoadConfig 讀到的設定檔, 變動後重新讀取並檢查:
+// 通過時呼叫 onChange, 讀取或檢查失敗時呼叫 onError, 呼叫端應保留上一份設定。
+// 兩個 callback 不會同時執行
+func Watch(onChange func(*Config), onError func(error)) {
+	var (
+		mu    sync.Mutex
+		apply sync.Mutex
+		timer *time.Timer
+	)
+
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(watchDebounce, func() {
+			apply.Lock()
+			defer apply.Unlock()
+
+			cfg, err := readConfig(viper.ConfigFileUsed())
+			if err != nil {
+				onError(err)
+				return
+			}
+			onChange(cfg)
+		})
+	})
+	viper.WatchConfig()
+}
+
+// readConfig 用獨立的 viper 讀檔, YAML 壞掉時 viper 會保留舊內容, 這裡才看得到錯誤
+func readConfig(path string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := prepare(v); err != nil {
+		return nil, err
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, fmt.Errorf("unable to decode into struct: %w", err)
+	}
+	config.normalize()
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
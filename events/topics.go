@@ -0,0 +1,38 @@
+// Package events 集中宣告 event bus 上的 topic 與 payload 型別,
+// 發佈與訂閱都透過這裡的 Topic, 型別不符在編譯時就會發現
+package events
+
+import (
+	"kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/types"
+)
+
+// 站點的事件都在 station.<id> 底下, 宣告時站號是 "+",
+// 發佈時用 With(stationId) 指定站點; 訂閱時直接用宣告的 topic 收全部站點,
+// 用 With(stationId) 只收一個站點, 例如 CANClient 只收自己的指令
+var (
+	// ConnectionTCP 是站點連線狀態變化
+	ConnectionTCP = infra.NewTopic[types.ConnectionTcp]("station.+.connection")
+	// QamsCommand 是要送給充電機的指令 (MQTT / Modbus)
+	QamsCommand = infra.NewTopic[types.QamsCommand]("station.+.command")
+	// ChargerTelemetry 是充電機回覆的遙測
+	ChargerTelemetry = infra.NewTopic[types.ChargerTelemetry]("station.+.telemetry")
+	// CommandResult 是指令是否已排入站點的寫入佇列, 不代表充電機已經執行
+	CommandResult = infra.NewTopic[types.CommandResult]("station.+.command.result")
+	// AlarmList 是站點目前的告警清單
+	AlarmList = infra.NewTopic[types.AlarmList]("station.+.alarms")
+	// AlarmAck 是維護人員確認告警
+	AlarmAck = infra.NewTopic[types.AlarmAck]("station.+.alarms.ack")
+)
+
+var (
+	// SystemWatchdog 是 systemd watchdog 檢查 event bus 是否還在派送的 ping
+	SystemWatchdog = infra.NewTopic[struct{}]("system.watchdog")
+	// HandlerPanic 是 event bus handler panic 後的 dead-letter 事件
+	HandlerPanic = infra.NewTopic[infra.HandlerPanic](infra.PanicEvent)
+)
+
+// TCPStatus 是 request bus 上查詢站點連線狀態的 topic (types.ReqTCPStatus -> types.ResTCPStatus)
+func TCPStatus(stationId string) string {
+	return "tcp." + stationId + ".status"
+}
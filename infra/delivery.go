@@ -0,0 +1,128 @@
+package infra
+
+import "sync"
+
+// Delivery selects how published events reach one subscription
+type Delivery int
+
+const (
+	// DeliverAsync runs the handler in a new goroutine per event (default).
+	// PublishSync still runs it in the publisher's goroutine
+	DeliverAsync Delivery = iota
+	// DeliverSync always runs the handler in the publisher's goroutine
+	DeliverSync
+	// DeliverOrdered queues events for one dedicated goroutine, so the
+	// handler sees them one at a time in publish order
+	DeliverOrdered
+	// DeliverPool queues events for a fixed number of worker goroutines,
+	// bounding concurrency without keeping order
+	DeliverPool
+)
+
+// Overflow is what a full queue does with a new event
+type Overflow int
+
+const (
+	// Block makes the publisher wait for room (default)
+	Block Overflow = iota
+	// DropOldest discards the oldest queued event to make room
+	DropOldest
+	// DropNewest discards the new event
+	DropNewest
+)
+
+// DefaultBuffer is the queue size of ordered and pool subscriptions
+const DefaultBuffer = 64
+
+// SubscribeOptions configures the delivery of one subscription.
+// The zero value is the original goroutine per event behaviour
+type SubscribeOptions struct {
+	Delivery Delivery
+	Buffer   int      // queue size for DeliverOrdered / DeliverPool, 0 means DefaultBuffer
+	Overflow Overflow // what to do when the queue is full
+	Workers  int      // goroutines for DeliverPool, 0 means 1
+}
+
+// envelope is a queued event with the topic it was published on
+type envelope struct {
+	event string
+	data  interface{}
+}
+
+// queue is the bounded FIFO in front of an ordered or pool subscription
+type queue struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []envelope
+	size     int
+	overflow Overflow
+	closed   bool
+}
+
+func newQueue(size int, overflow Overflow) *queue {
+	if size <= 0 {
+		size = DefaultBuffer
+	}
+	q := &queue{items: make([]envelope, 0, size), size: size, overflow: overflow}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds e and returns the event dropped to do so, nil if none: e itself
+// with DropNewest, the evicted head with DropOldest.
+// Events pushed after close are discarded without counting as drops
+func (q *queue) push(e envelope) (dropped *envelope) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) >= q.size && !q.closed {
+		switch q.overflow {
+		case DropNewest:
+			return &e
+		case DropOldest:
+			head := q.items[0]
+			q.items = append(q.items[:0], q.items[1:]...)
+			dropped = &head
+		default:
+			q.notFull.Wait()
+		}
+	}
+	if q.closed {
+		return nil
+	}
+
+	q.items = append(q.items, e)
+	q.notEmpty.Signal()
+	return dropped
+}
+
+// pop waits for the next event; ok is false once the queue is closed
+func (q *queue) pop() (e envelope, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if q.closed {
+		return envelope{}, false
+	}
+
+	e = q.items[0]
+	q.items = append(q.items[:0], q.items[1:]...)
+	q.notFull.Signal()
+	return e, true
+}
+
+// close discards queued events and releases blocked publishers and workers
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.items = nil
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
@@ -0,0 +1,211 @@
+package infra
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receive waits for n values from ch
+func receive[T any](t *testing.T, ch <-chan T, n int) []T {
+	t.Helper()
+
+	var got []T
+	for len(got) < n {
+		select {
+		case v := <-ch:
+			got = append(got, v)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d of %d events", len(got), n)
+		}
+	}
+	return got
+}
+
+func TestDeliverSync(t *testing.T) {
+	eb := New()
+	var got []int
+	eb.SubscribeWith("t", func(data interface{}) { got = append(got, data.(int)) }, SubscribeOptions{Delivery: DeliverSync})
+
+	// Publish only returns after the handler ran, no synchronisation needed
+	for i := 0; i < 3; i++ {
+		eb.Publish("t", i)
+	}
+	if !slices.Equal(got, []int{0, 1, 2}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestDeliverOrdered(t *testing.T) {
+	eb := New()
+	ch := make(chan int, 100)
+	eb.SubscribeWith("t", func(data interface{}) { ch <- data.(int) }, SubscribeOptions{Delivery: DeliverOrdered})
+
+	want := make([]int, 100)
+	for i := range want {
+		want[i] = i
+		eb.Publish("t", i)
+	}
+	if got := receive(t, ch, len(want)); !slices.Equal(got, want) {
+		t.Fatalf("got %v, want publish order", got)
+	}
+}
+
+func TestDeliverPool(t *testing.T) {
+	eb := New()
+	const workers = 3
+
+	var running, peak atomic.Int32
+	gate := make(chan struct{})
+	done := make(chan struct{}, 10)
+	eb.SubscribeWith("t", func(interface{}) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-gate
+		running.Add(-1)
+		done <- struct{}{}
+	}, SubscribeOptions{Delivery: DeliverPool, Workers: workers})
+
+	for i := 0; i < 10; i++ {
+		eb.Publish("t", i)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for running.Load() < workers && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// give an extra worker the chance to show up before releasing
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+
+	receive(t, done, 10)
+	if p := peak.Load(); p != workers {
+		t.Fatalf("peak concurrency %d, want %d", p, workers)
+	}
+}
+
+// blockedQueue subscribes to "t.+" with a queue of 2 and a handler that holds
+// the first event until release is closed, so later events pile up in the queue
+func blockedQueue(t *testing.T, overflow Overflow) (eb *EventBus, got <-chan string, release chan struct{}) {
+	t.Helper()
+
+	eb = New()
+	ch := make(chan string, 10)
+	release = make(chan struct{})
+	started := make(chan struct{})
+	var once sync.Once
+	eb.SubscribeTopic("t.+", func(topic string, _ interface{}) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		ch <- topic
+	}, SubscribeOptions{Delivery: DeliverOrdered, Buffer: 2, Overflow: overflow})
+
+	eb.Publish("t.1", nil)
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler never started")
+	}
+	return eb, ch, release
+}
+
+func TestOverflowDrop(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow Overflow
+		want     []string // topics delivered
+		dropped  []string // topics counted as dropped
+	}{
+		{"drop oldest", DropOldest, []string{"t.1", "t.4", "t.5"}, []string{"t.2", "t.3"}},
+		{"drop newest", DropNewest, []string{"t.1", "t.2", "t.3"}, []string{"t.4", "t.5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eb, got, release := blockedQueue(t, tt.overflow)
+
+			// t.1 is in the handler, t.2 and t.3 fill the queue
+			for i := 2; i <= 5; i++ {
+				eb.Publish(fmt.Sprintf("t.%d", i), nil)
+			}
+			close(release)
+
+			if topics := receive(t, got, len(tt.want)); !slices.Equal(topics, tt.want) {
+				t.Fatalf("delivered %v, want %v", topics, tt.want)
+			}
+
+			stats := eb.Stats()
+			if stats.Dropped != uint64(len(tt.dropped)) {
+				t.Fatalf("Dropped = %d, want %d", stats.Dropped, len(tt.dropped))
+			}
+			for _, topic := range tt.dropped {
+				if stats.DroppedByTopic[topic] != 1 {
+					t.Fatalf("DroppedByTopic = %v, want one drop for each of %v", stats.DroppedByTopic, tt.dropped)
+				}
+			}
+		})
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	eb, got, release := blockedQueue(t, Block)
+
+	eb.Publish("t.2", nil)
+	eb.Publish("t.3", nil)
+
+	published := make(chan struct{})
+	go func() {
+		eb.Publish("t.4", nil)
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish did not wait for room in a full queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish still blocked after the handler caught up")
+	}
+
+	want := []string{"t.1", "t.2", "t.3", "t.4"}
+	if topics := receive(t, got, len(want)); !slices.Equal(topics, want) {
+		t.Fatalf("delivered %v, want %v", topics, want)
+	}
+	if stats := eb.Stats(); stats.Dropped != 0 {
+		t.Fatalf("Dropped = %d with Block", stats.Dropped)
+	}
+}
+
+func TestUnsubscribeReleasesBlockedPublisher(t *testing.T) {
+	eb, _, release := blockedQueue(t, Block)
+	defer close(release)
+
+	eb.Publish("t.2", nil)
+	eb.Publish("t.3", nil)
+
+	published := make(chan struct{})
+	go func() {
+		eb.Publish("t.4", nil)
+		close(published)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	eb.ClearAll()
+	select {
+	case <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish still blocked after the subscription was removed")
+	}
+}
@@ -2,106 +2,219 @@ package infra
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // EventHandler is a function type that handles events
 type EventHandler func(data interface{})
 
-// EventBus manages event subscriptions and publishing
+// TopicHandler also receives the concrete topic an event was published on,
+// useful for wildcard subscriptions
+type TopicHandler func(topic string, data interface{})
+
+// subscription pairs a handler with its subscription ID
+type subscription struct {
+	id      int
+	handler TopicHandler
+	guard   *guard
+	mode    Delivery
+	queue   *queue // nil unless DeliverOrdered / DeliverPool
+}
+
+// EventBus manages event subscriptions and publishing.
+// Subscriptions are kept in a topic trie so wildcard patterns are matched
+// level by level instead of against every subscription
 type EventBus struct {
-	mu         sync.RWMutex
-	handlers   map[string][]EventHandler
-	handlerIDs map[string]map[int]EventHandler
-	nextID     int
+	mu     sync.RWMutex
+	root   *node
+	nextID int
+	logger Logger
+
+	panicLimit atomic.Int32
+	counters   counters
 }
 
 // New creates a new EventBus instance
 func New() *EventBus {
 	return &EventBus{
-		handlers:   make(map[string][]EventHandler),
-		handlerIDs: make(map[string]map[int]EventHandler),
-		nextID:     0,
+		root:   &node{},
+		nextID: 0,
+		logger: &DefaultLogger{},
 	}
 }
 
-// Subscribe registers a handler for a specific event
+// SetLogger replaces the logger used to report delivery problems
+func (eb *EventBus) SetLogger(logger Logger) {
+	eb.logger = logger
+}
+
+// SetPanicLimit disables a handler after limit consecutive panics, 0 never disables.
+// A disabled handler stays subscribed but no longer receives events
+func (eb *EventBus) SetPanicLimit(limit int) {
+	eb.panicLimit.Store(int32(limit))
+}
+
+// Stats returns the panic and drop counters of all handlers
+func (eb *EventBus) Stats() Stats {
+	return eb.counters.snapshot()
+}
+
+// Subscribe registers a handler for a specific event or wildcard pattern
 // Returns a subscription ID that can be used to unsubscribe
 func (eb *EventBus) Subscribe(event string, handler EventHandler) int {
+	return eb.SubscribeWith(event, handler, SubscribeOptions{})
+}
+
+// SubscribeWith registers a handler with its own delivery mode, see SubscribeOptions
+func (eb *EventBus) SubscribeWith(event string, handler EventHandler, opts SubscribeOptions) int {
+	return eb.SubscribeTopic(event, func(_ string, data interface{}) { handler(data) }, opts)
+}
+
+// SubscribeTopic registers a handler for a pattern such as "station.+.telemetry"
+// or "station.01.#"; the handler receives the concrete topic of each event
+func (eb *EventBus) SubscribeTopic(pattern string, handler TopicHandler, opts SubscribeOptions) int {
 	eb.mu.Lock()
 	defer eb.mu.Unlock()
 
-	eb.handlers[event] = append(eb.handlers[event], handler)
-
-	if eb.handlerIDs[event] == nil {
-		eb.handlerIDs[event] = make(map[int]EventHandler)
+	id := eb.nextID
+	sub := subscription{id: id, handler: handler, guard: &guard{}, mode: opts.Delivery}
+
+	switch opts.Delivery {
+	case DeliverOrdered:
+		sub.queue = newQueue(opts.Buffer, opts.Overflow)
+		go eb.work(sub)
+	case DeliverPool:
+		sub.queue = newQueue(opts.Buffer, opts.Overflow)
+		workers := opts.Workers
+		if workers <= 0 {
+			workers = 1
+		}
+		for i := 0; i < workers; i++ {
+			go eb.work(sub)
+		}
 	}
 
-	id := eb.nextID
-	eb.handlerIDs[event][id] = handler
+	eb.root.add(pattern, sub)
 	eb.nextID++
 
 	return id
 }
 
-// Unsubscribe removes a handler using its subscription ID
+// Unsubscribe removes a handler using the event or pattern it subscribed to and its ID
 func (eb *EventBus) Unsubscribe(event string, id int) error {
 	eb.mu.Lock()
 	defer eb.mu.Unlock()
 
-	eventHandlers, exists := eb.handlerIDs[event]
-	if !exists {
+	if eb.root.find(event) == nil {
 		return fmt.Errorf("event '%s' not found", event)
 	}
 
-	handler, exists := eventHandlers[id]
-	if !exists {
+	sub, ok := eb.root.remove(strings.Split(event, topicSep), id)
+	if !ok {
 		return fmt.Errorf("subscription ID %d not found for event '%s'", id, event)
 	}
+	closeQueue(sub)
+	return nil
+}
 
-	delete(eventHandlers, id)
-
-	// Remove from handlers slice
-	handlers := eb.handlers[event]
-	for i, h := range handlers {
-		if &h == &handler {
-			eb.handlers[event] = append(handlers[:i], handlers[i+1:]...)
-			break
-		}
+// match returns the subscriptions for a concrete topic. Publishing to a
+// wildcard pattern is a programming error and is dropped
+func (eb *EventBus) match(event string) []subscription {
+	if IsPattern(event) {
+		eb.logger.Error("event '%s': cannot publish to a wildcard pattern", event)
+		return nil
 	}
 
-	return nil
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	return eb.root.match(strings.Split(event, topicSep))
 }
 
 // Publish sends an event to all subscribed handlers
 func (eb *EventBus) Publish(event string, data interface{}) {
-	eb.mu.RLock()
-	handlers := eb.handlers[event]
-	eb.mu.RUnlock()
-
-	for _, handler := range handlers {
-		go handler(data)
+	for _, sub := range eb.match(event) {
+		switch {
+		case sub.queue != nil:
+			eb.enqueue(event, sub, data)
+		case sub.mode == DeliverSync:
+			eb.deliver(event, sub, data)
+		default:
+			go eb.deliver(event, sub, data)
+		}
 	}
 }
 
-// PublishSync sends an event to all subscribed handlers synchronously
+// PublishSync sends an event to all subscribed handlers synchronously.
+// Ordered and pool subscriptions still receive it through their queue
 func (eb *EventBus) PublishSync(event string, data interface{}) {
-	eb.mu.RLock()
-	handlers := eb.handlers[event]
-	eb.mu.RUnlock()
+	for _, sub := range eb.match(event) {
+		if sub.queue != nil {
+			eb.enqueue(event, sub, data)
+			continue
+		}
+		eb.deliver(event, sub, data)
+	}
+}
+
+// enqueue hands an event to a queued subscription, counting overflow drops
+// under the topic of the event that was actually discarded
+func (eb *EventBus) enqueue(event string, sub subscription, data interface{}) {
+	if dropped := sub.queue.push(envelope{event: event, data: data}); dropped != nil {
+		eb.counters.dropped(dropped.event)
+	}
+}
+
+// work is one worker goroutine of a queued subscription, it exits on Unsubscribe
+func (eb *EventBus) work(sub subscription) {
+	for {
+		e, ok := sub.queue.pop()
+		if !ok {
+			return
+		}
+		eb.deliver(e.event, sub, e.data)
+	}
+}
+
+// deliver runs one handler. A panic is logged, counted and published as a
+// HandlerPanic on PanicEvent instead of crashing the process
+func (eb *EventBus) deliver(event string, sub subscription, data interface{}) {
+	if sub.guard.disabled.Load() {
+		return
+	}
+
+	p := sub.guard.call(event, sub.id, int(eb.panicLimit.Load()), func() { sub.handler(event, data) })
+	if p == nil {
+		return
+	}
+
+	eb.counters.panicked(p)
+	eb.logger.Error("event '%s': handler %d panicked (%d in a row): %v\n%s", event, sub.id, p.Count, p.Value, p.Stack)
+	if p.Disabled {
+		eb.logger.Error("event '%s': handler %d disabled after %d panics in a row", event, sub.id, p.Count)
+	}
 
-	for _, handler := range handlers {
-		handler(data)
+	// A panicking dead-letter handler is only logged, otherwise it would loop
+	if event != PanicEvent {
+		eb.Publish(PanicEvent, *p)
 	}
 }
 
-// Clear removes all handlers for a specific event
+// Clear removes all handlers subscribed to exactly this event or pattern
 func (eb *EventBus) Clear(event string) {
 	eb.mu.Lock()
 	defer eb.mu.Unlock()
 
-	delete(eb.handlers, event)
-	delete(eb.handlerIDs, event)
+	n := eb.root.find(event)
+	if n == nil {
+		return
+	}
+	levels := strings.Split(event, topicSep)
+	for _, sub := range n.subs {
+		eb.root.remove(levels, sub.id)
+		closeQueue(sub)
+	}
 }
 
 // ClearAll removes all handlers for all events
@@ -109,6 +222,13 @@ func (eb *EventBus) ClearAll() {
 	eb.mu.Lock()
 	defer eb.mu.Unlock()
 
-	eb.handlers = make(map[string][]EventHandler)
-	eb.handlerIDs = make(map[string]map[int]EventHandler)
+	eb.root.all(closeQueue)
+	eb.root = &node{}
+}
+
+// closeQueue stops the workers of a removed queued subscription
+func closeQueue(sub subscription) {
+	if sub.queue != nil {
+		sub.queue.close()
+	}
 }
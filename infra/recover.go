@@ -0,0 +1,56 @@
+package infra
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicEvent is the dead-letter event published on the EventBus when a
+// handler panics. Its payload is a HandlerPanic
+const PanicEvent = "eventbus.panic"
+
+// HandlerPanic describes a recovered handler panic. Request handlers return
+// it as the response error, event handlers publish it on PanicEvent
+type HandlerPanic struct {
+	Topic    string      // event or request topic
+	ID       int         // subscription ID of the handler
+	Value    interface{} // value passed to panic
+	Stack    string      // stack of the panicking goroutine
+	Count    int         // consecutive panics of this handler
+	Disabled bool        // the handler was disabled by this panic
+}
+
+func (p HandlerPanic) Error() string {
+	return fmt.Sprintf("handler %d for '%s' panicked: %v", p.ID, p.Topic, p.Value)
+}
+
+// guard isolates one handler: it recovers panics and remembers how many
+// happened in a row so a handler that keeps panicking can be disabled
+type guard struct {
+	panics   atomic.Int32
+	disabled atomic.Bool
+}
+
+// call runs fn and converts a panic into a HandlerPanic.
+// With limit > 0 the handler is disabled after limit consecutive panics
+func (g *guard) call(topic string, id int, limit int, fn func()) (p *HandlerPanic) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			if g.panics.Load() != 0 {
+				g.panics.Store(0)
+			}
+			return
+		}
+
+		n := int(g.panics.Add(1))
+		p = &HandlerPanic{Topic: topic, ID: id, Value: v, Stack: string(debug.Stack()), Count: n}
+		if limit > 0 && n >= limit {
+			p.Disabled = g.disabled.CompareAndSwap(false, true)
+		}
+	}()
+
+	fn()
+	return nil
+}
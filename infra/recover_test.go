@@ -0,0 +1,113 @@
+package infra
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// nopLogger keeps the expected panic stacks out of the test output
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+func TestHandlerPanicLimit(t *testing.T) {
+	const limit = 3
+
+	eb := New()
+	eb.SetLogger(nopLogger{})
+	eb.SetPanicLimit(limit)
+	opts := SubscribeOptions{Delivery: DeliverSync}
+
+	var mu sync.Mutex
+	var dead []HandlerPanic
+	eb.SubscribeWith(PanicEvent, func(data interface{}) {
+		mu.Lock()
+		dead = append(dead, data.(HandlerPanic))
+		mu.Unlock()
+	}, opts)
+
+	var calls, received atomic.Int32
+	bad := eb.SubscribeWith("station.01.telemetry", func(interface{}) {
+		calls.Add(1)
+		panic("boom")
+	}, opts)
+	eb.SubscribeWith("station.01.telemetry", func(interface{}) { received.Add(1) }, opts)
+
+	const events = limit + 2
+	for i := 0; i < events; i++ {
+		eb.Publish("station.01.telemetry", i)
+	}
+
+	if n := calls.Load(); n != limit {
+		t.Fatalf("panicking handler called %d times, want %d before it is disabled", n, limit)
+	}
+	if n := received.Load(); n != events {
+		t.Fatalf("other subscriber received %d events, want %d", n, events)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dead) != limit {
+		t.Fatalf("%d dead-letter events, want %d", len(dead), limit)
+	}
+	for i, p := range dead {
+		if p.Topic != "station.01.telemetry" || p.ID != bad || p.Value != "boom" || p.Count != i+1 || p.Stack == "" {
+			t.Fatalf("dead-letter event %d = %+v", i, p)
+		}
+		if want := i+1 == limit; p.Disabled != want {
+			t.Fatalf("dead-letter event %d Disabled = %v, want %v", i, p.Disabled, want)
+		}
+	}
+
+	stats := eb.Stats()
+	if stats.Panics != limit || stats.Disabled != 1 || stats.PanicsByTopic["station.01.telemetry"] != limit {
+		t.Fatalf("Stats = %+v", stats)
+	}
+}
+
+func TestHandlerPanicCountResets(t *testing.T) {
+	eb := New()
+	eb.SetLogger(nopLogger{})
+	eb.SetPanicLimit(2)
+
+	// panics on odd events only, never twice in a row
+	var calls atomic.Int32
+	eb.SubscribeWith("t", func(data interface{}) {
+		calls.Add(1)
+		if data.(int)%2 == 1 {
+			panic("odd")
+		}
+	}, SubscribeOptions{Delivery: DeliverSync})
+
+	for i := 0; i < 6; i++ {
+		eb.Publish("t", i)
+	}
+	if n := calls.Load(); n != 6 {
+		t.Fatalf("handler called %d times, want 6: disabled without %d panics in a row", n, 2)
+	}
+	if stats := eb.Stats(); stats.Panics != 3 || stats.Disabled != 0 {
+		t.Fatalf("Stats = %+v", stats)
+	}
+}
+
+func TestPanickingDeadLetterHandler(t *testing.T) {
+	eb := New()
+	eb.SetLogger(nopLogger{})
+	opts := SubscribeOptions{Delivery: DeliverSync}
+
+	var deadCalls atomic.Int32
+	eb.SubscribeWith(PanicEvent, func(interface{}) {
+		deadCalls.Add(1)
+		panic("dead letter")
+	}, opts)
+	eb.SubscribeWith("t", func(interface{}) { panic("boom") }, opts)
+
+	// must not loop publishing panics of the dead-letter handler
+	eb.Publish("t", nil)
+	if n := deadCalls.Load(); n != 1 {
+		t.Fatalf("dead-letter handler called %d times, want 1", n)
+	}
+}
@@ -35,6 +35,8 @@ type RequestSubscription struct {
 	ID      int
 	Topic   string
 	Handler RequestHandler
+
+	guard *guard
 }
 
 // RequestResponseBus handles request-response communication patterns
@@ -46,6 +48,8 @@ type RequestResponseBus struct {
 	nextID           int
 	timeout          time.Duration
 	logger           Logger
+	panicLimit       int
+	counters         counters
 }
 
 // Logger interface for logging
@@ -74,6 +78,7 @@ func (l *DefaultLogger) Error(msg string, args ...interface{}) {
 type Config struct {
 	DefaultTimeout time.Duration
 	Logger         Logger
+	PanicLimit     int // unregister a handler after this many consecutive panics, 0 never does
 }
 
 // New creates a new RequestResponseBus with default configuration
@@ -93,6 +98,7 @@ func NewWithConfig(config Config) *RequestResponseBus {
 		nextID:           0,
 		timeout:          config.DefaultTimeout,
 		logger:           config.Logger,
+		panicLimit:       config.PanicLimit,
 	}
 }
 
@@ -105,6 +111,7 @@ func (rb *RequestResponseBus) RegisterHandler(topic string, handler RequestHandl
 		ID:      rb.nextID,
 		Topic:   topic,
 		Handler: handler,
+		guard:   &guard{},
 	}
 
 	rb.handlers[topic] = sub
@@ -185,7 +192,7 @@ func (rb *RequestResponseBus) RequestWithTimeout(ctx context.Context, topic stri
 			rb.logger.Debug("Processing request: %s (ID: %s)", req.Topic, req.ID)
 		}
 
-		data, err := handler.Handler(ctx, req)
+		data, err := rb.invoke(ctx, handler, req)
 
 		response := Response{
 			RequestID: req.ID,
@@ -258,7 +265,7 @@ func (rb *RequestResponseBus) Broadcast(topic string, data interface{}) ([]*Resp
 	}
 
 	ctx := context.Background()
-	responseData, err := handler.Handler(ctx, req)
+	responseData, err := rb.invoke(ctx, handler, req)
 
 	response := &Response{
 		RequestID: req.ID,
@@ -270,6 +277,41 @@ func (rb *RequestResponseBus) Broadcast(topic string, data interface{}) ([]*Resp
 	return []*Response{response}, nil
 }
 
+// invoke runs a request handler and turns a panic into a HandlerPanic error.
+// A handler that reaches the panic limit is unregistered
+func (rb *RequestResponseBus) invoke(ctx context.Context, sub *RequestSubscription, req Request) (data interface{}, err error) {
+	p := sub.guard.call(req.Topic, sub.ID, rb.panicLimit, func() {
+		data, err = sub.Handler(ctx, req)
+	})
+	if p == nil {
+		return data, err
+	}
+
+	rb.counters.panicked(p)
+	if rb.logger != nil {
+		rb.logger.Error("request '%s' (ID: %s): handler panicked (%d in a row): %v\n%s", req.Topic, req.ID, p.Count, p.Value, p.Stack)
+	}
+
+	if p.Disabled {
+		rb.mu.Lock()
+		if rb.handlers[sub.Topic] == sub {
+			delete(rb.handlers, sub.Topic)
+		}
+		rb.mu.Unlock()
+
+		if rb.logger != nil {
+			rb.logger.Error("Unregistered handler for topic %s after %d panics in a row", sub.Topic, p.Count)
+		}
+	}
+
+	return nil, *p
+}
+
+// Stats returns the panic counters of all request handlers
+func (rb *RequestResponseBus) Stats() Stats {
+	return rb.counters.snapshot()
+}
+
 // HasHandler checks if a handler is registered for a topic
 func (rb *RequestResponseBus) HasHandler(topic string) bool {
 	rb.mu.RLock()
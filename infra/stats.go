@@ -0,0 +1,61 @@
+package infra
+
+import "sync"
+
+// Stats are the bus counters exposed for monitoring
+type Stats struct {
+	Panics         uint64            `json:"panics"`
+	Disabled       uint64            `json:"disabled"`
+	PanicsByTopic  map[string]uint64 `json:"panicsByTopic,omitempty"`
+	Dropped        uint64            `json:"dropped"` // events discarded by full subscription queues
+	DroppedByTopic map[string]uint64 `json:"droppedByTopic,omitempty"`
+}
+
+// counters collects Stats for one bus
+type counters struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+func (c *counters) panicked(p *HandlerPanic) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Panics++
+	if p.Disabled {
+		c.stats.Disabled++
+	}
+	if c.stats.PanicsByTopic == nil {
+		c.stats.PanicsByTopic = make(map[string]uint64)
+	}
+	c.stats.PanicsByTopic[p.Topic]++
+}
+
+func (c *counters) dropped(topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Dropped++
+	if c.stats.DroppedByTopic == nil {
+		c.stats.DroppedByTopic = make(map[string]uint64)
+	}
+	c.stats.DroppedByTopic[topic]++
+}
+
+func (c *counters) snapshot() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.PanicsByTopic = copyCounts(c.stats.PanicsByTopic)
+	s.DroppedByTopic = copyCounts(c.stats.DroppedByTopic)
+	return s
+}
+
+func copyCounts(m map[string]uint64) map[string]uint64 {
+	out := make(map[string]uint64, len(m))
+	for topic, n := range m {
+		out[topic] = n
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package infra
+
+import "strings"
+
+// Topic is an event name bound to its payload type, so a publisher and a
+// subscriber that disagree on the payload fail to compile instead of
+// panicking on a type assertion at runtime.
+// The name may be a wildcard pattern declaring a family of topics, for
+// example "station.+.telemetry"; use With to get one member of the family
+type Topic[T any] struct {
+	name string
+}
+
+// NewTopic declares a typed topic. Declare each topic once and share the value
+func NewTopic[T any](name string) Topic[T] {
+	return Topic[T]{name: name}
+}
+
+// Name returns the underlying event name or pattern
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// With fills the "+" levels of the name in order, for example
+// NewTopic[T]("station.+.telemetry").With("01") is "station.01.telemetry".
+// Levels left unfilled stay wildcards
+func (t Topic[T]) With(levels ...string) Topic[T] {
+	parts := strings.Split(t.name, topicSep)
+	for i := range parts {
+		if len(levels) == 0 {
+			break
+		}
+		if parts[i] == wildcardOne {
+			parts[i], levels = levels[0], levels[1:]
+		}
+	}
+	return Topic[T]{name: strings.Join(parts, topicSep)}
+}
+
+// Publish sends data to all subscribers asynchronously, see EventBus.Publish
+func (t Topic[T]) Publish(eb *EventBus, data T) {
+	eb.Publish(t.name, data)
+}
+
+// PublishSync sends data to all subscribers synchronously, see EventBus.PublishSync
+func (t Topic[T]) PublishSync(eb *EventBus, data T) {
+	eb.PublishSync(t.name, data)
+}
+
+// Subscribe registers a typed handler and returns its subscription ID.
+// A payload of another type published through the untyped EventBus API is
+// reported to the bus logger and dropped
+func (t Topic[T]) Subscribe(eb *EventBus, handler func(T)) int {
+	return t.SubscribeWith(eb, handler, SubscribeOptions{})
+}
+
+// SubscribeWith registers a typed handler with its own delivery mode, see SubscribeOptions
+func (t Topic[T]) SubscribeWith(eb *EventBus, handler func(T), opts SubscribeOptions) int {
+	return t.SubscribeTopic(eb, func(_ string, v T) { handler(v) }, opts)
+}
+
+// SubscribeTopic registers a typed handler that also receives the concrete topic
+func (t Topic[T]) SubscribeTopic(eb *EventBus, handler func(topic string, v T), opts SubscribeOptions) int {
+	return eb.SubscribeTopic(t.name, func(topic string, data interface{}) {
+		v, ok := data.(T)
+		if !ok {
+			var want T
+			eb.logger.Error("event '%s': dropped payload of type %T, want %T", topic, data, want)
+			return
+		}
+		handler(topic, v)
+	}, opts)
+}
+
+// Unsubscribe removes a handler registered with Subscribe
+func (t Topic[T]) Unsubscribe(eb *EventBus, id int) error {
+	return eb.Unsubscribe(t.name, id)
+}
@@ -0,0 +1,137 @@
+package infra
+
+import (
+	"slices"
+	"strings"
+)
+
+// Topics are dot separated levels, for example "station.01.telemetry".
+// In a subscription pattern a level that is exactly "+" matches any one
+// level and a final "#" matches the remaining levels, including none
+const (
+	topicSep     = "."
+	wildcardOne  = "+"
+	wildcardRest = "#"
+)
+
+// IsPattern reports whether topic contains a wildcard level
+func IsPattern(topic string) bool {
+	levels := strings.Split(topic, topicSep)
+	for i, l := range levels {
+		if l == wildcardOne || (l == wildcardRest && i == len(levels)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// node is one level of the subscription trie. Children are keyed by the
+// literal level, "+" or "#"
+type node struct {
+	children map[string]*node
+	subs     []subscription
+}
+
+func (n *node) child(level string) *node {
+	if n.children == nil {
+		n.children = make(map[string]*node)
+	}
+	c, ok := n.children[level]
+	if !ok {
+		c = &node{}
+		n.children[level] = c
+	}
+	return c
+}
+
+// add stores sub under pattern. subs is copied so a concurrent match keeps
+// its own snapshot
+func (n *node) add(pattern string, sub subscription) {
+	for _, level := range strings.Split(pattern, topicSep) {
+		n = n.child(level)
+	}
+	subs := make([]subscription, 0, len(n.subs)+1)
+	n.subs = append(append(subs, n.subs...), sub)
+}
+
+// find returns the node of pattern, nil if nothing was ever subscribed to it
+func (n *node) find(pattern string) *node {
+	for _, level := range strings.Split(pattern, topicSep) {
+		n = n.children[level]
+		if n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// remove deletes the subscription id under pattern and prunes empty nodes
+func (n *node) remove(levels []string, id int) (subscription, bool) {
+	if len(levels) == 0 {
+		for i, sub := range n.subs {
+			if sub.id == id {
+				rest := make([]subscription, 0, len(n.subs)-1)
+				n.subs = append(append(rest, n.subs[:i]...), n.subs[i+1:]...)
+				return sub, true
+			}
+		}
+		return subscription{}, false
+	}
+
+	c := n.children[levels[0]]
+	if c == nil {
+		return subscription{}, false
+	}
+	sub, ok := c.remove(levels[1:], id)
+	if ok && c.empty() {
+		delete(n.children, levels[0])
+	}
+	return sub, ok
+}
+
+func (n *node) empty() bool {
+	return len(n.subs) == 0 && len(n.children) == 0
+}
+
+// match collects the subscriptions whose pattern matches the topic levels,
+// in subscription order
+func (n *node) match(levels []string) []subscription {
+	var out []subscription
+	var nodes int
+	var walk func(n *node, levels []string)
+	walk = func(n *node, levels []string) {
+		if c := n.children[wildcardRest]; c != nil && len(c.subs) > 0 {
+			out = append(out, c.subs...)
+			nodes++
+		}
+		if len(levels) == 0 {
+			if len(n.subs) > 0 {
+				out = append(out, n.subs...)
+				nodes++
+			}
+			return
+		}
+		if c := n.children[levels[0]]; c != nil {
+			walk(c, levels[1:])
+		}
+		if c := n.children[wildcardOne]; c != nil {
+			walk(c, levels[1:])
+		}
+	}
+	walk(n, levels)
+
+	if nodes > 1 {
+		slices.SortFunc(out, func(a, b subscription) int { return a.id - b.id })
+	}
+	return out
+}
+
+// all calls fn for every subscription in the trie
+func (n *node) all(fn func(subscription)) {
+	for _, sub := range n.subs {
+		fn(sub)
+	}
+	for _, c := range n.children {
+		c.all(fn)
+	}
+}
@@ -0,0 +1,129 @@
+package infra
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIsPattern(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  bool
+	}{
+		{"station.01.telemetry", false},
+		{"station.+.telemetry", true},
+		{"station.01.#", true},
+		{"#", true},
+		// "#" only counts as the last level, and only a whole level is a wildcard
+		{"station.#.telemetry", false},
+		{"station.a+b.telemetry", false},
+	}
+	for _, tt := range tests {
+		if got := IsPattern(tt.topic); got != tt.want {
+			t.Errorf("IsPattern(%q) = %v, want %v", tt.topic, got, tt.want)
+		}
+	}
+}
+
+// newTrie subscribes patterns in order, the subscription ID is the index
+func newTrie(patterns ...string) *node {
+	root := &node{}
+	for id, p := range patterns {
+		root.add(p, subscription{id: id})
+	}
+	return root
+}
+
+func matchIDs(root *node, topic string) []int {
+	ids := []int{}
+	for _, sub := range root.match(strings.Split(topic, topicSep)) {
+		ids = append(ids, sub.id)
+	}
+	return ids
+}
+
+func TestTrieMatch(t *testing.T) {
+	root := newTrie(
+		"station.01.telemetry", // 0
+		"station.+.telemetry",  // 1
+		"station.01.#",         // 2
+		"station.#",            // 3
+		"#",                    // 4
+		"station.+.+",          // 5
+		"+.01",                 // 6
+		"station.01.telemetry", // 7, a second handler on the same topic
+	)
+
+	tests := []struct {
+		topic string
+		want  []int
+	}{
+		{"station.01.telemetry", []int{0, 1, 2, 3, 4, 5, 7}},
+		{"station.02.telemetry", []int{1, 3, 4, 5}},
+		{"station.01.alarm.list", []int{2, 3, 4}},
+		// "#" also matches zero remaining levels
+		{"station.01", []int{2, 3, 4, 6}},
+		{"station", []int{3, 4}},
+		{"system.watchdog", []int{4}},
+		{"gateway.01", []int{4, 6}},
+	}
+	for _, tt := range tests {
+		if got := matchIDs(root, tt.topic); !slices.Equal(got, tt.want) {
+			t.Errorf("match(%q) = %v, want %v", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestTrieRemovePrunes(t *testing.T) {
+	patterns := []string{"station.01.telemetry", "station.+.telemetry", "station.01.#", "station.01.telemetry"}
+
+	tests := []struct {
+		name   string
+		remove []int
+		left   []string // patterns that must still have a node
+		gone   []string // patterns whose nodes must be pruned
+	}{
+		{"one of two handlers", []int{0}, []string{"station.01.telemetry"}, nil},
+		{"shared prefix kept", []int{1}, []string{"station.01.telemetry", "station.01.#"}, []string{"station.+.telemetry", "station.+"}},
+		{"leaf with sibling", []int{2}, []string{"station.01.telemetry"}, []string{"station.01.#"}},
+		{"everything", []int{0, 1, 2, 3}, nil, []string{"station"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := newTrie(patterns...)
+			for _, id := range tt.remove {
+				if _, ok := root.remove(strings.Split(patterns[id], topicSep), id); !ok {
+					t.Fatalf("remove(%q, %d) not found", patterns[id], id)
+				}
+			}
+			for _, p := range tt.left {
+				if root.find(p) == nil {
+					t.Errorf("%q pruned", p)
+				}
+			}
+			for _, p := range tt.gone {
+				if root.find(p) != nil {
+					t.Errorf("%q not pruned", p)
+				}
+			}
+			if len(tt.left) == 0 && !root.empty() {
+				t.Errorf("root not empty after removing every subscription")
+			}
+		})
+	}
+}
+
+func TestTrieRemoveUnknown(t *testing.T) {
+	root := newTrie("station.01.telemetry")
+
+	if _, ok := root.remove(strings.Split("station.01.telemetry", topicSep), 5); ok {
+		t.Error("removed an unknown ID")
+	}
+	if _, ok := root.remove(strings.Split("station.02.telemetry", topicSep), 0); ok {
+		t.Error("removed from an unknown pattern")
+	}
+	if got := matchIDs(root, "station.01.telemetry"); !slices.Equal(got, []int{0}) {
+		t.Errorf("match after failed removes = %v", got)
+	}
+}
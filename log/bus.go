@@ -0,0 +1,18 @@
+package log
+
+import "fmt"
+
+// BusLogger 讓 infra 的 event bus / request bus 把訊息 (例如 handler panic) 寫進 Logger
+type BusLogger struct{}
+
+func (BusLogger) Debug(msg string, args ...interface{}) {
+	Logger.Debug(fmt.Sprintf(msg, args...))
+}
+
+func (BusLogger) Info(msg string, args ...interface{}) {
+	Logger.Info(fmt.Sprintf(msg, args...))
+}
+
+func (BusLogger) Error(msg string, args ...interface{}) {
+	Logger.Error(fmt.Sprintf("💥 "+msg, args...))
+}
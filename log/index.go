@@ -5,7 +5,6 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
-	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -13,55 +12,80 @@ import (
 
 var Logger *zap.Logger
 
-// InitLog 初始化 zap logger
-// logFilePath: 日誌檔案路徑，例如 "app.log"
+// Level 是 terminal 與檔案共用的輸出等級, 執行中可用 SetLevel 調整
+var Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
 
-func InitLog() {
-	kenmecPath, err := GetKenmecFilePath()
-	if err != nil {
-		panic(err)
-	}
+// Options 是 InitLog 的設定
+type Options struct {
+	Dir          string        // 日誌目錄, 空白時使用 ~/kenmec/_logs/charge_station
+	Level        zapcore.Level // 最低輸出等級
+	Rotate       RotateOptions // 檔案輪替與保留
+	StationFiles bool          // 每個站點另外寫一份 <dir>/stations/station-<id>-YYYY-MM-DD.log
+}
 
-	// 日誌目錄
-	logDir := filepath.Join(kenmecPath, "_logs/charge_station")
-	err = os.MkdirAll(logDir, os.ModePerm)
-	if err != nil {
-		panic("無法建立 log 目錄: " + err.Error())
-	}
+// opts 是 InitLog 實際使用的設定, StationLogger 開站點檔案時沿用
+var opts Options
 
-	// 產生每日 log 檔案名稱
-	today := time.Now().Format("2006-01-02") // YYYY-MM-DD
-	logFilePath := filepath.Join(logDir, fmt.Sprintf("charge_station-%s.log", today))
+// InitLog 初始化 zap logger, 同時輸出到 terminal 與每日輪替的 json 檔
+func InitLog(o Options) {
+	logDir := o.Dir
+	if logDir == "" {
+		kenmecPath, err := GetKenmecFilePath()
+		if err != nil {
+			panic(err)
+		}
+		logDir = filepath.Join(kenmecPath, "_logs/charge_station")
+	}
 
-	// 開啟或建立 log 檔案
-	file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	// 每日 log 檔 charge_station-YYYY-MM-DD.log, 跨日或超過大小時換檔
+	file, err := NewRotatingFile(logDir, "charge_station", o.Rotate)
 	if err != nil {
 		panic("無法開啟 log 檔案: " + err.Error())
 	}
 
-	// Encoder 設定
-	encoderCfg := zap.NewProductionEncoderConfig()
-	encoderCfg.TimeKey = "T"
-	encoderCfg.LevelKey = "L"
-	encoderCfg.CallerKey = "C"
-	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
-	encoderCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	encoderCfg.EncodeCaller = zapcore.ShortCallerEncoder
+	o.Dir = logDir
+	opts = o
+	Level.SetLevel(o.Level)
 
 	// Terminal encoder
-	consoleEncoder := zapcore.NewConsoleEncoder(encoderCfg)
+	consoleEncoder := zapcore.NewConsoleEncoder(encoderConfig())
 	// File encoder (json)
-	fileEncoder := zapcore.NewJSONEncoder(encoderCfg)
+	fileEncoder := zapcore.NewJSONEncoder(encoderConfig())
 
 	// 同時輸出到 terminal 與檔案
 	core := zapcore.NewTee(
-		zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), zap.DebugLevel),
-		zapcore.NewCore(fileEncoder, zapcore.AddSync(file), zap.DebugLevel),
+		zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), Level),
+		zapcore.NewCore(fileEncoder, file, Level),
 	)
 
 	Logger = zap.New(core, zap.AddStacktrace(zapcore.ErrorLevel))
 }
 
+// encoderConfig 是 terminal 與檔案共用的欄位名稱與格式
+func encoderConfig() zapcore.EncoderConfig {
+	encoderCfg := zap.NewProductionEncoderConfig()
+	encoderCfg.TimeKey = "T"
+	encoderCfg.LevelKey = "L"
+	encoderCfg.CallerKey = "C"
+	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoderCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	encoderCfg.EncodeCaller = zapcore.ShortCallerEncoder
+	return encoderCfg
+}
+
+// SetLevel 在執行中調整輸出等級, 例如 "info"
+func SetLevel(level string) error {
+	l, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if l != Level.Level() {
+		Level.SetLevel(l)
+		Logger.Info(fmt.Sprintf("🔧 log 等級改為 %s", l))
+	}
+	return nil
+}
+
 func GetKenmecFilePath() (string, error) {
 	currentUser, err := user.Current()
 	if err != nil {
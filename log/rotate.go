@@ -0,0 +1,285 @@
+package log
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RotateOptions 是 log 檔的輪替與保留設定
+type RotateOptions struct {
+	MaxSizeMB  int  // 單檔上限, 超過時切出 <prefix>-YYYY-MM-DD.N.log; 0 表示只在午夜輪替
+	MaxAgeDays int  // 舊檔保留天數, 0 表示不依天數刪除
+	MaxTotalMB int  // 所有舊檔合計上限, 超過時從最舊的開始刪; 0 表示不限
+	Compress   bool // 輪替出去的檔案以 gzip 壓縮
+}
+
+// RotatingFile 寫入 <dir>/<prefix>-YYYY-MM-DD.log, 跨日或超過大小時換檔,
+// 換下來的檔案在背景壓縮並依保留設定清理
+type RotatingFile struct {
+	mu      sync.Mutex
+	dir     string
+	prefix  string
+	opts    RotateOptions
+	file    *os.File
+	day     string
+	size    int64
+	pattern *regexp.Regexp
+	now     func() time.Time // 測試時替換
+
+	bgMu sync.Mutex // 壓縮與清理一次只跑一個
+}
+
+func NewRotatingFile(dir, prefix string, opts RotateOptions) (*RotatingFile, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	r := &RotatingFile{
+		dir:     dir,
+		prefix:  prefix,
+		opts:    opts,
+		pattern: regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `-\d{4}-\d{2}-\d{2}(\.\d+)?\.log(\.gz)?$`),
+		now:     time.Now,
+	}
+
+	if err := r.open(r.now().Format("2006-01-02")); err != nil {
+		return nil, err
+	}
+
+	// 處理上次執行留下、還沒壓縮或超過保留期限的檔案
+	go r.housekeep()
+	return r, nil
+}
+
+// Write 寫入目前的檔案; 輪替失敗時照樣寫進原本的檔案並回傳錯誤, 下次寫入時再試,
+// 不會因為一次改名或開檔失敗就再也寫不進 log
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	day := r.now().Format("2006-01-02")
+	var rotateErr error
+	switch {
+	case r.file == nil:
+		// 上次輪替後連原本的檔案都開不了
+		rotateErr = r.open(day)
+	case day != r.day:
+		rotateErr = r.rotateDay(day)
+	case r.opts.MaxSizeMB > 0 && r.size > 0 && r.size+int64(len(p)) > int64(r.opts.MaxSizeMB)<<20:
+		rotateErr = r.rotateSize()
+	}
+	if r.file == nil {
+		return 0, rotateErr
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) path(day string) string {
+	return filepath.Join(r.dir, fmt.Sprintf("%s-%s.log", r.prefix, day))
+}
+
+func (r *RotatingFile) open(day string) error {
+	f, err := os.OpenFile(r.path(day), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.day = day
+	r.size = info.Size()
+	return nil
+}
+
+// rotateDay 午夜換到新的一天, 前一天的檔案交給背景壓縮; 新檔開不了時繼續用原本的檔案
+func (r *RotatingFile) rotateDay(day string) error {
+	old := r.file
+	if err := r.open(day); err != nil {
+		return fmt.Errorf("rotate to %s: %w", r.path(day), err)
+	}
+	old.Close()
+	go r.housekeep()
+	return nil
+}
+
+// rotateSize 把目前的檔案改名為 <prefix>-YYYY-MM-DD.N.log 後重新開一個;
+// 改名失敗時重新開啟原本的檔案, r.file 只有在連原檔都開不了時才是 nil
+func (r *RotatingFile) rotateSize() error {
+	r.file.Close()
+	r.file = nil
+
+	current := r.path(r.day)
+	var name string
+	for i := 1; ; i++ {
+		name = filepath.Join(r.dir, fmt.Sprintf("%s-%s.%d.log", r.prefix, r.day, i))
+		if !exists(name) && !exists(name+".gz") {
+			break
+		}
+	}
+	if err := os.Rename(current, name); err != nil {
+		err = fmt.Errorf("rotate %s: %w", current, err)
+		if oerr := r.open(r.day); oerr != nil {
+			return errors.Join(err, oerr)
+		}
+		return err
+	}
+
+	if err := r.open(r.day); err != nil {
+		return err
+	}
+	go r.housekeep()
+	return nil
+}
+
+// housekeep 壓縮換下來的檔案, 再依天數與總大小刪除舊檔
+func (r *RotatingFile) housekeep() {
+	r.bgMu.Lock()
+	defer r.bgMu.Unlock()
+
+	r.mu.Lock()
+	current := r.path(r.day)
+	r.mu.Unlock()
+
+	files, err := r.rotated(current)
+	if err != nil {
+		return
+	}
+
+	if r.opts.Compress {
+		for i, f := range files {
+			if filepath.Ext(f.path) != ".log" {
+				continue
+			}
+			if err := compress(f.path); err != nil {
+				fmt.Fprintf(os.Stderr, "log 壓縮失敗 %s: %v\n", f.path, err)
+				continue
+			}
+			info, err := os.Stat(f.path + ".gz")
+			if err == nil {
+				files[i] = rotatedFile{path: f.path + ".gz", modTime: f.modTime, size: info.Size()}
+			}
+		}
+	}
+
+	// 新的在前
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+		expired := r.opts.MaxAgeDays > 0 && time.Since(f.modTime) > time.Duration(r.opts.MaxAgeDays)*24*time.Hour
+		tooBig := r.opts.MaxTotalMB > 0 && total > int64(r.opts.MaxTotalMB)<<20
+		if expired || tooBig {
+			os.Remove(f.path)
+		}
+	}
+}
+
+type rotatedFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// rotated 列出這個 prefix 換下來的檔案 (不含正在寫的)
+func (r *RotatingFile) rotated(current string) ([]rotatedFile, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []rotatedFile
+	for _, e := range entries {
+		path := filepath.Join(r.dir, e.Name())
+		if e.IsDir() || path == current || !r.pattern.MatchString(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{path: path, modTime: info.ModTime(), size: info.Size()})
+	}
+	return files, nil
+}
+
+// compress 把 path 壓成 path.gz 並刪除原檔, 保留原本的修改時間供保留期限判斷
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	os.Chtimes(path+".gz", info.ModTime(), info.ModTime())
+	return os.Remove(path)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
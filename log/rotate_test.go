@@ -0,0 +1,252 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestFile 開一個時間由 *now 決定的 RotatingFile
+func newTestFile(t *testing.T, opts RotateOptions, now *time.Time) (*RotatingFile, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	r, err := NewRotatingFile(dir, "test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	r.mu.Lock()
+	r.now = func() time.Time { return *now }
+	r.mu.Unlock()
+	return r, dir
+}
+
+// today 回傳今天 h:m 的時間, NewRotatingFile 一開始就以今天開檔, 測試不會多出空檔
+func today(h, m int) time.Time {
+	y, mo, d := time.Now().Date()
+	return time.Date(y, mo, d, h, m, 0, 0, time.Local)
+}
+
+func day(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func write(t *testing.T, r *RotatingFile, p []byte) {
+	t.Helper()
+	if _, err := r.Write(p); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+// logFiles 列出 dir 下的檔名, 已排序
+func logFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotateDay(t *testing.T) {
+	now := today(23, 59)
+	first := day(now)
+	r, dir := newTestFile(t, RotateOptions{}, &now)
+
+	write(t, r, []byte("day one\n"))
+	now = now.Add(2 * time.Minute)
+	write(t, r, []byte("day two\n"))
+
+	if got := readFile(t, filepath.Join(dir, "test-"+first+".log")); got != "day one\n" {
+		t.Fatalf("first day = %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "test-"+day(now)+".log")); got != "day two\n" {
+		t.Fatalf("second day = %q", got)
+	}
+}
+
+func TestRotateSize(t *testing.T) {
+	now := today(12, 0)
+	r, dir := newTestFile(t, RotateOptions{MaxSizeMB: 1}, &now)
+
+	chunk := bytes.Repeat([]byte("x"), 600<<10)
+	for i := 0; i < 3; i++ {
+		write(t, r, chunk)
+	}
+	r.housekeep()
+
+	prefix := "test-" + day(now)
+	want := []string{prefix + ".1.log", prefix + ".2.log", prefix + ".log"}
+	got := logFiles(t, dir)
+	if len(got) != len(want) {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("files = %v, want %v", got, want)
+		}
+		if info, _ := os.Stat(filepath.Join(dir, got[i])); info.Size() != int64(len(chunk)) {
+			t.Fatalf("%s is %d bytes, want one chunk", got[i], info.Size())
+		}
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	now := today(12, 0)
+	r, dir := newTestFile(t, RotateOptions{MaxSizeMB: 1, Compress: true}, &now)
+
+	first := bytes.Repeat([]byte("a"), 600<<10)
+	write(t, r, first)
+	write(t, r, bytes.Repeat([]byte("b"), 600<<10))
+	r.housekeep()
+
+	path := filepath.Join(dir, "test-"+day(now)+".1.log")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("%s still exists after compression", path)
+	}
+	f, err := os.Open(path + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, first) {
+		t.Fatalf("decompressed %d bytes, want the first %d bytes written", len(got), len(first))
+	}
+}
+
+// oldFile 建立一個修改時間為 age 之前的輪替檔
+func oldFile(t *testing.T, dir, name string, size int, age time.Duration) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, make([]byte, size), 0666); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRotateMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	expired := oldFile(t, dir, "test-2026-01-01.log", 10, 10*24*time.Hour)
+	recent := oldFile(t, dir, "test-2026-01-09.log.gz", 10, 24*time.Hour)
+	other := oldFile(t, dir, "other-2026-01-01.log", 10, 10*24*time.Hour)
+
+	r, err := NewRotatingFile(dir, "test", RotateOptions{MaxAgeDays: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.housekeep()
+
+	if exists(expired) {
+		t.Fatal("file older than max_age_days kept")
+	}
+	if !exists(recent) || !exists(other) {
+		t.Fatal("recent file or another prefix removed")
+	}
+}
+
+func TestRotateMaxTotal(t *testing.T) {
+	dir := t.TempDir()
+	oldest := oldFile(t, dir, "test-2026-01-01.log", 600<<10, 3*time.Hour)
+	older := oldFile(t, dir, "test-2026-01-01.1.log", 600<<10, 2*time.Hour)
+	newest := oldFile(t, dir, "test-2026-01-01.2.log", 600<<10, time.Hour)
+
+	r, err := NewRotatingFile(dir, "test", RotateOptions{MaxTotalMB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.housekeep()
+
+	if !exists(newest) {
+		t.Fatal("newest rotated file removed")
+	}
+	if exists(older) || exists(oldest) {
+		t.Fatal("rotated files over max_total_mb kept")
+	}
+}
+
+func TestRotateSizeRenameFails(t *testing.T) {
+	now := today(12, 0)
+	r, dir := newTestFile(t, RotateOptions{MaxSizeMB: 1}, &now)
+	path := filepath.Join(dir, "test-"+day(now)+".log")
+
+	write(t, r, []byte("first\n"))
+	// 檔案被移走後改名會失敗, 要回到原本的路徑繼續寫
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	r.mu.Lock()
+	r.size = 1 << 20
+	r.mu.Unlock()
+	if _, err := r.Write([]byte("second\n")); err == nil {
+		t.Fatal("rotate error not reported")
+	}
+	write(t, r, []byte("third\n"))
+
+	if got := readFile(t, path); got != "second\nthird\n" {
+		t.Fatalf("log after failed rotate = %q", got)
+	}
+}
+
+func TestRotateDayOpenFails(t *testing.T) {
+	now := today(23, 59)
+	first := day(now)
+	r, dir := newTestFile(t, RotateOptions{}, &now)
+	write(t, r, []byte("day one\n"))
+
+	// 新一天的檔名被目錄佔住, 開檔失敗時繼續寫前一天的檔案
+	next := filepath.Join(dir, "test-"+day(now.Add(2*time.Minute))+".log")
+	if err := os.Mkdir(next, 0777); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := r.Write([]byte("late\n")); err == nil {
+		t.Fatal("rotate error not reported")
+	}
+	if got := readFile(t, filepath.Join(dir, "test-"+first+".log")); got != "day one\nlate\n" {
+		t.Fatalf("previous day = %q", got)
+	}
+
+	// 問題排除後下一次寫入就換到新的一天
+	os.Remove(next)
+	write(t, r, []byte("day two\n"))
+	if got := readFile(t, next); got != "day two\n" {
+		t.Fatalf("second day = %q", got)
+	}
+}
@@ -0,0 +1,51 @@
+package log
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	stationMu    sync.Mutex
+	stationFiles = make(map[string]*RotatingFile)
+)
+
+// StationLogger 回傳帶 station_id 與 addr 欄位的子 logger;
+// 開啟 StationFiles 時同一行也會寫進該站自己的檔案
+func StationLogger(stationId, addr string) *zap.Logger {
+	logger := Logger
+	if opts.StationFiles {
+		if file, err := stationFile(stationId); err != nil {
+			Logger.Error(fmt.Sprintf("station %s 無法開啟站點 log 檔: %v", stationId, err))
+		} else {
+			stationCore := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig()), file, Level)
+			logger = Logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+				return zapcore.NewTee(core, stationCore)
+			}))
+		}
+	}
+
+	return logger.With(zap.String("station_id", stationId), zap.String("addr", addr))
+}
+
+// stationFile 每個站號只開一次, 站點重新啟動或重連時沿用,
+// 避免舊連線的 goroutine 還在寫時檔案被關掉
+func stationFile(stationId string) (*RotatingFile, error) {
+	stationMu.Lock()
+	defer stationMu.Unlock()
+
+	if f, ok := stationFiles[stationId]; ok {
+		return f, nil
+	}
+
+	f, err := NewRotatingFile(filepath.Join(opts.Dir, "stations"), "station-"+stationId, opts.Rotate)
+	if err != nil {
+		return nil, err
+	}
+	stationFiles[stationId] = f
+	return f, nil
+}
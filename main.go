@@ -1,32 +1,222 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"kenmec/jimmy/charge_core/alarm"
 	"kenmec/jimmy/charge_core/api"
 	"kenmec/jimmy/charge_core/config"
 	eventbus "kenmec/jimmy/charge_core/infra"
 	"kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/systemd"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap/zapcore"
 )
 
 func main() {
-	log.InitLog()
-	cfg, err := config.LoadConfig()
+	configPath := pflag.StringP("config", "c", "", "設定檔路徑, 預設為目前目錄的 config.yaml")
+	pflag.String("log-dir", "", "覆寫 log.dir, 預設 ~/kenmec/_logs/charge_station")
+	pflag.String("log-level", "", "覆寫 log.level: debug / info / warn / error")
+	pflag.String("mqtt-broker", "", "覆寫 mqtt.broker, 例如 tcp://10.0.0.5:1883")
+	checkConfig := pflag.Bool("check-config", false, "只檢查設定檔, 列出所有問題後結束")
+	pflag.Usage = usage
+	pflag.Parse()
+
+	command := pflag.Arg(0)
+	switch command {
+	case "version":
+		printVersion()
+		return
+	case "", "print-config":
+	default:
+		usage()
+		os.Exit(2)
+	}
 
+	cfg, err := config.LoadConfig(*configPath, pflag.CommandLine)
 	if err != nil {
-		panic("Could not load config")
+		fmt.Fprintf(os.Stderr, "設定檔有誤:\n%v\n", err)
+		os.Exit(1)
+	}
+	if *checkConfig {
+		fmt.Printf("config OK (%d stations)\n", len(cfg.Stations))
+		return
+	}
+	if command == "print-config" {
+		if err := config.PrintConfig(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
+	level, _ := zapcore.ParseLevel(cfg.Log.Level) // Validate 已檢查過
+	log.InitLog(log.Options{
+		Dir:          cfg.Log.Dir,
+		Level:        level,
+		StationFiles: cfg.Log.StationFiles,
+		Rotate: log.RotateOptions{
+			MaxSizeMB:  cfg.Log.MaxSizeMB,
+			MaxAgeDays: cfg.Log.MaxAgeDays,
+			MaxTotalMB: cfg.Log.MaxTotalMB,
+			Compress:   cfg.Log.Compress,
+		},
+	})
+
+	// ⭐ handler panic 會被攔下寫進 log, 不會讓整個服務掛掉
 	eb := eventbus.New()
-	reqbus := eventbus.NewReqBus()
+	eb.SetLogger(log.BusLogger{})
+	eb.SetPanicLimit(cfg.EventBus.PanicLimit)
+	reqbus := eventbus.NewWithConfig(eventbus.Config{
+		DefaultTimeout: 30 * time.Second,
+		Logger:         log.BusLogger{},
+		PanicLimit:     cfg.EventBus.PanicLimit,
+	})
+
+	// ⭐ SIGTERM (systemd stop) / Ctrl-C, 啟動等待中 (包括等 MQTT broker) 也能中斷
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 
-	api.NewMQTTClient(eb, reqbus, cfg)
+	mqttClient := api.NewMQTTClient(ctx, eb, reqbus, cfg)
+
+	// ⭐ 告警要在站點啟動前訂閱, 才不會漏掉第一次連線失敗
+	alarms := alarm.NewManager(cfg.Alarm, cfg.Stations, eb, reqbus)
 
 	// ⭐ 建立 CANManager
-	canManager := api.NewCANManager()
+	canManager := api.NewCANManager(cfg.Capture)
 
 	// ⭐ 設定多個站
-	for _, v := range cfg.Stations {
-		canManager.Add(v.ID, v.IP, v.Port, eb, reqbus)
+	runner := api.NewStationRunner(canManager, eb, reqbus)
+	runner.Start(cfg.Stations)
+
+	var modbus *api.ModbusServer
+	if cfg.Modbus.Enabled {
+		if modbus, err = api.NewModbusServer(cfg.Modbus, cfg.Stations, canManager, eb); err != nil {
+			log.Logger.Error(fmt.Sprintf("Modbus server 啟動失敗: %v", err))
+		}
+	}
+
+	var httpServer *api.HTTPServer
+	if cfg.HTTP.Enabled {
+		if httpServer, err = api.NewHTTPServer(cfg.HTTP, alarms, eb, reqbus); err != nil {
+			log.Logger.Error(fmt.Sprintf("HTTP server 啟動失敗: %v", err))
+		}
+	}
+
+	// ⭐ config.yaml 熱更新, 只有 stations 與 log.level 即時生效
+	current := cfg
+	config.Watch(func(next *config.Config) {
+		if modbus != nil {
+			if err := modbus.SetStations(next.Stations); err != nil {
+				log.Logger.Error(fmt.Sprintf("❌ config.yaml 有誤, 保留目前設定:\n%v", err))
+				return
+			}
+		}
+
+		alarms.SetStations(next.Stations)
+		mqttClient.SetStations(next.Stations)
+		runner.Apply(next.Stations)
+
+		if err := log.SetLevel(next.Log.Level); err != nil {
+			log.Logger.Error(fmt.Sprintf("❌ log.level: %v", err))
+		}
+		nextLog, currentLog := next.Log, current.Log
+		nextLog.Level, currentLog.Level = "", ""
+		if nextLog != currentLog {
+			log.Logger.Warn("⚠️ log 目錄 / 輪替設定變更需重新啟動才會生效")
+		}
+
+		if !reflect.DeepEqual(current.Modbus, next.Modbus) || !reflect.DeepEqual(current.Capture, next.Capture) || !reflect.DeepEqual(current.MQTT, next.MQTT) ||
+			current.Alarm != next.Alarm || current.HTTP != next.HTTP || current.EventBus != next.EventBus {
+			log.Logger.Warn("⚠️ mqtt / modbus / capture / alarm / http / event_bus 設定變更需重新啟動才會生效")
+		}
+		current = next
+		log.Logger.Info("✅ config.yaml 已重新載入")
+	}, func(err error) {
+		log.Logger.Error(fmt.Sprintf("❌ config.yaml 有誤, 保留目前設定:\n%v", err))
+	})
+
+	// ⭐ 依 startup.ready_policy 等站點連線後通知 systemd, 最多等 startup.ready_timeout,
+	// 逾時仍送 READY=1 (標示 degraded), 不然 systemd 會在 TimeoutStartSec 後把服務砍掉重來
+	readyTimeout := time.Duration(cfg.Startup.ReadyTimeout) * time.Second
+	if readyTimeout <= 0 {
+		readyTimeout = 60 * time.Second
 	}
+	systemd.Notify("STATUS=waiting for stations (ready_policy=" + cfg.Startup.ReadyPolicy + ")")
+	readyCtx, cancelReady := context.WithTimeout(ctx, readyTimeout)
+	err = runner.WaitReady(readyCtx, cfg.Startup.ReadyPolicy)
+	cancelReady()
+	switch {
+	case ctx.Err() != nil:
+		log.Logger.Warn(fmt.Sprintf("⚠️ 啟動未完成: %v", err))
+	case err != nil:
+		log.Logger.Warn(fmt.Sprintf("⚠️ %v 內未達到 ready_policy=%s (%v), 以 degraded 狀態繼續啟動", readyTimeout, cfg.Startup.ReadyPolicy, err))
+		notifySystemd(ctx, canManager, mqttClient, eb, true)
+	default:
+		log.Logger.Info(fmt.Sprintf("✅ 啟動完成 (ready_policy=%s)", cfg.Startup.ReadyPolicy))
+		notifySystemd(ctx, canManager, mqttClient, eb, false)
+	}
+
+	// ⭐ 等關機訊號後依序關閉
+	<-ctx.Done()
+	stop()
+	systemd.Notify("STOPPING=1")
+
+	shutdownCfg := current.Shutdown
+	timeout := time.Duration(shutdownCfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	log.Logger.Info(fmt.Sprintf("🛑 收到關機訊號, stop_policy=%s, 最多等待 %v", shutdownCfg.StopPolicy, timeout))
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(timeout, shutdownCfg.StopPolicy == "stop", runner, mqttClient, modbus, httpServer, alarms)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Logger.Info("👋 已關閉")
+		log.Logger.Sync()
+	case <-time.After(timeout + time.Second):
+		log.Logger.Error("❌ 關機逾時, 強制結束")
+		log.Logger.Sync()
+		os.Exit(1)
+	}
+}
+
+// shutdown 的順序: 停止接收新指令 -> 依政策停止充電並送完寫入佇列 -> 關閉站點 -> 發佈離線狀態
+func shutdown(timeout time.Duration, stopCharging bool, runner *api.StationRunner, mqttClient *api.MQTT_Client, modbus *api.ModbusServer, httpServer *api.HTTPServer, alarms *alarm.Manager) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if modbus != nil {
+		modbus.Close()
+	}
+	if httpServer != nil {
+		httpServer.Close()
+	}
+
+	stations := runner.Shutdown(ctx, stopCharging)
+	alarms.Close()
+	mqttClient.Close(stations)
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: chargestationcore [flags] [command]
+
+commands:
+  (無)           啟動服務
+  version        印出版本與建置資訊
+  print-config   印出實際生效的設定 (密碼會遮蔽)
 
-	select {}
+flags:
+`)
+	pflag.PrintDefaults()
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"kenmec/jimmy/charge_core/api"
+	"kenmec/jimmy/charge_core/events"
+	eventbus "kenmec/jimmy/charge_core/infra"
+	"kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/systemd"
+	"kenmec/jimmy/charge_core/types"
+	"sort"
+	"strings"
+	"time"
+)
+
+// statusInterval 定期更新 systemctl status 顯示的 STATUS
+const statusInterval = 30 * time.Second
+
+// notifySystemd 送 READY=1, 之後在連線狀態變化時更新 STATUS, 有設定 WatchdogSec 時啟動 watchdog。
+// degraded 表示等到 ready_timeout 仍未達到 ready_policy, 第一個 STATUS 會標示出來。
+// 沒有在 systemd 下執行時 Notify 不會做任何事
+func notifySystemd(ctx context.Context, manager *api.CANManager, mqttClient *api.MQTT_Client, eb *eventbus.EventBus, degraded bool) {
+	status := stationStatus(manager)
+	if degraded {
+		status = "degraded, " + status
+	}
+	if ok, err := systemd.Notify("READY=1\nSTATUS=" + status); err != nil {
+		log.Logger.Warn(fmt.Sprintf("⚠️ sd_notify 失敗: %v", err))
+		return
+	} else if !ok {
+		return
+	}
+
+	updates := make(chan struct{}, 1)
+	events.ConnectionTCP.Subscribe(eb, func(types.ConnectionTcp) {
+		select {
+		case updates <- struct{}{}:
+		default:
+		}
+	})
+
+	go func() {
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-updates:
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			systemd.Notify("STATUS=" + stationStatus(manager))
+		}
+	}()
+
+	interval, ok := systemd.WatchdogInterval()
+	if !ok {
+		return
+	}
+
+	wd := systemd.NewWatchdog(func(err error) {
+		log.Logger.Error(fmt.Sprintf("❌ %v, 暫停 WATCHDOG ping", err))
+	})
+
+	wd.AddCheck("mqtt heartbeat", func() error {
+		last, every := mqttClient.LastHeartbeat()
+		return systemd.Stale(last, 3*every)
+	})
+
+	wd.AddCheck("write loops", func() error {
+		var stale []string
+		for id, c := range manager.GetAllClient() {
+			if systemd.Stale(c.LastWriteLoop(), 3*api.WriteLoopTick) != nil {
+				stale = append(stale, id)
+			}
+		}
+		if len(stale) > 0 {
+			sort.Strings(stale)
+			return fmt.Errorf("stations %s stuck", strings.Join(stale, ","))
+		}
+		return nil
+	})
+
+	// event bus 派送: 每次檢查送一個 ping, 在 interval/4 內被處理才算通過,
+	// 檢查每 interval/2 跑一次, 等待時間加上去仍在 WatchdogSec 內
+	dispatchTimeout := interval / 4
+	pong := make(chan struct{}, 1)
+	events.SystemWatchdog.Subscribe(eb, func(struct{}) {
+		select {
+		case pong <- struct{}{}:
+		default:
+		}
+	})
+	wd.AddCheck("event dispatch", func() error {
+		// 上一次逾時後才到的回應不算
+		select {
+		case <-pong:
+		default:
+		}
+		events.SystemWatchdog.Publish(eb, struct{}{})
+		select {
+		case <-pong:
+			return nil
+		case <-time.After(dispatchTimeout):
+			return fmt.Errorf("ping not delivered within %v", dispatchTimeout)
+		}
+	})
+
+	log.Logger.Info(fmt.Sprintf("🐶 systemd watchdog 啟動, WatchdogSec=%v", interval))
+	go wd.Run(ctx, interval/2)
+}
+
+// stationStatus 例如 "2/3 stations connected, offline: 02"
+func stationStatus(manager *api.CANManager) string {
+	var offline []string
+	clients := manager.GetAllClient()
+	for id, c := range clients {
+		if !c.IsConnected() {
+			offline = append(offline, id)
+		}
+	}
+	sort.Strings(offline)
+
+	status := fmt.Sprintf("%d/%d stations connected", len(clients)-len(offline), len(clients))
+	if len(offline) > 0 {
+		status += ", offline: " + strings.Join(offline, ",")
+	}
+	return status
+}
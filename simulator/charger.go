@@ -0,0 +1,218 @@
+package simulator
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"kenmec/jimmy/charge_core/tool"
+)
+
+// 充電曲線參數: 先定電流 (CC) 到 ccLimit, 之後定電壓 (CV) 電流逐步下降
+const (
+	packVoltageEmpty = 320.0 // V
+	packVoltageFull  = 403.2 // V
+	maxCurrent       = 100.0 // A
+	capacityWh       = 60000.0
+	ccLimit          = 0.8
+	ambientTemp      = 25.0 // °C
+)
+
+// Charger 模擬一台充電機的狀態與電池充電曲線
+type Charger struct {
+	mu          sync.Mutex
+	stationId   string
+	charging    bool
+	soc         float64 // 0 ~ 1
+	temperature float64
+	faultBits   byte
+	heldTemp    *float64 // 情境指定的溫度, 清除故障前不會回到曲線
+	ignoreStart int      // 接下來要忽略幾次 start
+	ignoreStop  int      // 接下來要忽略幾次 stop
+	// set_current_limit / set_voltage_limit / set_max_time 設定的上限, 0 表示不限制
+	currentLimit float64
+	voltageLimit float64
+	maxTime      time.Duration
+	startedAt    time.Time
+	updated      time.Time
+}
+
+func NewCharger(stationId string, soc float64, now time.Time) *Charger {
+	return &Charger{
+		stationId:   stationId,
+		soc:         soc,
+		temperature: ambientTemp,
+		updated:     now,
+	}
+}
+
+func (c *Charger) StationId() string {
+	return c.stationId
+}
+
+// Start / Stop 對應 start / stop 指令, 有故障時不會開始充電;
+// 情境要求忽略指令時回傳 false
+func (c *Charger) Start(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+	if c.ignoreStart > 0 {
+		c.ignoreStart--
+		return false
+	}
+	if c.faultBits == 0 && !c.charging {
+		c.charging = true
+		c.startedAt = now
+	}
+	return true
+}
+
+func (c *Charger) Stop(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+	if c.ignoreStop > 0 {
+		c.ignoreStop--
+		return false
+	}
+	c.charging = false
+	return true
+}
+
+// SetFault 設定故障位元並停止充電, temperature 不為 nil 時溫度固定在該值直到 ClearFault
+func (c *Charger) SetFault(now time.Time, bits byte, temperature *int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+	c.faultBits = bits
+	c.charging = false
+	if temperature != nil {
+		t := float64(*temperature)
+		c.heldTemp = &t
+		c.temperature = t
+	}
+}
+
+func (c *Charger) ClearFault(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+	c.faultBits = 0
+	c.heldTemp = nil
+}
+
+// SetCurrentLimit / SetVoltageLimit / SetMaxTime 對應同名指令, 0 表示不限制
+func (c *Charger) SetCurrentLimit(now time.Time, a float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+	c.currentLimit = a
+}
+
+func (c *Charger) SetVoltageLimit(now time.Time, v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+	c.voltageLimit = v
+}
+
+func (c *Charger) SetMaxTime(now time.Time, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+	c.maxTime = d
+}
+
+// IgnoreCommands 讓充電機接下來忽略 start / stop 指令各 n 次
+func (c *Charger) IgnoreCommands(start, stop int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ignoreStart += start
+	c.ignoreStop += stop
+}
+
+func (c *Charger) SetSOC(now time.Time, soc float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+	c.soc = math.Max(0, math.Min(1, soc))
+}
+
+// Status 推進模擬時間後回傳目前狀態
+func (c *Charger) Status(now time.Time) tool.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+
+	st := tool.Status{
+		Charging:    c.charging,
+		Voltage:     c.voltage(),
+		Temperature: int(math.Round(c.temperature)),
+		FaultBits:   c.faultBits,
+	}
+	if c.charging {
+		st.Current = c.current()
+	}
+	return st
+}
+
+func (c *Charger) voltage() float64 {
+	return packVoltageEmpty + (packVoltageFull-packVoltageEmpty)*c.soc
+}
+
+func (c *Charger) current() float64 {
+	a := maxCurrent
+	if c.soc >= ccLimit {
+		// CV 階段電流隨 SOC 線性下降到 0
+		a = maxCurrent * (1 - c.soc) / (1 - ccLimit)
+	}
+	if c.currentLimit > 0 && a > c.currentLimit {
+		a = c.currentLimit
+	}
+	return a
+}
+
+// advance 依經過時間更新 SOC 與溫度, 充飽會自動停止
+func (c *Charger) advance(now time.Time) {
+	dt := now.Sub(c.updated).Hours()
+	c.updated = now
+	if dt <= 0 {
+		return
+	}
+
+	target := ambientTemp
+	if c.charging {
+		c.soc += c.voltage() * c.current() * dt / capacityWh
+		if c.soc >= 0.999 {
+			c.soc = 1
+			c.charging = false
+		}
+		// 到達電壓上限或充電時間上限也會停止
+		if c.voltageLimit > 0 && c.voltage() >= c.voltageLimit {
+			c.charging = false
+		}
+		if c.maxTime > 0 && now.Sub(c.startedAt) >= c.maxTime {
+			c.charging = false
+		}
+		target = ambientTemp + 20*c.current()/maxCurrent
+	}
+
+	if c.heldTemp != nil {
+		c.temperature = *c.heldTemp
+		return
+	}
+
+	// 溫度以約 10 分鐘的時間常數趨近目標
+	k := 1 - math.Exp(-dt*6)
+	c.temperature += (target - c.temperature) * k
+}
@@ -0,0 +1,140 @@
+package simulator
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+func TestChargerCCCV(t *testing.T) {
+	c := NewCharger("01", 0.2, t0)
+	if !c.Start(t0) {
+		t.Fatal("Start ignored")
+	}
+
+	st := c.Status(t0)
+	if !st.Charging || st.Current != maxCurrent {
+		t.Fatalf("at 20%% SOC got %+v, want CC at %gA", st, maxCurrent)
+	}
+	if want := packVoltageEmpty + (packVoltageFull-packVoltageEmpty)*0.2; math.Abs(st.Voltage-want) > 1e-9 {
+		t.Fatalf("voltage %g, want %g", st.Voltage, want)
+	}
+
+	prev := st
+	sawCV := false
+	now := t0
+	for i := 0; i < 6*60 && prev.Charging; i++ {
+		now = now.Add(time.Minute)
+		st = c.Status(now)
+
+		if st.Voltage < prev.Voltage {
+			t.Fatalf("voltage dropped from %g to %g at %v", prev.Voltage, st.Voltage, now.Sub(t0))
+		}
+		if st.Charging && st.Current > prev.Current {
+			t.Fatalf("current rose from %g to %g at %v", prev.Current, st.Current, now.Sub(t0))
+		}
+		if st.Charging && st.Current < maxCurrent {
+			sawCV = true
+			// CV 階段電流與剩餘容量成正比
+			soc := (st.Voltage - packVoltageEmpty) / (packVoltageFull - packVoltageEmpty)
+			if soc < ccLimit-1e-9 {
+				t.Fatalf("current %g below max at SOC %.3f, before CV", st.Current, soc)
+			}
+			if want := maxCurrent * (1 - soc) / (1 - ccLimit); math.Abs(st.Current-want) > 1e-6 {
+				t.Fatalf("CV current %g, want %g at SOC %.3f", st.Current, want, soc)
+			}
+		}
+		prev = st
+	}
+
+	if !sawCV {
+		t.Fatal("never entered CV")
+	}
+	// 充飽自動停止, 電壓停在滿電
+	if st.Charging || st.Current != 0 || st.Voltage != packVoltageFull {
+		t.Fatalf("after %v got %+v, want stopped at %gV", now.Sub(t0), st, packVoltageFull)
+	}
+	// 溫度在停止後回到環境溫度附近
+	if st = c.Status(now.Add(2 * time.Hour)); st.Temperature != int(ambientTemp) {
+		t.Fatalf("temperature %d after cooling, want %g", st.Temperature, ambientTemp)
+	}
+	// 已經充飽再 start 也不會超過 100%
+	c.Start(now)
+	if st = c.Status(now.Add(3 * time.Hour)); st.Voltage != packVoltageFull {
+		t.Fatalf("voltage %g after restart at full, want %g", st.Voltage, packVoltageFull)
+	}
+}
+
+func TestChargerFault(t *testing.T) {
+	c := NewCharger("01", 0.5, t0)
+	c.Start(t0)
+
+	temp := 85
+	c.SetFault(t0, 0x04, &temp)
+	st := c.Status(t0.Add(time.Hour))
+	if st.Charging || st.FaultBits != 0x04 || st.Temperature != 85 {
+		t.Fatalf("after fault got %+v", st)
+	}
+
+	// 故障時 start 被接受但不會開始充電
+	if !c.Start(t0.Add(time.Hour)) || c.Status(t0.Add(time.Hour)).Charging {
+		t.Fatal("started while faulted")
+	}
+
+	c.ClearFault(t0.Add(time.Hour))
+	c.Start(t0.Add(time.Hour))
+	if st = c.Status(t0.Add(time.Hour)); !st.Charging || st.FaultBits != 0 {
+		t.Fatalf("after reset got %+v", st)
+	}
+}
+
+func TestChargerLimits(t *testing.T) {
+	c := NewCharger("01", 0.2, t0)
+	c.SetCurrentLimit(t0, 32)
+	c.Start(t0)
+	if st := c.Status(t0); st.Current != 32 {
+		t.Fatalf("current %g with 32A limit", st.Current)
+	}
+
+	c.SetMaxTime(t0, 10*time.Minute)
+	if st := c.Status(t0.Add(9 * time.Minute)); !st.Charging {
+		t.Fatal("stopped before max time")
+	}
+	if st := c.Status(t0.Add(11 * time.Minute)); st.Charging {
+		t.Fatal("still charging after max time")
+	}
+
+	c = NewCharger("02", 0.2, t0)
+	c.SetVoltageLimit(t0, 350)
+	c.Start(t0)
+	now := t0
+	for c.Status(now).Charging {
+		now = now.Add(time.Minute)
+		if now.Sub(t0) > 6*time.Hour {
+			t.Fatal("voltage limit never reached")
+		}
+	}
+	if st := c.Status(now); st.Voltage < 350 || st.Voltage > 352 {
+		t.Fatalf("stopped at %gV with 350V limit", st.Voltage)
+	}
+}
+
+func TestChargerIgnoreCommands(t *testing.T) {
+	c := NewCharger("01", 0.2, t0)
+	c.IgnoreCommands(1, 1)
+
+	if c.Start(t0) || c.Status(t0).Charging {
+		t.Fatal("first start not ignored")
+	}
+	if !c.Start(t0) || !c.Status(t0).Charging {
+		t.Fatal("second start ignored")
+	}
+	if c.Stop(t0) || !c.Status(t0).Charging {
+		t.Fatal("first stop not ignored")
+	}
+	if !c.Stop(t0) || c.Status(t0).Charging {
+		t.Fatal("second stop ignored")
+	}
+}
@@ -0,0 +1,493 @@
+package simulator
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	klog "kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/tool"
+	"kenmec/jimmy/charge_core/transport"
+)
+
+// Options 控制模擬閘道器的網路行為
+type Options struct {
+	Latency         time.Duration       // 回覆前的固定延遲
+	Jitter          time.Duration       // 額外的隨機延遲 0 ~ Jitter
+	DropRate        float64             // 不回覆 read 的機率 0 ~ 1
+	CorruptRate     float64             // 回覆 checksum 錯誤的機率 0 ~ 1
+	DisconnectAfter time.Duration       // 平均多久突然斷線一次, 0 表示不斷線
+	Seed            int64               // 亂數種子, 0 表示用目前時間
+	Clock           Clock               // nil 表示用真實時間
+	Driver          tool.ProtocolDriver // 充電機 frame 格式, nil 表示 tool.DefaultDriver
+	// Transport 是服務那一端的 transport: 空白或 "tcp" 時監聽 addr 等服務連進來,
+	// "tcp_server" 時主動連到服務的 addr, "udp" 時在 addr 收 datagram 並回給送來的位址,
+	// "socketcan" 時 addr 是 CAN 介面名稱 (例如 vcan0), 直接在 CAN bus 上當充電機
+	Transport string
+	// RegisterID 不為空白時, tcp_server 連上服務後先送這個註冊封包
+	RegisterID string
+	// PushInterval 大於 0 時充電機每隔這段時間主動送出狀態, 不用等 read
+	PushInterval time.Duration
+}
+
+// Gateway 模擬一台 CAN 轉 Ethernet 閘道器, 底下接一台或多台充電機
+type Gateway struct {
+	mu           sync.Mutex
+	opts         Options
+	clock        Clock
+	rnd          *rand.Rand
+	chargers     map[string]*Charger
+	addr         net.Addr
+	listener     net.Listener       // tcp
+	packetConn   net.PacketConn     // udp
+	bus          io.ReadWriteCloser // socketcan
+	closed       chan struct{}
+	conns        map[net.Conn]struct{}
+	offlineUntil time.Time // 情境模擬閘道器離線, 這之前的連線會被直接關掉
+}
+
+func NewGateway(addr string, stationIds []string, opts Options) (*Gateway, error) {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	clock := opts.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	if opts.Driver == nil {
+		opts.Driver = tool.DefaultDriver
+	}
+
+	g := &Gateway{
+		opts:     opts,
+		clock:    clock,
+		rnd:      rand.New(rand.NewSource(seed)),
+		chargers: make(map[string]*Charger),
+		conns:    make(map[net.Conn]struct{}),
+		closed:   make(chan struct{}),
+	}
+
+	now := clock.Now()
+	for _, id := range stationIds {
+		if b, err := hex.DecodeString(id); err != nil || len(b) != 1 {
+			return nil, fmt.Errorf("station id %q must be two hex characters", id)
+		}
+		id = strings.ToLower(id)
+		g.chargers[id] = NewCharger(id, 0.2+0.1*g.rnd.Float64(), now)
+	}
+
+	switch opts.Transport {
+	case "", transport.KindTCP:
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		g.listener, g.addr = ln, ln.Addr()
+		go g.acceptLoop()
+	case transport.KindTCPServer:
+		raddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		g.addr = raddr
+		go g.dialLoop(addr)
+	case transport.KindUDP:
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		g.packetConn, g.addr = pc, pc.LocalAddr()
+		go g.packetLoop()
+	case transport.KindSocketCAN:
+		tr, err := transport.New(transport.Options{Kind: transport.KindSocketCAN, Interface: addr, Driver: opts.Driver}, klog.Logger)
+		if err != nil {
+			return nil, err
+		}
+		bus, err := tr.Open(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		g.bus, g.addr = bus, canAddr(addr)
+		go g.busLoop()
+	default:
+		return nil, fmt.Errorf("unknown transport %q", opts.Transport)
+	}
+	return g, nil
+}
+
+// Addr 是監聽的位址; tcp_server 時是要連過去的服務位址
+func (g *Gateway) Addr() net.Addr {
+	return g.addr
+}
+
+// Charger 取得模擬的充電機, 不存在回傳 nil
+func (g *Gateway) Charger(stationId string) *Charger {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.chargers[strings.ToLower(stationId)]
+}
+
+// DisconnectAll 模擬閘道器斷線, 關閉目前所有連線
+func (g *Gateway) DisconnectAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for conn := range g.conns {
+		conn.Close()
+	}
+}
+
+// Offline 斷開所有連線, 並在 d 時間內拒絕新的連線
+func (g *Gateway) Offline(d time.Duration) {
+	g.mu.Lock()
+	g.offlineUntil = g.clock.Now().Add(d)
+	g.mu.Unlock()
+
+	g.DisconnectAll()
+}
+
+// SetDropRate 調整不回覆 read 的機率
+func (g *Gateway) SetDropRate(rate float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.opts.DropRate = rate
+}
+
+func (g *Gateway) dropRate() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.opts.DropRate
+}
+
+func (g *Gateway) Close() {
+	close(g.closed)
+	if g.listener != nil {
+		g.listener.Close()
+	}
+	if g.packetConn != nil {
+		g.packetConn.Close()
+	}
+	if g.bus != nil {
+		g.bus.Close()
+	}
+	g.DisconnectAll()
+}
+
+func (g *Gateway) offline() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.clock.Now().Before(g.offlineUntil)
+}
+
+// dialLoop 模擬設定成 TCP client 的閘道器: 斷線或連不上時每 3 秒重連服務
+func (g *Gateway) dialLoop(addr string) {
+	for {
+		if !g.offline() {
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				if g.opts.RegisterID != "" {
+					conn.Write([]byte(g.opts.RegisterID))
+				}
+				g.mu.Lock()
+				g.conns[conn] = struct{}{}
+				g.mu.Unlock()
+
+				klog.Logger.Info(fmt.Sprintf("simulator: connected to %s", addr))
+				g.serve(conn)
+			}
+		}
+
+		select {
+		case <-g.closed:
+			return
+		case <-time.After(3 * time.Second):
+		}
+	}
+}
+
+// packetLoop 模擬 UDP 模式的閘道器, 每個 datagram 是一個 frame
+func (g *Gateway) packetLoop() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := g.packetConn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if g.offline() {
+			continue
+		}
+
+		splitter := tool.FrameSplitter{Driver: g.opts.Driver}
+		frames, _ := splitter.Feed(buf[:n])
+		for _, frame := range frames {
+			reply := g.handleFrame(frame)
+			if reply == nil {
+				continue
+			}
+
+			write := func() { g.packetConn.WriteTo(reply, from) }
+			if d := g.delay(); d > 0 {
+				g.clock.AfterFunc(d, write)
+			} else {
+				write()
+			}
+		}
+	}
+}
+
+func (g *Gateway) acceptLoop() {
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			klog.Logger.Error(fmt.Sprintf("simulator accept error: %v", err))
+			continue
+		}
+
+		g.mu.Lock()
+		offline := g.clock.Now().Before(g.offlineUntil)
+		if !offline {
+			g.conns[conn] = struct{}{}
+		}
+		g.mu.Unlock()
+
+		if offline {
+			conn.Close()
+			continue
+		}
+
+		klog.Logger.Info(fmt.Sprintf("simulator: %s connected", conn.RemoteAddr()))
+		go g.serve(conn)
+	}
+}
+
+func (g *Gateway) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		g.mu.Lock()
+		delete(g.conns, conn)
+		g.mu.Unlock()
+		klog.Logger.Info(fmt.Sprintf("simulator: %s disconnected", conn.RemoteAddr()))
+	}()
+
+	if g.opts.DisconnectAfter > 0 {
+		after := time.Duration(g.float64() * 2 * float64(g.opts.DisconnectAfter))
+		timer := g.clock.AfterFunc(after, func() {
+			klog.Logger.Warn(fmt.Sprintf("simulator: drop connection %s", conn.RemoteAddr()))
+			conn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	var writeMu sync.Mutex
+	if g.opts.PushInterval > 0 {
+		stop := g.push(conn, &writeMu)
+		defer stop()
+	}
+
+	splitter := tool.FrameSplitter{Driver: g.opts.Driver}
+	chunk := make([]byte, 1024)
+
+	for {
+		n, err := conn.Read(chunk)
+		if err != nil {
+			return
+		}
+
+		frames, dropped := splitter.Feed(chunk[:n])
+		if dropped > 0 {
+			klog.Logger.Warn(fmt.Sprintf("simulator: dropped %d bytes with bad checksum", dropped))
+		}
+
+		for _, frame := range frames {
+			reply := g.handleFrame(frame)
+			if reply == nil {
+				continue
+			}
+
+			write := func() {
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				conn.Write(reply)
+			}
+			if d := g.delay(); d > 0 {
+				g.clock.AfterFunc(d, write)
+			} else {
+				write()
+			}
+		}
+	}
+}
+
+// busLoop 直接在 CAN bus 上回覆, 每次讀到的是一個 frame
+func (g *Gateway) busLoop() {
+	buf := make([]byte, 64)
+	for {
+		n, err := g.bus.Read(buf)
+		if err != nil {
+			select {
+			case <-g.closed:
+			default:
+				klog.Logger.Error(fmt.Sprintf("simulator: CAN bus read error: %v", err))
+			}
+			return
+		}
+
+		frame, err := g.opts.Driver.DecodeFrame(buf[:n])
+		if err != nil || !g.opts.Driver.MatchFrame(frame) || g.offline() {
+			continue
+		}
+		reply := g.handleFrame(frame)
+		if reply == nil {
+			continue
+		}
+
+		write := func() { g.bus.Write(reply) }
+		if d := g.delay(); d > 0 {
+			g.clock.AfterFunc(d, write)
+		} else {
+			write()
+		}
+	}
+}
+
+// canAddr 讓 CAN 介面名稱可以當 net.Addr 顯示
+type canAddr string
+
+func (a canAddr) Network() string { return "can" }
+func (a canAddr) String() string  { return string(a) }
+
+// push 定期送出所有充電機的狀態, 回傳停止的函式
+func (g *Gateway) push(conn net.Conn, writeMu *sync.Mutex) func() {
+	var mu sync.Mutex
+	var timer Timer
+	stopped := false
+
+	var tick func()
+	tick = func() {
+		now := g.clock.Now()
+		for _, id := range g.stationIds() {
+			frame, err := g.opts.Driver.EncodeStatus(id, g.Charger(id).Status(now))
+			if err != nil {
+				continue
+			}
+			writeMu.Lock()
+			conn.Write(frame)
+			writeMu.Unlock()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			timer = g.clock.AfterFunc(g.opts.PushInterval, tick)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	timer = g.clock.AfterFunc(g.opts.PushInterval, tick)
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		timer.Stop()
+	}
+}
+
+func (g *Gateway) stationIds() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ids := make([]string, 0, len(g.chargers))
+	for id := range g.chargers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// handleFrame 執行指令, 需要回覆時回傳回覆的 frame
+func (g *Gateway) handleFrame(f tool.Frame) []byte {
+	charger := g.Charger(f.StationId)
+	if charger == nil {
+		return nil
+	}
+
+	cmd, args, ok := tool.DecodeCommandArgs(f)
+	if !ok {
+		klog.Logger.Warn(fmt.Sprintf("simulator: station %s unknown command % x", f.StationId, f.Data))
+		return nil
+	}
+
+	now := g.clock.Now()
+	switch cmd.Name {
+	case "start":
+		if !charger.Start(now) {
+			klog.Logger.Info(fmt.Sprintf("simulator: station %s ignored start", f.StationId))
+		}
+		return nil
+	case "stop":
+		if !charger.Stop(now) {
+			klog.Logger.Info(fmt.Sprintf("simulator: station %s ignored stop", f.StationId))
+		}
+		return nil
+	case "reset":
+		charger.ClearFault(now)
+		klog.Logger.Info(fmt.Sprintf("simulator: station %s fault reset", f.StationId))
+		return nil
+	case "set_current_limit":
+		charger.SetCurrentLimit(now, args[0])
+		return nil
+	case "set_voltage_limit":
+		charger.SetVoltageLimit(now, args[0])
+		return nil
+	case "set_max_time":
+		charger.SetMaxTime(now, time.Duration(args[0])*time.Minute)
+		return nil
+	case "identify":
+		klog.Logger.Info(fmt.Sprintf("simulator: station %s LED blinking for %gs", f.StationId, args[0]))
+		return nil
+	}
+
+	if g.float64() < g.dropRate() {
+		return nil
+	}
+
+	reply, err := g.opts.Driver.EncodeStatus(f.StationId, charger.Status(now))
+	if err != nil {
+		return nil
+	}
+	if g.float64() < g.opts.CorruptRate {
+		reply[len(reply)-1] ^= 0xFF
+	}
+	return reply
+}
+
+func (g *Gateway) delay() time.Duration {
+	d := g.opts.Latency
+	if g.opts.Jitter > 0 {
+		d += time.Duration(g.float64() * float64(g.opts.Jitter))
+	}
+	return d
+}
+
+// float64 以 lock 保護共用的 rand
+func (g *Gateway) float64() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.rnd.Float64()
+}
@@ -0,0 +1,141 @@
+package simulator
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"kenmec/jimmy/charge_core/tool"
+)
+
+// dialGateway 開一台 TCP 模擬閘道器 (站點 01, 02) 並連上
+func dialGateway(t *testing.T, opts Options) (*Gateway, net.Conn) {
+	t.Helper()
+
+	opts.Seed = 1
+	g, err := NewGateway("127.0.0.1:0", []string{"01", "02"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(g.Close)
+
+	conn, err := net.Dial("tcp", g.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return g, conn
+}
+
+func send(t *testing.T, conn net.Conn, stationId, cmd string) {
+	t.Helper()
+
+	frame, err := tool.Command(stationId, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFrames 讀到 wait 逾時為止, 回傳 checksum 正確的 frame 與丟掉的 byte 數
+func readFrames(t *testing.T, conn net.Conn, wait time.Duration) ([]tool.Frame, int) {
+	t.Helper()
+
+	var splitter tool.FrameSplitter
+	var frames []tool.Frame
+	dropped := 0
+	buf := make([]byte, 1024)
+
+	conn.SetReadDeadline(time.Now().Add(wait))
+	for {
+		n, err := conn.Read(buf)
+		f, d := splitter.Feed(buf[:n])
+		frames = append(frames, f...)
+		dropped += d
+		if err != nil {
+			return frames, dropped
+		}
+	}
+}
+
+func TestGatewayCommands(t *testing.T) {
+	g, conn := dialGateway(t, Options{})
+
+	send(t, conn, "01", "start")
+	send(t, conn, "02", "set_current_limit 32")
+	send(t, conn, "01", "read")
+	send(t, conn, "02", "status")
+
+	// 只有 read 會回覆, 每個站點一個 Status frame
+	frames, dropped := readFrames(t, conn, 300*time.Millisecond)
+	if len(frames) != 2 || dropped != 0 {
+		t.Fatalf("got %d frames, %d bytes dropped, want 2 replies", len(frames), dropped)
+	}
+	if frames[0].StationId != "01" || !tool.DecodeStatus(frames[0]).Charging {
+		t.Fatalf("station 01 reply %+v, want charging", tool.DecodeStatus(frames[0]))
+	}
+	if frames[1].StationId != "02" || tool.DecodeStatus(frames[1]).Charging {
+		t.Fatalf("station 02 reply %+v, want idle", tool.DecodeStatus(frames[1]))
+	}
+
+	// 參數指令改變充電機狀態
+	g.Charger("02").Start(time.Now())
+	if st := g.Charger("02").Status(time.Now()); st.Current != 32 {
+		t.Fatalf("station 02 current %g after set_current_limit 32", st.Current)
+	}
+
+	// 不存在的站點不回覆
+	send(t, conn, "09", "read")
+	if frames, _ := readFrames(t, conn, 200*time.Millisecond); len(frames) != 0 {
+		t.Fatalf("unknown station got %d replies", len(frames))
+	}
+}
+
+func TestGatewayBadChecksum(t *testing.T) {
+	_, conn := dialGateway(t, Options{})
+
+	bad, _ := tool.Command("01", "read")
+	bad[len(bad)-1] ^= 0xFF
+	good, _ := tool.Command("01", "read")
+
+	// 閘道器丟掉壞的 frame 後重新對齊, 後面的 frame 照常處理
+	if _, err := conn.Write(append(bad, good...)); err != nil {
+		t.Fatal(err)
+	}
+	frames, _ := readFrames(t, conn, 300*time.Millisecond)
+	if len(frames) != 1 || frames[0].StationId != "01" {
+		t.Fatalf("got %d replies after a bad frame, want 1", len(frames))
+	}
+}
+
+func TestGatewayCorruptReplies(t *testing.T) {
+	_, conn := dialGateway(t, Options{CorruptRate: 1})
+
+	send(t, conn, "01", "read")
+	frames, dropped := readFrames(t, conn, 300*time.Millisecond)
+	if len(frames) != 0 || dropped == 0 {
+		t.Fatalf("got %d valid frames, %d bytes dropped, want only corrupt bytes", len(frames), dropped)
+	}
+}
+
+func TestGatewayDroppedReplies(t *testing.T) {
+	g, conn := dialGateway(t, Options{DropRate: 1})
+
+	// 指令照常執行, 只有 read 不回覆
+	send(t, conn, "01", "start")
+	send(t, conn, "01", "read")
+	if frames, _ := readFrames(t, conn, 300*time.Millisecond); len(frames) != 0 {
+		t.Fatalf("got %d replies with drop rate 1", len(frames))
+	}
+	if !g.Charger("01").Status(time.Now()).Charging {
+		t.Fatal("start was dropped")
+	}
+
+	g.SetDropRate(0)
+	send(t, conn, "01", "read")
+	if frames, _ := readFrames(t, conn, 300*time.Millisecond); len(frames) != 1 {
+		t.Fatalf("got %d replies after SetDropRate(0), want 1", len(frames))
+	}
+}
@@ -0,0 +1,219 @@
+package simulator
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	klog "kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/tool"
+
+	"github.com/spf13/viper"
+)
+
+// Scenario 是一份情境腳本, 依時間軸對模擬閘道器注入事件
+//
+//	name: "station 02 over temperature"
+//	seed: 42
+//	stations: ["01", "02"]
+//	events:
+//	  - at: 30s
+//	    station: "02"
+//	    action: fault
+//	    faults: [over_temperature]
+//	    temperature: 95
+//	  - at: 60s
+//	    action: offline
+//	    for: 10s
+//	  - at: 0s
+//	    station: "01"
+//	    action: ignore_stop
+//	    count: 2
+type Scenario struct {
+	Name     string          `mapstructure:"name"`
+	Seed     int64           `mapstructure:"seed"`
+	Stations []string        `mapstructure:"stations"`
+	Events   []ScenarioEvent `mapstructure:"events"`
+}
+
+// ScenarioEvent 的 action:
+//
+//	fault        設定 faults (故障代碼, 見 tool.Faults) 或 fault_bits (可選 temperature 固定溫度), 有 for 時到期自動清除
+//	clear_fault  清除故障
+//	offline      閘道器斷線, for 期間拒絕重新連線
+//	disconnect   只斷開目前的連線, 可以立即重連
+//	ignore_start 接下來忽略 count 次 start
+//	ignore_stop  接下來忽略 count 次 stop
+//	drop         for 期間以 rate (預設 1) 的機率不回覆 read
+//	soc          把電池 SOC 設成 soc (0-1)
+type ScenarioEvent struct {
+	At          time.Duration `mapstructure:"at"`
+	Station     string        `mapstructure:"station"`
+	Action      string        `mapstructure:"action"`
+	Faults      []string      `mapstructure:"faults"`
+	FaultBits   uint8         `mapstructure:"fault_bits"`
+	Temperature *int          `mapstructure:"temperature"`
+	For         time.Duration `mapstructure:"for"`
+	Count       int           `mapstructure:"count"`
+	Rate        float64       `mapstructure:"rate"`
+	SOC         float64       `mapstructure:"soc"`
+}
+
+// LoadScenario 讀取 YAML 情境檔並檢查內容
+func LoadScenario(path string) (*Scenario, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading scenario file: %w", err)
+	}
+
+	var s Scenario
+	if err := v.Unmarshal(&s); err != nil {
+		return nil, fmt.Errorf("unable to decode scenario: %w", err)
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate 檢查事件內容, 並把 faults 的故障代碼併入 fault_bits
+func (s *Scenario) Validate() error {
+	for i := range s.Events {
+		ev := &s.Events[i]
+		if ev.At < 0 {
+			return fmt.Errorf("events[%d].at must not be negative", i)
+		}
+
+		switch ev.Action {
+		case "fault", "clear_fault", "ignore_start", "ignore_stop", "soc":
+			if ev.Station == "" {
+				return fmt.Errorf("events[%d]: action %s needs a station", i, ev.Action)
+			}
+		case "offline", "drop":
+			if ev.For <= 0 {
+				return fmt.Errorf("events[%d]: action %s needs a positive for", i, ev.Action)
+			}
+		case "disconnect":
+		default:
+			return fmt.Errorf("events[%d]: unknown action %q", i, ev.Action)
+		}
+
+		for _, code := range ev.Faults {
+			f, ok := tool.FaultByCode(code)
+			if !ok {
+				return fmt.Errorf("events[%d]: unknown fault %q", i, code)
+			}
+			ev.FaultBits |= f.Bit
+		}
+		if ev.Action == "fault" && ev.FaultBits == 0 {
+			return fmt.Errorf("events[%d]: fault needs faults or non-zero fault_bits", i)
+		}
+		if (ev.Action == "ignore_start" || ev.Action == "ignore_stop") && ev.Count <= 0 {
+			return fmt.Errorf("events[%d]: %s needs a positive count", i, ev.Action)
+		}
+	}
+	return nil
+}
+
+// ScenarioRun 是一次執行中的情境, Done 在最後一個事件 (含 for 期間) 結束後關閉
+type ScenarioRun struct {
+	mu      sync.Mutex
+	clock   Clock
+	timers  []Timer // 包含事件排出的自動清除, Stop 時一併取消
+	stopped bool
+	done    chan struct{}
+}
+
+func (r *ScenarioRun) Done() <-chan struct{} {
+	return r.done
+}
+
+// Stop 取消還沒執行的事件, 包含 fault / drop 的 for 到期後的自動清除
+func (r *ScenarioRun) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopped = true
+	for _, t := range r.timers {
+		t.Stop()
+	}
+}
+
+// after 排程 f 並記在 timers 裡, Stop 之後不再排程
+func (r *ScenarioRun) after(d time.Duration, f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return
+	}
+	r.timers = append(r.timers, r.clock.AfterFunc(d, f))
+}
+
+// RunScenario 依閘道器的 Clock 排程情境事件, 同一時間的事件依檔案中的順序執行
+func (g *Gateway) RunScenario(s *Scenario) (*ScenarioRun, error) {
+	for i, ev := range s.Events {
+		if ev.Station != "" && g.Charger(ev.Station) == nil {
+			return nil, fmt.Errorf("events[%d]: station %s is not simulated", i, ev.Station)
+		}
+	}
+
+	events := append([]ScenarioEvent(nil), s.Events...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At < events[j].At })
+
+	run := &ScenarioRun{clock: g.clock, done: make(chan struct{})}
+
+	var end time.Duration
+	for _, ev := range events {
+		ev := ev
+		run.after(ev.At, func() { g.applyEvent(run, ev) })
+
+		if ev.At+ev.For > end {
+			end = ev.At + ev.For
+		}
+	}
+	run.after(end, func() { close(run.done) })
+
+	klog.Logger.Info(fmt.Sprintf("scenario %q: %d events over %v", s.Name, len(events), end))
+	return run, nil
+}
+
+func (g *Gateway) applyEvent(run *ScenarioRun, ev ScenarioEvent) {
+	now := g.clock.Now()
+	charger := g.Charger(ev.Station)
+
+	klog.Logger.Info(fmt.Sprintf("scenario t=%v: %s %s", ev.At, ev.Action, ev.Station))
+
+	switch ev.Action {
+	case "fault":
+		charger.SetFault(now, ev.FaultBits, ev.Temperature)
+		if ev.For > 0 {
+			run.after(ev.For, func() { charger.ClearFault(g.clock.Now()) })
+		}
+	case "clear_fault":
+		charger.ClearFault(now)
+	case "offline":
+		g.Offline(ev.For)
+	case "disconnect":
+		g.DisconnectAll()
+	case "ignore_start":
+		charger.IgnoreCommands(ev.Count, 0)
+	case "ignore_stop":
+		charger.IgnoreCommands(0, ev.Count)
+	case "drop":
+		rate := ev.Rate
+		if rate == 0 {
+			rate = 1
+		}
+		prev := g.dropRate()
+		g.SetDropRate(rate)
+		run.after(ev.For, func() { g.SetDropRate(prev) })
+	case "soc":
+		charger.SetSOC(now, ev.SOC)
+	}
+}
@@ -0,0 +1,157 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeGateway 開一台用 FakeClock 的模擬閘道器, 站點 01, 02
+func newFakeGateway(t *testing.T) (*Gateway, *FakeClock) {
+	t.Helper()
+
+	clock := NewFakeClock(t0)
+	g, err := NewGateway("127.0.0.1:0", []string{"01", "02"}, Options{Clock: clock, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(g.Close)
+	return g, clock
+}
+
+func runScenario(t *testing.T, g *Gateway, events ...ScenarioEvent) *ScenarioRun {
+	t.Helper()
+
+	s := &Scenario{Name: t.Name(), Events: events}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	run, err := g.RunScenario(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func isDone(run *ScenarioRun) bool {
+	select {
+	case <-run.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestScenarioTimeline(t *testing.T) {
+	g, clock := newFakeGateway(t)
+	temp := 95
+
+	run := runScenario(t, g,
+		ScenarioEvent{At: 30 * time.Second, Station: "02", Action: "fault", Faults: []string{"over_temperature"}, Temperature: &temp, For: 10 * time.Second},
+		ScenarioEvent{At: 5 * time.Second, Action: "drop", Rate: 0.5, For: 5 * time.Second},
+		ScenarioEvent{At: 0, Station: "01", Action: "soc", SOC: 0.9},
+		ScenarioEvent{At: 0, Station: "01", Action: "ignore_stop", Count: 1},
+	)
+
+	clock.Advance(0)
+	if st := g.Charger("01").Status(clock.Now()); st.Voltage != packVoltageEmpty+(packVoltageFull-packVoltageEmpty)*0.9 {
+		t.Fatalf("voltage %g after soc 0.9", st.Voltage)
+	}
+	if g.Charger("01").Stop(clock.Now()) {
+		t.Fatal("stop not ignored")
+	}
+
+	clock.Advance(5 * time.Second)
+	if rate := g.dropRate(); rate != 0.5 {
+		t.Fatalf("drop rate %g at 5s, want 0.5", rate)
+	}
+	clock.Advance(5 * time.Second)
+	if rate := g.dropRate(); rate != 0 {
+		t.Fatalf("drop rate %g at 10s, want restored to 0", rate)
+	}
+
+	clock.Advance(19 * time.Second)
+	if st := g.Charger("02").Status(clock.Now()); st.FaultBits != 0 {
+		t.Fatalf("fault before 30s: %+v", st)
+	}
+	clock.Advance(time.Second)
+	if st := g.Charger("02").Status(clock.Now()); st.FaultBits == 0 || st.Temperature != 95 {
+		t.Fatalf("no fault at 30s: %+v", st)
+	}
+	if isDone(run) {
+		t.Fatal("done before the fault cleared")
+	}
+
+	clock.Advance(10 * time.Second)
+	if st := g.Charger("02").Status(clock.Now()); st.FaultBits != 0 {
+		t.Fatalf("fault not cleared at 40s: %+v", st)
+	}
+	if !isDone(run) {
+		t.Fatal("not done at 40s")
+	}
+}
+
+func TestScenarioStopCancelsAutoClear(t *testing.T) {
+	g, clock := newFakeGateway(t)
+
+	run := runScenario(t, g,
+		ScenarioEvent{At: time.Second, Station: "02", Action: "fault", FaultBits: 0x01, For: 10 * time.Second},
+		ScenarioEvent{At: time.Second, Action: "drop", For: 10 * time.Second},
+		ScenarioEvent{At: 5 * time.Second, Station: "01", Action: "fault", FaultBits: 0x01},
+	)
+
+	clock.Advance(2 * time.Second)
+	run.Stop()
+	clock.Advance(time.Minute)
+
+	// fault / drop 已生效, 它們的自動清除與之後的事件都被取消
+	if st := g.Charger("02").Status(clock.Now()); st.FaultBits != 0x01 {
+		t.Fatalf("station 02 fault cleared after Stop: %+v", st)
+	}
+	if rate := g.dropRate(); rate != 1 {
+		t.Fatalf("drop rate restored to %g after Stop", rate)
+	}
+	if st := g.Charger("01").Status(clock.Now()); st.FaultBits != 0 {
+		t.Fatalf("station 01 event ran after Stop: %+v", st)
+	}
+	if isDone(run) {
+		t.Fatal("done after Stop")
+	}
+}
+
+func TestScenarioValidate(t *testing.T) {
+	tests := []struct {
+		ev   ScenarioEvent
+		want string
+	}{
+		{ScenarioEvent{At: -time.Second, Action: "disconnect"}, "must not be negative"},
+		{ScenarioEvent{Action: "fault", FaultBits: 1}, "needs a station"},
+		{ScenarioEvent{Action: "offline"}, "positive for"},
+		{ScenarioEvent{Action: "explode"}, "unknown action"},
+		{ScenarioEvent{Station: "01", Action: "fault", Faults: []string{"nope"}}, "unknown fault"},
+		{ScenarioEvent{Station: "01", Action: "fault"}, "needs faults"},
+		{ScenarioEvent{Station: "01", Action: "ignore_stop"}, "positive count"},
+	}
+
+	for _, tt := range tests {
+		s := &Scenario{Events: []ScenarioEvent{tt.ev}}
+		if err := s.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: got %v, want %q", tt.ev, err, tt.want)
+		}
+	}
+
+	g, _ := newFakeGateway(t)
+	if _, err := g.RunScenario(&Scenario{Events: []ScenarioEvent{{Station: "09", Action: "clear_fault"}}}); err == nil {
+		t.Error("RunScenario accepted a station that is not simulated")
+	}
+}
+
+func TestLoadScenarioExample(t *testing.T) {
+	s, err := LoadScenario("../cmd/chargesim/scenarios/over_temperature.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Events) == 0 || s.Events[1].FaultBits == 0 {
+		t.Fatalf("faults not merged into fault_bits: %+v", s.Events)
+	}
+}
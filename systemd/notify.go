@@ -0,0 +1,48 @@
+// Package systemd 實作 sd_notify 協定, 不依賴 libsystemd:
+// 服務以 Type=notify 執行時把 READY / STATUS / WATCHDOG 狀態送到 $NOTIFY_SOCKET
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify 把 state (例如 "READY=1", "STATUS=...") 送到 $NOTIFY_SOCKET,
+// 沒有在 systemd 下執行 (沒有 NOTIFY_SOCKET) 時回傳 false, nil
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// @ 開頭是 Linux abstract socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval 回傳 systemd 設定的 WatchdogSec, 沒有開啟或不是給這個 process 時回傳 false
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
@@ -0,0 +1,97 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotify 開一個 unixgram socket 代替 systemd 的 notify socket, 並設定 NOTIFY_SOCKET
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	// unix socket 路徑有長度限制, 不用 t.TempDir
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := listenNotify(t)
+
+	for _, state := range []string{"READY=1\nSTATUS=degraded, 1/2 stations connected, offline: 02", "WATCHDOG=1", "STOPPING=1"} {
+		ok, err := Notify(state)
+		if !ok || err != nil {
+			t.Fatalf("Notify(%q) = %v, %v", state, ok, err)
+		}
+		if got := readNotify(t, conn); got != state {
+			t.Fatalf("received %q, want %q", got, state)
+		}
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	if ok, err := Notify("READY=1"); ok || err != nil {
+		t.Fatalf("Notify without NOTIFY_SOCKET = %v, %v", ok, err)
+	}
+}
+
+func TestNotifyUnreachableSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+
+	if ok, err := Notify("READY=1"); ok || err == nil {
+		t.Fatalf("Notify to missing socket = %v, %v", ok, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+		ok        bool
+	}{
+		{"", "", 0, false},
+		{"0", "", 0, false},
+		{"30000000", "", 30 * time.Second, true},
+		{"30000000", strconv.Itoa(os.Getpid()), 30 * time.Second, true},
+		{"30000000", "1", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+
+		if got, ok := WatchdogInterval(); got != tt.want || ok != tt.ok {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: got %v, %v, want %v, %v", tt.usec, tt.pid, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watchdog 定期執行所有檢查, 全部通過才送 WATCHDOG=1;
+// 任何一個內部迴圈卡住時停止 ping, 讓 systemd 在 WatchdogSec 到期後重啟服務
+type Watchdog struct {
+	mu     sync.Mutex
+	checks []watchdogCheck
+	onFail func(err error)
+}
+
+type watchdogCheck struct {
+	name string
+	fn   func() error
+}
+
+// NewWatchdog 建立 Watchdog, onFail 在檢查失敗 (不送 ping) 時被呼叫, 可以為 nil
+func NewWatchdog(onFail func(err error)) *Watchdog {
+	return &Watchdog{onFail: onFail}
+}
+
+// AddCheck 註冊一個檢查, fn 回傳錯誤表示該迴圈沒有在前進
+func (w *Watchdog) AddCheck(name string, fn func() error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.checks = append(w.checks, watchdogCheck{name: name, fn: fn})
+}
+
+// Check 執行所有檢查, 回傳所有失敗的項目
+func (w *Watchdog) Check() error {
+	w.mu.Lock()
+	checks := append([]watchdogCheck(nil), w.checks...)
+	w.mu.Unlock()
+
+	var failed []string
+	for _, c := range checks {
+		if err := c.fn(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", c.name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("watchdog check failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Run 每 interval 檢查一次, 通過就送 WATCHDOG=1, 直到 ctx 結束。
+// interval 通常取 WatchdogInterval 的一半
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := w.Check(); err != nil {
+			if w.onFail != nil {
+				w.onFail(err)
+			}
+			continue
+		}
+		Notify("WATCHDOG=1")
+	}
+}
+
+// Stale 是給 AddCheck 用的小工具: last 距今超過 maxAge 時回傳錯誤
+func Stale(last time.Time, maxAge time.Duration) error {
+	if last.IsZero() {
+		return fmt.Errorf("never ran")
+	}
+	if age := time.Since(last); age > maxAge {
+		return fmt.Errorf("no progress for %v", age.Round(time.Second))
+	}
+	return nil
+}
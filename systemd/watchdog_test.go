@@ -0,0 +1,68 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// drainNotify 讀掉 socket 裡已經收到的訊息, 直到 wait 內沒有新的訊息, 回傳讀到的
+func drainNotify(conn *net.UnixConn, wait time.Duration) []string {
+	var got []string
+	buf := make([]byte, 4096)
+	for {
+		conn.SetReadDeadline(time.Now().Add(wait))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return got
+		}
+		got = append(got, string(buf[:n]))
+	}
+}
+
+func TestWatchdogStopsPingOnFailedCheck(t *testing.T) {
+	conn := listenNotify(t)
+
+	var stuck atomic.Bool
+	failures := make(chan error, 100)
+	wd := NewWatchdog(func(err error) { failures <- err })
+	wd.AddCheck("ok loop", func() error { return nil })
+	wd.AddCheck("write loops", func() error {
+		if stuck.Load() {
+			return errors.New("stations 01 stuck")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go wd.Run(ctx, 10*time.Millisecond)
+
+	if got := readNotify(t, conn); got != "WATCHDOG=1" {
+		t.Fatalf("received %q, want WATCHDOG=1", got)
+	}
+
+	stuck.Store(true)
+	select {
+	case err := <-failures:
+		if !strings.Contains(err.Error(), "write loops: stations 01 stuck") || strings.Contains(err.Error(), "ok loop") {
+			t.Fatalf("onFail error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("onFail not called")
+	}
+	// 失敗之前已經送出的 ping 先讀掉, 之後的幾個週期都不能再有 ping
+	drainNotify(conn, 20*time.Millisecond)
+	if got := drainNotify(conn, 100*time.Millisecond); len(got) != 0 {
+		t.Fatalf("received %q while a check was failing", got)
+	}
+
+	stuck.Store(false)
+	if got := readNotify(t, conn); got != "WATCHDOG=1" {
+		t.Fatalf("received %q after recovery, want WATCHDOG=1", got)
+	}
+}
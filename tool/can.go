@@ -0,0 +1,137 @@
+package tool
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const (
+	frameInfoExtended = 0x80 // bit7: 1 擴展幀 (29-bit ID), 0 標準幀 (11-bit ID)
+	frameInfoRemote   = 0x40 // bit6: 1 遠程幀, 0 數據幀
+	frameInfoDLC      = 0x0F // bit0-3: DLC
+
+	MaxStandardID = 0x7FF
+	MaxExtendedID = 0x1FFFFFFF
+)
+
+// FrameInfo 是閘道器 frame 的 frame-info byte (前綴第 3 個 byte)
+type FrameInfo struct {
+	Extended bool
+	Remote   bool
+	DLC      int
+}
+
+func (fi FrameInfo) Byte() byte {
+	b := byte(fi.DLC) & frameInfoDLC
+	if fi.Extended {
+		b |= frameInfoExtended
+	}
+	if fi.Remote {
+		b |= frameInfoRemote
+	}
+	return b
+}
+
+// ParseFrameInfo 解析 frame-info byte, DLC 超過 8 視為錯誤
+func ParseFrameInfo(b byte) (FrameInfo, error) {
+	fi := FrameInfo{
+		Extended: b&frameInfoExtended != 0,
+		Remote:   b&frameInfoRemote != 0,
+		DLC:      int(b & frameInfoDLC),
+	}
+	if fi.DLC > canDataLen {
+		return fi, fmt.Errorf("frame info %02x: DLC %d exceeds %d", b, fi.DLC, canDataLen)
+	}
+	return fi, nil
+}
+
+// CANAddress 是站點在 CAN bus 上的定址
+type CANAddress struct {
+	ID       uint32 // 送給充電機的 CAN ID
+	RxID     uint32 // 充電機回覆的 CAN ID
+	Extended bool   // 使用 29-bit 擴展幀
+}
+
+// DefaultCANAddress 是原本寫死在前綴的 00000f00 標準幀;
+// 0xf00 其實超過 11 bits, 但現有閘道器照收, 所以只在沒有設定 can 時沿用, 不做檢查
+var DefaultCANAddress = CANAddress{ID: 0xF00, RxID: 0xF00}
+
+// Validate 檢查 CAN ID 是否在標準幀 / 擴展幀的範圍內
+func (a CANAddress) Validate() error {
+	max, kind := uint32(MaxStandardID), "11-bit"
+	if a.Extended {
+		max, kind = MaxExtendedID, "29-bit"
+	}
+	if a.ID > max {
+		return fmt.Errorf("CAN id %#x exceeds %s range %#x", a.ID, kind, max)
+	}
+	if a.RxID > max {
+		return fmt.Errorf("CAN rx id %#x exceeds %s range %#x", a.RxID, kind, max)
+	}
+	return nil
+}
+
+// Reverse 回傳充電機端看到的定址 (送出與接收對調), 模擬器使用
+func (a CANAddress) Reverse() CANAddress {
+	a.ID, a.RxID = a.RxID, a.ID
+	return a
+}
+
+func (a CANAddress) String() string {
+	kind := "std"
+	if a.Extended {
+		kind = "ext"
+	}
+	if a.ID == a.RxID {
+		return fmt.Sprintf("%#x/%s", a.ID, kind)
+	}
+	return fmt.Sprintf("tx %#x rx %#x/%s", a.ID, a.RxID, kind)
+}
+
+// buildFrame 組出閘道器 frame 不含檢查碼的部分:
+// 2 bytes header + frame info + 4 bytes CAN ID (big endian) + 8 bytes CAN data
+func buildFrame(info FrameInfo, id uint32, data []byte) ([]byte, error) {
+	if info.DLC < 0 || info.DLC > canDataLen {
+		return nil, fmt.Errorf("DLC %d out of range 0-%d", info.DLC, canDataLen)
+	}
+	if info.Remote {
+		if len(data) != 0 {
+			return nil, fmt.Errorf("remote frame carries no data, got %d bytes", len(data))
+		}
+	} else if info.DLC != len(data) {
+		return nil, fmt.Errorf("DLC %d does not match %d bytes of data", info.DLC, len(data))
+	}
+
+	pkt := make([]byte, gatewayPrefixLen+canDataLen)
+	pkt[2] = info.Byte()
+	binary.BigEndian.PutUint32(pkt[3:gatewayPrefixLen], id)
+	copy(pkt[gatewayPrefixLen:], data)
+	return pkt, nil
+}
+
+// ParseCANAddress 解析命令列的 CAN ID (可用 0x 開頭的 hex);
+// 都沒指定時回傳 DefaultCANAddress, rxId 空白表示同 id
+func ParseCANAddress(id, rxId string, extended bool) (CANAddress, error) {
+	if id == "" && rxId == "" && !extended {
+		return DefaultCANAddress, nil
+	}
+	if id == "" {
+		return CANAddress{}, fmt.Errorf("CAN id is required when rx id or extended is set")
+	}
+	if rxId == "" {
+		rxId = id
+	}
+
+	tx, err := strconv.ParseUint(id, 0, 32)
+	if err != nil {
+		return CANAddress{}, fmt.Errorf("CAN id %q: %v", id, err)
+	}
+	rx, err := strconv.ParseUint(rxId, 0, 32)
+	if err != nil {
+		return CANAddress{}, fmt.Errorf("CAN rx id %q: %v", rxId, err)
+	}
+
+	addr := CANAddress{ID: uint32(tx), RxID: uint32(rx), Extended: extended}
+	return addr, addr.Validate()
+}
@@ -1,42 +1,177 @@
 package tool
 
 import (
-	"encoding/hex"
 	"fmt"
-	"log"
+	"math"
+	"strconv"
+	"strings"
 )
 
-func Command(stationId, cmd string) ([]byte, error) {
+// Param 是指令參數, 送出時乘上 Scale 後以 uint16 big endian 依序放在 payload 前面
+type Param struct {
+	Name    string
+	Unit    string
+	Min     float64
+	Max     float64
+	Scale   float64 // 例如 10 表示以 0.1 為單位
+	Default *float64
+}
+
+// CommandSpec 描述一個充電機指令: 名稱、payload 最後一個 byte 的指令碼與參數
+type CommandSpec struct {
+	Name    string
+	Aliases []string
+	Code    byte
+	Params  []Param
+	Doc     string
+}
 
-	switch cmd {
-	case "start":
-		return buildCommand(stationId, "00000000000001")
-	case "stop":
-		return buildCommand(stationId, "00000000000000")
-	//  case "read":
-	//  	return buildCommand(stationId, "00000000000077")
-	default:
-		return []byte{}, fmt.Errorf("unknown cmd")
+func defaultValue(v float64) *float64 { return &v }
+
+// Commands 是所有可以送給充電機的指令; payload 共 7 bytes, 最後一個 byte 是指令碼
+var Commands = []CommandSpec{
+	{Name: "start", Code: 0x01, Doc: "開始充電"},
+	{Name: "stop", Code: 0x00, Doc: "停止充電"},
+	{Name: "read", Aliases: []string{"status"}, Code: 0x77, Doc: "讀取狀態, 充電機以 Status frame 回覆"},
+	{Name: "reset", Aliases: []string{"fault_reset"}, Code: 0x10, Doc: "清除故障, 故障條件仍存在時會再次出現"},
+	{
+		Name: "set_current_limit", Code: 0x20, Doc: "設定輸出電流上限",
+		Params: []Param{{Name: "current", Unit: "A", Min: 0, Max: 250, Scale: 10}},
+	},
+	{
+		Name: "set_voltage_limit", Code: 0x21, Doc: "設定輸出電壓上限",
+		Params: []Param{{Name: "voltage", Unit: "V", Min: 0, Max: 1000, Scale: 10}},
+	},
+	{
+		Name: "set_max_time", Code: 0x22, Doc: "設定單次充電最長時間, 0 表示不限制",
+		Params: []Param{{Name: "minutes", Unit: "min", Min: 0, Max: 1440, Scale: 1}},
+	},
+	{
+		Name: "identify", Code: 0x30, Doc: "LED 閃爍, 方便現場找到充電機",
+		Params: []Param{{Name: "seconds", Unit: "s", Min: 1, Max: 60, Scale: 1, Default: defaultValue(10)}},
+	},
+}
+
+// LookupCommand 依名稱或別名找指令
+func LookupCommand(name string) (CommandSpec, bool) {
+	for _, c := range Commands {
+		if c.Name == name {
+			return c, true
+		}
+		for _, a := range c.Aliases {
+			if a == name {
+				return c, true
+			}
+		}
 	}
+	return CommandSpec{}, false
 }
 
-func buildCommand(stationId, payload string) ([]byte, error) {
-	const startBuffer string = "00000800000f00"
+// Usage 例如 "set_current_limit <current A 0-250>"
+func (c CommandSpec) Usage() string {
+	s := c.Name
+	for _, p := range c.Params {
+		arg := fmt.Sprintf("%s %s %g-%g", p.Name, p.Unit, p.Min, p.Max)
+		if p.Default != nil {
+			s += fmt.Sprintf(" [%s, 預設 %g]", arg, *p.Default)
+		} else {
+			s += fmt.Sprintf(" <%s>", arg)
+		}
+	}
+	return s
+}
+
+// Encode 檢查參數範圍並組出 7 bytes 的 payload
+func (c CommandSpec) Encode(args []float64) ([7]byte, error) {
+	var payload [7]byte
+	if len(args) > len(c.Params) {
+		return payload, fmt.Errorf("%s takes %d parameter(s), got %d", c.Name, len(c.Params), len(args))
+	}
 
-	bufferedStr, err := hex.DecodeString(startBuffer + stationId + payload)
+	for i, p := range c.Params {
+		var v float64
+		switch {
+		case i < len(args):
+			v = args[i]
+		case p.Default != nil:
+			v = *p.Default
+		default:
+			return payload, fmt.Errorf("usage: %s", c.Usage())
+		}
+		if math.IsNaN(v) || v < p.Min || v > p.Max {
+			return payload, fmt.Errorf("%s %g %s out of range %g-%g", p.Name, v, p.Unit, p.Min, p.Max)
+		}
 
-	if err != nil {
-		log.Fatal("字串解析失敗，請確保字串長度為偶數且只包含 0-9, a-f:", err)
-		return nil, err
+		raw := uint16(math.Round(v * p.Scale))
+		payload[i*2], payload[i*2+1] = byte(raw>>8), byte(raw)
 	}
+	payload[6] = c.Code
+	return payload, nil
+}
 
-	checksum := calculateChecksum(bufferedStr)
+// Decode 是 Encode 的反向, 由 payload 還原參數
+func (c CommandSpec) Decode(payload [7]byte) []float64 {
+	args := make([]float64, len(c.Params))
+	for i, p := range c.Params {
+		raw := uint16(payload[i*2])<<8 | uint16(payload[i*2+1])
+		args[i] = float64(raw) / p.Scale
+	}
+	return args
+}
 
-	//fmt.Println(checksum, "check sum")
-	bufferedStr = append(bufferedStr, checksum)
+// ParseCommand 解析 "set_current_limit 32.5" 這種指令字串
+func ParseCommand(cmd string) (CommandSpec, []float64, error) {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return CommandSpec{}, nil, fmt.Errorf("unknown cmd")
+	}
 
-	return bufferedStr, nil
+	spec, ok := LookupCommand(fields[0])
+	if !ok {
+		return CommandSpec{}, nil, fmt.Errorf("unknown cmd")
+	}
+
+	args := make([]float64, 0, len(fields)-1)
+	for _, f := range fields[1:] {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return CommandSpec{}, nil, fmt.Errorf("%s: %q is not a number", spec.Name, f)
+		}
+		args = append(args, v)
+	}
+	return spec, args, nil
+}
+
+// Command 以 DefaultDriver 把指令字串 (例如 "start", "set_current_limit 32.5") 組成要送出的 frame
+func Command(stationId, cmd string) ([]byte, error) {
+	return DefaultDriver.EncodeCommand(stationId, cmd)
+}
 
+// DecodeCommandArgs 由 frame 的最後一個 payload byte 反推指令與參數
+func DecodeCommandArgs(f Frame) (CommandSpec, []float64, bool) {
+	var payload [7]byte
+	copy(payload[:], f.Data[1:])
+
+	for _, c := range Commands {
+		if c.Code == payload[6] {
+			return c, c.Decode(payload), true
+		}
+	}
+	return CommandSpec{}, nil, false
+}
+
+// DecodeCommand 由 frame 反推 Command 的指令字串, 有參數時一併附上
+func DecodeCommand(f Frame) (string, bool) {
+	spec, args, ok := DecodeCommandArgs(f)
+	if !ok {
+		return "", false
+	}
+
+	s := spec.Name
+	for _, a := range args {
+		s += " " + strconv.FormatFloat(a, 'f', -1, 64)
+	}
+	return s, true
 }
 
 func calculateChecksum(data []byte) byte {
@@ -0,0 +1,53 @@
+package tool
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// 原本 buildCommand 組出的 frame, DefaultDriver 要逐 byte 相同
+func TestCommandMatchesLegacyFrames(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"start", "00000800000f000100000000000001" + "19"},
+		{"stop", "00000800000f000100000000000000" + "18"},
+		{"read", "00000800000f000100000000000077" + "8f"},
+	}
+	for _, tt := range tests {
+		got, err := Command("01", tt.cmd)
+		if err != nil {
+			t.Fatalf("Command(%q): %v", tt.cmd, err)
+		}
+		want, _ := hex.DecodeString(tt.want)
+		if !bytes.Equal(got, want) {
+			t.Errorf("Command(%q) = % x, want % x", tt.cmd, got, want)
+		}
+	}
+}
+
+func TestCommandRejectsBadStationId(t *testing.T) {
+	for _, id := range []string{"", "1", "zz", "0102"} {
+		if _, err := Command(id, "start"); err == nil {
+			t.Errorf("Command(%q) returned no error", id)
+		}
+	}
+}
+
+func TestCommandArgsRoundTrip(t *testing.T) {
+	for _, cmd := range []string{"set_current_limit 32.5", "set_voltage_limit 410", "identify 10", "reset"} {
+		frame, err := Command("0a", cmd)
+		if err != nil {
+			t.Fatalf("Command(%q): %v", cmd, err)
+		}
+		f, err := DefaultDriver.DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := DecodeCommand(f); !ok || got != cmd {
+			t.Errorf("DecodeCommand = %q, %v, want %q", got, ok, cmd)
+		}
+	}
+}
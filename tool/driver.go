@@ -0,0 +1,216 @@
+package tool
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProtocolDriver 是一種充電機 / 閘道器的 frame 格式, 每個站點依型號選一個
+type ProtocolDriver interface {
+	Name() string
+	// FrameLen 是一個完整 frame 的長度
+	FrameLen() int
+	// EncodeCommand 把指令字串 (例如 "start", "set_current_limit 32") 組成 frame
+	EncodeCommand(stationId, cmd string) ([]byte, error)
+	// DecodeFrame 解析一個完整的 frame 並驗證 checksum
+	DecodeFrame(pkt []byte) (Frame, error)
+	// EncodeStatus 組出充電機回覆 read 的 frame, 模擬器使用
+	EncodeStatus(stationId string, st Status) ([]byte, error)
+	// Checksum 計算 frame 應有的 checksum bytes (frame 最後 ChecksumLen 個 byte)
+	Checksum(pkt []byte) []byte
+	// PollCommand 是定期輪詢狀態用的指令
+	PollCommand() string
+	// MatchFrame 判斷收到的 frame 是否是這個站點的充電機送的 (依 CAN ID)
+	MatchFrame(f Frame) bool
+	// EncodeFrame 把 CANID / Extended / Remote / DLC / Data 組成這個格式的 frame, SocketCAN 收到的 frame 轉換用
+	EncodeFrame(f Frame) ([]byte, error)
+}
+
+// ChecksumScheme 是 frame 結尾的檢查碼計算方式
+type ChecksumScheme struct {
+	Name string
+	Len  int
+	Sum  func(data []byte) []byte // data 不含檢查碼
+}
+
+var (
+	// SumChecksum 是前 15 個 byte 相加取低 8 位
+	SumChecksum = ChecksumScheme{Name: "sum8", Len: 1, Sum: func(data []byte) []byte {
+		return []byte{calculateChecksum(data)}
+	}}
+	// CRC16Checksum 是 CRC-16/MODBUS, 低位 byte 在前
+	CRC16Checksum = ChecksumScheme{Name: "crc16", Len: 2, Sum: func(data []byte) []byte {
+		crc := crc16Modbus(data)
+		return []byte{byte(crc), byte(crc >> 8)}
+	}}
+)
+
+// GatewayDriver 是 CAN 轉 Ethernet 閘道器的格式:
+// 2 bytes header + frame info + 4 bytes CAN ID + 8 bytes CAN data + 檢查碼
+type GatewayDriver struct {
+	name     string
+	checksum ChecksumScheme
+	addr     CANAddress
+}
+
+const (
+	gatewayPrefixLen = 7
+	canDataLen       = 8
+)
+
+func NewGatewayDriver(name string, checksum ChecksumScheme, addr CANAddress) *GatewayDriver {
+	return &GatewayDriver{name: name, checksum: checksum, addr: addr}
+}
+
+func (d *GatewayDriver) Name() string { return d.name }
+
+func (d *GatewayDriver) Address() CANAddress { return d.addr }
+
+func (d *GatewayDriver) FrameLen() int {
+	return gatewayPrefixLen + canDataLen + d.checksum.Len
+}
+
+func (d *GatewayDriver) PollCommand() string { return "read" }
+
+func (d *GatewayDriver) Checksum(pkt []byte) []byte {
+	return d.checksum.Sum(pkt[:gatewayPrefixLen+canDataLen])
+}
+
+func (d *GatewayDriver) EncodeCommand(stationId, cmd string) ([]byte, error) {
+	spec, args, err := ParseCommand(cmd)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	payload, err := spec.Encode(args)
+	if err != nil {
+		return []byte{}, err
+	}
+	return d.build(stationId, payload)
+}
+
+func (d *GatewayDriver) EncodeStatus(stationId string, st Status) ([]byte, error) {
+	return d.build(stationId, encodeStatusPayload(st))
+}
+
+func (d *GatewayDriver) DecodeFrame(pkt []byte) (Frame, error) {
+	n := d.FrameLen()
+	if len(pkt) != n {
+		return Frame{}, fmt.Errorf("frame length %d, want %d", len(pkt), n)
+	}
+
+	body, got := pkt[:n-d.checksum.Len], pkt[n-d.checksum.Len:]
+	if want := d.checksum.Sum(body); string(want) != string(got) {
+		return Frame{}, fmt.Errorf("checksum mismatch: got %x, want %x", got, want)
+	}
+
+	info, err := ParseFrameInfo(pkt[2])
+	if err != nil {
+		return Frame{}, err
+	}
+
+	f := Frame{
+		Raw:      append([]byte(nil), pkt...),
+		CANID:    binary.BigEndian.Uint32(pkt[3:gatewayPrefixLen]),
+		Extended: info.Extended,
+		Remote:   info.Remote,
+		DLC:      info.DLC,
+	}
+	copy(f.Data[:], pkt[gatewayPrefixLen:gatewayPrefixLen+canDataLen])
+	f.StationId = hex.EncodeToString(f.Data[:1])
+	return f, nil
+}
+
+// MatchFrame 只接受充電機回覆 CAN ID 的數據幀, 且 DLC 要裝得下 station byte + payload
+func (d *GatewayDriver) MatchFrame(f Frame) bool {
+	return !f.Remote && f.Extended == d.addr.Extended && f.CANID == d.addr.RxID && f.DLC == canDataLen
+}
+
+func (d *GatewayDriver) EncodeFrame(f Frame) ([]byte, error) {
+	if f.DLC < 0 || f.DLC > canDataLen {
+		return nil, fmt.Errorf("DLC %d out of range 0-%d", f.DLC, canDataLen)
+	}
+	data := f.Data[:f.DLC]
+	if f.Remote {
+		data = nil
+	}
+	body, err := buildFrame(FrameInfo{Extended: f.Extended, Remote: f.Remote, DLC: f.DLC}, f.CANID, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, d.checksum.Sum(body)...), nil
+}
+
+// build 組出 station byte + 7 bytes payload 的數據幀, 再依檢查碼方式補上結尾。
+// 取代原本的 buildCommand: 那個版本寫死 CAN ID 0x0f00 與 sum8 檢查碼, station id 格式錯誤時 log.Fatal
+// 讓整個服務結束; 現在 CAN ID、檢查碼依站點設定, 錯誤回傳給呼叫端。DefaultDriver 組出的 frame 與原本逐 byte 相同
+func (d *GatewayDriver) build(stationId string, payload [7]byte) ([]byte, error) {
+	station, err := hex.DecodeString(stationId)
+	if err != nil || len(station) != 1 {
+		return nil, fmt.Errorf("station id %q must be two hex characters", stationId)
+	}
+
+	data := append(station, payload[:]...)
+	body, err := buildFrame(FrameInfo{Extended: d.addr.Extended, DLC: len(data)}, d.addr.ID, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, d.checksum.Sum(body)...), nil
+}
+
+// DefaultDriver 是目前充電機使用的格式, 站點沒有指定 protocol 時使用
+var DefaultDriver ProtocolDriver = NewGatewayDriver("default", SumChecksum, DefaultCANAddress)
+
+var drivers = map[string]func(CANAddress) ProtocolDriver{
+	"default": func(addr CANAddress) ProtocolDriver { return NewGatewayDriver("default", SumChecksum, addr) },
+	"crc16":   func(addr CANAddress) ProtocolDriver { return NewGatewayDriver("crc16", CRC16Checksum, addr) },
+}
+
+// Driver 依名稱找使用 DefaultCANAddress 的 driver, 空白表示 DefaultDriver
+func Driver(name string) (ProtocolDriver, error) {
+	if name == "" {
+		return DefaultDriver, nil
+	}
+	return NewDriver(name, DefaultCANAddress)
+}
+
+// NewDriver 依名稱建立使用指定 CAN 定址的 driver, 空白表示 default
+func NewDriver(name string, addr CANAddress) (ProtocolDriver, error) {
+	if name == "" {
+		name = "default"
+	}
+	newDriver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol %q, available: %s", name, strings.Join(DriverNames(), ", "))
+	}
+	return newDriver(addr), nil
+}
+
+// DriverNames 回傳所有 driver 名稱
+func DriverNames() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// crc16Modbus 是 CRC-16/MODBUS: poly 0x8005 (反射 0xA001), init 0xFFFF
+func crc16Modbus(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
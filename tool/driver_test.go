@@ -0,0 +1,60 @@
+package tool
+
+import "testing"
+
+// CRC-16/MODBUS 的標準檢查值
+func TestCRC16ModbusCheckValue(t *testing.T) {
+	if got := crc16Modbus([]byte("123456789")); got != 0x4B37 {
+		t.Fatalf("crc16Modbus(\"123456789\") = %#04x, want 0x4b37", got)
+	}
+	// 低位 byte 在前
+	if got := CRC16Checksum.Sum([]byte("123456789")); got[0] != 0x37 || got[1] != 0x4B {
+		t.Fatalf("CRC16Checksum = % x, want 37 4b", got)
+	}
+}
+
+func TestCRC16DriverRoundTrip(t *testing.T) {
+	d, err := Driver("crc16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range []string{"start", "stop", "read", "set_current_limit 32.5", "identify 10"} {
+		frame, err := d.EncodeCommand("0a", cmd)
+		if err != nil {
+			t.Fatalf("EncodeCommand(%q): %v", cmd, err)
+		}
+		if len(frame) != d.FrameLen() {
+			t.Fatalf("EncodeCommand(%q) is %d bytes, want %d", cmd, len(frame), d.FrameLen())
+		}
+		f, err := d.DecodeFrame(frame)
+		if err != nil {
+			t.Fatalf("DecodeFrame(%q): %v", cmd, err)
+		}
+		if got, ok := DecodeCommand(f); !ok || got != cmd || f.StationId != "0a" {
+			t.Errorf("DecodeCommand = %q, %v station %s, want %q station 0a", got, ok, f.StationId, cmd)
+		}
+	}
+
+	st := Status{Charging: true, Voltage: 401.2, Current: 31.5, Temperature: -5, FaultBits: 0x04}
+	frame, err := d.EncodeStatus("0a", st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := d.DecodeFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := DecodeStatus(f); got != st {
+		t.Fatalf("DecodeStatus = %+v, want %+v", got, st)
+	}
+
+	// 任何一個 byte 錯誤都要被檢查碼擋下
+	for i := range frame {
+		bad := append([]byte(nil), frame...)
+		bad[i] ^= 0x01
+		if _, err := d.DecodeFrame(bad); err == nil {
+			t.Errorf("DecodeFrame accepted a frame with byte %d flipped", i)
+		}
+	}
+}
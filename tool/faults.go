@@ -0,0 +1,49 @@
+package tool
+
+// 告警嚴重度, 由高到低
+const (
+	SeverityCritical = "critical"
+	SeverityMajor    = "major"
+	SeverityMinor    = "minor"
+)
+
+// Fault 是充電機狀態 data[7] 故障位元的其中一個 bit
+type Fault struct {
+	Bit      byte
+	Code     string // 給程式與 MQTT 使用的代碼
+	Name     string // 顯示用名稱
+	Severity string
+}
+
+// Faults 是故障位元表, 順序即 bit0 ~ bit7
+var Faults = []Fault{
+	{Bit: 0x01, Code: "over_temperature", Name: "過溫", Severity: SeverityCritical},
+	{Bit: 0x02, Code: "over_voltage", Name: "輸出過壓", Severity: SeverityCritical},
+	{Bit: 0x04, Code: "under_voltage", Name: "輸入欠壓", Severity: SeverityMajor},
+	{Bit: 0x08, Code: "over_current", Name: "輸出過流", Severity: SeverityCritical},
+	{Bit: 0x10, Code: "short_circuit", Name: "輸出短路", Severity: SeverityCritical},
+	{Bit: 0x20, Code: "communication_loss", Name: "充電機與電池通訊中斷", Severity: SeverityMajor},
+	{Bit: 0x40, Code: "fan_failure", Name: "風扇故障", Severity: SeverityMinor},
+	{Bit: 0x80, Code: "emergency_stop", Name: "緊急停止", Severity: SeverityCritical},
+}
+
+// DecodeFaults 回傳 bits 中有設定的故障
+func DecodeFaults(bits byte) []Fault {
+	var faults []Fault
+	for _, f := range Faults {
+		if bits&f.Bit != 0 {
+			faults = append(faults, f)
+		}
+	}
+	return faults
+}
+
+// FaultByCode 依代碼找故障位元, 例如 "over_temperature"
+func FaultByCode(code string) (Fault, bool) {
+	for _, f := range Faults {
+		if f.Code == code {
+			return f, true
+		}
+	}
+	return Fault{}, false
+}
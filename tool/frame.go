@@ -0,0 +1,110 @@
+package tool
+
+// FrameLen 是 DefaultDriver 一個 frame 的長度: 7 bytes 前綴 (header, frame info, CAN ID) + 8 bytes CAN data + 1 byte checksum
+const FrameLen = 16
+
+// Frame 是從閘道器收到、已通過 checksum 檢查的一個 frame
+type Frame struct {
+	Raw       []byte
+	CANID     uint32
+	Extended  bool // 29-bit 擴展幀
+	Remote    bool // 遠程幀, 不帶資料
+	DLC       int
+	StationId string  // CAN data 第一個 byte, 以兩位小寫 hex 表示
+	Data      [8]byte // CAN data (含 station byte)
+}
+
+// ParseFrame 以 DefaultDriver 解析一個完整的 frame 並驗證 checksum
+func ParseFrame(pkt []byte) (Frame, error) {
+	return DefaultDriver.DecodeFrame(pkt)
+}
+
+// FrameSplitter 把 TCP stream 切成 frame, 對不上 frame 邊界時一次丟一個 byte 重新同步
+type FrameSplitter struct {
+	Driver ProtocolDriver // nil 表示 DefaultDriver
+	buf    []byte
+}
+
+// Feed 放入新收到的 bytes, 回傳完整且 checksum 正確的 frame 與被丟掉的 byte 數
+func (s *FrameSplitter) Feed(b []byte) ([]Frame, int) {
+	s.buf = append(s.buf, b...)
+
+	driver := s.Driver
+	if driver == nil {
+		driver = DefaultDriver
+	}
+	n := driver.FrameLen()
+
+	var frames []Frame
+	dropped := 0
+	for len(s.buf) >= n {
+		f, err := driver.DecodeFrame(s.buf[:n])
+		if err != nil {
+			s.buf = s.buf[1:]
+			dropped++
+			continue
+		}
+		s.buf = s.buf[n:]
+		frames = append(frames, f)
+	}
+	return frames, dropped
+}
+
+// Reset 清掉暫存, 斷線重連時使用
+func (s *FrameSplitter) Reset() {
+	s.buf = nil
+}
+
+// Checksum 計算 DefaultDriver frame 前 15 個 byte 的 checksum
+func Checksum(pkt []byte) byte {
+	return calculateChecksum(pkt)
+}
+
+// Status 是充電機回覆 read 指令的狀態內容
+//
+//	data[1]   狀態 bit0 = 充電中
+//	data[2:4] 電壓 (0.1 V, big endian)
+//	data[4:6] 電流 (0.1 A, big endian)
+//	data[6]   溫度 (°C, signed)
+//	data[7]   故障位元
+//
+// 這個配置還沒對照充電機協定文件, 是暫定的 (目前只有模擬器照這個格式回覆)
+type Status struct {
+	Charging    bool
+	Voltage     float64
+	Current     float64
+	Temperature int
+	FaultBits   byte
+}
+
+// DecodeStatus 將 frame 的 CAN data 解成充電機狀態
+func DecodeStatus(f Frame) Status {
+	d := f.Data
+	return Status{
+		Charging:    d[1]&0x01 != 0,
+		Voltage:     float64(uint16(d[2])<<8|uint16(d[3])) / 10,
+		Current:     float64(uint16(d[4])<<8|uint16(d[5])) / 10,
+		Temperature: int(int8(d[6])),
+		FaultBits:   d[7],
+	}
+}
+
+// EncodeStatus 是 DecodeStatus 的反向, 以 DefaultDriver 組出充電機回覆 read 的 frame
+func EncodeStatus(stationId string, st Status) ([]byte, error) {
+	return DefaultDriver.EncodeStatus(stationId, st)
+}
+
+// encodeStatusPayload 組出 station byte 之後的 7 bytes CAN data
+func encodeStatusPayload(st Status) [7]byte {
+	var d [7]byte
+	if st.Charging {
+		d[0] = 0x01
+	}
+	v := uint16(st.Voltage*10 + 0.5)
+	a := uint16(st.Current*10 + 0.5)
+	d[1], d[2] = byte(v>>8), byte(v)
+	d[3], d[4] = byte(a>>8), byte(a)
+	d[5] = byte(int8(st.Temperature))
+	d[6] = st.FaultBits
+	return d
+}
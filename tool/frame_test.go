@@ -0,0 +1,63 @@
+package tool
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// 原本 buildCommand 組出的 frame (與實機閘道器溝通過的格式), 解析方向也要對得上
+func TestParseLegacyFrames(t *testing.T) {
+	tests := []struct {
+		frame string
+		cmd   string
+	}{
+		{"00000800000f000100000000000001" + "19", "start"},
+		{"00000800000f000100000000000000" + "18", "stop"},
+		{"00000800000f000100000000000077" + "8f", "read"},
+	}
+	for _, tt := range tests {
+		raw, _ := hex.DecodeString(tt.frame)
+		f, err := ParseFrame(raw)
+		if err != nil {
+			t.Fatalf("ParseFrame(%s): %v", tt.frame, err)
+		}
+		if f.CANID != 0xf00 || f.Extended || f.Remote || f.DLC != 8 || f.StationId != "01" {
+			t.Errorf("ParseFrame(%s) = %+v", tt.frame, f)
+		}
+		if got, ok := DecodeCommand(f); !ok || got != tt.cmd {
+			t.Errorf("DecodeCommand(%s) = %q, %v, want %q", tt.frame, got, ok, tt.cmd)
+		}
+
+		raw[len(raw)-1]--
+		if _, err := ParseFrame(raw); err == nil {
+			t.Errorf("ParseFrame accepted %s with a wrong checksum", tt.frame)
+		}
+	}
+}
+
+// Status 的欄位配置還沒對照協定文件 (見 readme), 這裡以手組的 frame 固定目前的解讀,
+// 拿到實機擷取的 read 回覆後要加進來
+func TestDecodeStatusLayout(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  Status
+	}{
+		// 充電中 401.2 V 31.5 A -5 °C, 故障位元 0x04
+		{"charging", "00000800000f00" + "01010fac013bfb04" + "0f", Status{Charging: true, Voltage: 401.2, Current: 31.5, Temperature: -5, FaultBits: 0x04}},
+		// data[1] 只看 bit0, 其他位元忽略
+		{"idle", "00000800000f00" + "0a02000000001900" + "3c", Status{Temperature: 25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _ := hex.DecodeString(tt.frame)
+			f, err := ParseFrame(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := DecodeStatus(f); got != tt.want {
+				t.Fatalf("DecodeStatus = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,507 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	klog "kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/tool"
+
+	"go.uber.org/zap"
+)
+
+// tcp_server 的閘道器識別方式
+const (
+	IdentifyIP       = "ip"       // 來源 IP 等於站點的 ip
+	IdentifyStation  = "station"  // 收到帶有站號的 frame 時綁定該站
+	IdentifyRegister = "register" // 閘道器連上後先送的註冊封包 (ASCII) 等於站點的 register_id
+)
+
+// Identifies 是所有識別方式
+var Identifies = []string{IdentifyIP, IdentifyStation, IdentifyRegister}
+
+// IdentifyTimeout 是連進來後多久內要識別出至少一個站點, 否則斷線
+const IdentifyTimeout = 10 * time.Second
+
+// 共用同一個 listen 位址的站點共用一個 hub
+var (
+	hubsMu sync.Mutex
+	hubs   = make(map[string]*hub)
+)
+
+// hub 是一個 listen 位址: 接受閘道器連線、識別後綁定到站點,
+// 再依 frame 的站號分送給各站的 CANClient
+type hub struct {
+	mu       sync.Mutex
+	listen   string
+	driver   tool.ProtocolDriver // 切 frame 用, 同一個 listen 的站點 protocol 要相同
+	ln       net.Listener
+	stations map[string]*hubStation // 小寫站號
+	sessions map[*session]struct{}
+	logger   *zap.Logger
+}
+
+// hubStation 是註冊在 hub 上的一個站點
+type hubStation struct {
+	id         string
+	identify   string
+	ip         string
+	registerID string
+	session    *session     // 目前綁定的閘道器連線
+	conn       *stationConn // 目前交給 CANClient 的連線
+	ready      chan *stationConn
+	logger     *zap.Logger
+}
+
+// session 是一條閘道器連線, 可能綁定多個站點
+type session struct {
+	conn       net.Conn
+	writeMu    sync.Mutex
+	bound      map[string]*stationConn // 以下以 hub.mu 保護
+	checked    bool                    // 已經比對過註冊封包
+	refused    map[string]bool         // 已經被其他連線綁定而略過的站點, 只警告一次
+	registerID string
+}
+
+// serverTransport 是一個站點在 hub 上的 Transport
+type serverTransport struct {
+	hub *hub
+	st  *hubStation
+}
+
+func newServerTransport(o Options, logger *zap.Logger) (*serverTransport, error) {
+	if o.Driver == nil {
+		o.Driver = tool.DefaultDriver
+	}
+	identify := o.Identify
+	if identify == "" {
+		identify = IdentifyStation
+		if o.Remote != "" {
+			identify = IdentifyIP
+		}
+	}
+
+	// host name 在拿鎖之前解析, 供已經連著的閘道器比對
+	var ips []string
+	if identify == IdentifyIP {
+		ips = resolveIP(o.Remote)
+	}
+
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+
+	h := hubs[o.Listen]
+	if h == nil {
+		h = &hub{
+			listen:   o.Listen,
+			driver:   o.Driver,
+			stations: make(map[string]*hubStation),
+			sessions: make(map[*session]struct{}),
+			logger:   klog.Logger.With(zap.String("listen", o.Listen)),
+		}
+		hubs[o.Listen] = h
+	} else if h.driver.Name() != o.Driver.Name() {
+		return nil, fmt.Errorf("listen %s is shared with protocol %s, got %s", o.Listen, h.driver.Name(), o.Driver.Name())
+	}
+
+	st := &hubStation{
+		id:         strings.ToLower(o.StationId),
+		identify:   identify,
+		ip:         o.Remote,
+		registerID: o.RegisterID,
+		ready:      make(chan *stationConn, 1),
+		logger:     logger,
+	}
+	h.register(st, ips)
+	return &serverTransport{hub: h, st: st}, nil
+}
+
+func (t *serverTransport) Open(ctx context.Context) (io.ReadWriteCloser, error) {
+	if err := t.hub.start(); err != nil {
+		return nil, err
+	}
+	select {
+	case conn := <-t.st.ready:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *serverTransport) Datagram() bool { return false }
+
+func (t *serverTransport) Close() error {
+	t.hub.unregister(t.st)
+	return nil
+}
+
+func (t *serverTransport) String() string {
+	switch t.st.identify {
+	case IdentifyIP:
+		return fmt.Sprintf("tcp_server://%s (ip %s)", t.hub.listen, t.st.ip)
+	case IdentifyRegister:
+		return fmt.Sprintf("tcp_server://%s (register %q)", t.hub.listen, t.st.registerID)
+	}
+	return fmt.Sprintf("tcp_server://%s (station %s)", t.hub.listen, t.st.id)
+}
+
+// register 加入站點; 已經有閘道器連著且符合識別條件時直接綁定, ips 是 ip 識別時解析好的位址
+func (h *hub) register(st *hubStation, ips []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.stations[st.id] = st
+	for s := range h.sessions {
+		if (st.identify == IdentifyIP && matchIP(ips, s.conn.RemoteAddr())) ||
+			(st.identify == IdentifyRegister && s.registered(st.registerID)) {
+			h.bind(s, st)
+		}
+	}
+}
+
+// unregister 移除站點, 最後一個站點移除時關閉 listener
+func (h *hub) unregister(st *hubStation) {
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stations[st.id] != st {
+		return
+	}
+	delete(h.stations, st.id)
+	h.unbind(st)
+
+	if len(h.stations) > 0 {
+		return
+	}
+	if h.ln != nil {
+		h.ln.Close()
+		h.ln = nil
+	}
+	for s := range h.sessions {
+		s.conn.Close()
+	}
+	delete(hubs, h.listen)
+}
+
+// start 在第一次 Open 時開始監聽, 失敗時由 CANClient 稍後重試
+func (h *hub) start() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ln != nil {
+		return nil
+	}
+
+	lc := net.ListenConfig{KeepAlive: KeepAlive}
+	ln, err := lc.Listen(context.Background(), "tcp", h.listen)
+	if err != nil {
+		return err
+	}
+	h.ln = ln
+	h.logger.Info("listening for gateways")
+	go h.acceptLoop(ln)
+	return nil
+}
+
+func (h *hub) acceptLoop(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				h.logger.Error("accept error", zap.Error(err))
+			}
+			return
+		}
+		go h.serve(conn)
+	}
+}
+
+// serve 識別閘道器並把收到的 frame 分送給綁定的站點
+func (h *hub) serve(conn net.Conn) {
+	peer := conn.RemoteAddr().String()
+	s := &session{conn: conn, bound: make(map[string]*stationConn), refused: make(map[string]bool)}
+
+	// DNS 查詢可能很久, 不能拿著 mu 做: 先取出 ip 識別的站點, 解析完再回來綁定
+	h.mu.Lock()
+	var byIP []*hubStation
+	for _, st := range h.stations {
+		if st.identify == IdentifyIP {
+			byIP = append(byIP, st)
+		}
+	}
+	h.mu.Unlock()
+
+	var matched []*hubStation
+	for _, st := range byIP {
+		if matchIP(resolveIP(st.ip), conn.RemoteAddr()) {
+			matched = append(matched, st)
+		}
+	}
+
+	h.mu.Lock()
+	h.sessions[s] = struct{}{}
+	for _, st := range matched {
+		// 解析期間站點可能已經移除
+		if h.stations[st.id] == st {
+			h.bind(s, st)
+		}
+	}
+	h.mu.Unlock()
+
+	defer func() {
+		conn.Close()
+		h.mu.Lock()
+		delete(h.sessions, s)
+		for _, sc := range s.bound {
+			h.unbind(sc.st)
+		}
+		h.mu.Unlock()
+		h.logger.Info("gateway disconnected", zap.String("peer", peer))
+	}()
+
+	if !h.canIdentify(s) {
+		h.logger.Warn("reject connection from unknown peer", zap.String("peer", peer))
+		return
+	}
+	h.logger.Info("gateway connected", zap.String("peer", peer))
+
+	splitter := tool.FrameSplitter{Driver: h.driver}
+	buf := make([]byte, 1024)
+	first := true
+	for {
+		// 還沒識別出任何站點前要在時限內送資料
+		if h.boundCount(s) == 0 {
+			conn.SetReadDeadline(time.Now().Add(IdentifyTimeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				h.logger.Warn("gateway not identified in time", zap.String("peer", peer), zap.Duration("timeout", IdentifyTimeout))
+			}
+			return
+		}
+
+		data := buf[:n]
+		if first {
+			first = false
+			data = h.identifyRegister(s, data)
+		}
+
+		frames, _ := splitter.Feed(data)
+		for _, f := range frames {
+			h.dispatch(s, f)
+		}
+
+		if h.boundCount(s) == 0 && !h.canIdentify(s) {
+			h.logger.Warn("reject connection from unknown peer", zap.String("peer", peer))
+			return
+		}
+	}
+}
+
+// canIdentify 判斷這條連線是否還可能綁定到站點
+func (h *hub) canIdentify(s *session) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(s.bound) > 0 {
+		return true
+	}
+	for _, st := range h.stations {
+		if st.identify == IdentifyStation || (st.identify == IdentifyRegister && !s.checked) {
+			return true
+		}
+	}
+	return false
+}
+
+// identifyRegister 比對第一段資料開頭的註冊封包, 回傳去掉註冊封包後的資料
+func (h *hub) identifyRegister(s *session, data []byte) []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var matched []*hubStation
+	id := ""
+	for _, st := range h.stations {
+		if st.identify != IdentifyRegister || !bytes.HasPrefix(data, []byte(st.registerID)) {
+			continue
+		}
+		// 註冊封包可能彼此是前綴, 取最長的
+		if len(st.registerID) > len(id) {
+			matched, id = nil, st.registerID
+		}
+		if st.registerID == id {
+			matched = append(matched, st)
+		}
+	}
+	s.checked = true
+	if id == "" {
+		return data
+	}
+
+	s.registerID = id
+	for _, st := range matched {
+		h.bind(s, st)
+	}
+	return bytes.TrimLeft(data[len(id):], "\r\n")
+}
+
+// dispatch 把 frame 交給站號對應的站點, station 識別的站點在這裡綁定
+func (h *hub) dispatch(s *session, f tool.Frame) {
+	h.mu.Lock()
+	sc := s.bound[f.StationId]
+	if sc == nil {
+		st := h.stations[f.StationId]
+		if st == nil || st.identify != IdentifyStation {
+			h.mu.Unlock()
+			return
+		}
+		// 站號誰都能送, 不讓另一條連線搶走已綁定的站點; 舊連線斷掉後才改綁
+		if st.session != nil {
+			if !s.refused[st.id] {
+				s.refused[st.id] = true
+				st.logger.Warn("station already bound to another gateway, ignore frames from new peer",
+					zap.String("bound", st.session.conn.RemoteAddr().String()), zap.String("peer", s.conn.RemoteAddr().String()))
+			}
+			h.mu.Unlock()
+			return
+		}
+		sc = h.bind(s, st)
+	}
+	h.mu.Unlock()
+
+	sc.deliver(f.Raw)
+}
+
+func (h *hub) boundCount(s *session) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(s.bound)
+}
+
+// bind 把站點綁定到 session, 同一個閘道器重連時取代舊的連線; 呼叫時需持有 mu
+func (h *hub) bind(s *session, st *hubStation) *stationConn {
+	if st.session == s {
+		return st.conn
+	}
+	if st.session != nil {
+		st.logger.Info("gateway reconnected, replace old connection",
+			zap.String("old", st.session.conn.RemoteAddr().String()), zap.String("new", s.conn.RemoteAddr().String()))
+		h.unbind(st)
+	}
+
+	sc := &stationConn{s: s, st: st, frames: make(chan []byte, 64), done: make(chan struct{})}
+	st.session, st.conn = s, sc
+	s.bound[st.id] = sc
+
+	// 丟掉還沒被 Open 取走的舊連線
+	select {
+	case old := <-st.ready:
+		old.Close()
+	default:
+	}
+	st.ready <- sc
+	st.logger.Info("gateway identified", zap.String("peer", s.conn.RemoteAddr().String()), zap.String("identify", st.identify))
+	return sc
+}
+
+// unbind 解除站點目前的綁定, session 沒有綁定的站點時關閉; 呼叫時需持有 mu
+func (h *hub) unbind(st *hubStation) {
+	if st.session == nil {
+		return
+	}
+	s := st.session
+	delete(s.bound, st.id)
+	st.conn.Close()
+	st.session, st.conn = nil, nil
+
+	if len(s.bound) == 0 {
+		s.conn.Close()
+	}
+}
+
+func (s *session) registered(id string) bool {
+	return id != "" && s.registerID == id
+}
+
+// stationConn 是交給單一站點 CANClient 的連線, 讀到的是分送過來的 frame
+type stationConn struct {
+	s      *session
+	st     *hubStation
+	frames chan []byte
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (c *stationConn) deliver(frame []byte) {
+	select {
+	case c.frames <- frame:
+	case <-c.done:
+	default:
+		c.st.logger.Warn("station not reading, drop frame")
+	}
+}
+
+func (c *stationConn) Read(p []byte) (int, error) {
+	select {
+	case frame := <-c.frames:
+		// frame 比 CANClient 的 buffer 小很多, 一次讀完
+		return copy(p, frame), nil
+	case <-c.done:
+		return 0, io.EOF
+	}
+}
+
+func (c *stationConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.s.writeMu.Lock()
+	defer c.s.writeMu.Unlock()
+	return c.s.conn.Write(p)
+}
+
+func (c *stationConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+// resolveIP 回傳設定的 ip (或 host name) 對應的位址, 查不到時只有設定值本身
+func resolveIP(host string) []string {
+	if host == "" {
+		return nil
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) != nil {
+		return []string{host}
+	}
+	ips, _ := net.LookupHost(host)
+	return append([]string{host}, ips...)
+}
+
+// matchIP 比對 resolveIP 的結果與連線來源
+func matchIP(ips []string, addr net.Addr) bool {
+	peer, _, _ := net.SplitHostPort(addr.String())
+	for _, ip := range ips {
+		if ip == peer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	klog "kenmec/jimmy/charge_core/log"
+	"kenmec/jimmy/charge_core/tool"
+
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	klog.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// newTestServer 在隨機 port 開一個 tcp_server 站點, 回傳閘道器要連的位址
+func newTestServer(t *testing.T, o Options) (*serverTransport, string) {
+	t.Helper()
+
+	o.Kind, o.Listen = KindTCPServer, "127.0.0.1:0"
+	tr, err := New(o, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := tr.(*serverTransport)
+	t.Cleanup(func() { st.Close() })
+	if err := st.hub.start(); err != nil {
+		t.Fatal(err)
+	}
+	return st, st.hub.ln.Addr().String()
+}
+
+// open 等站點綁定到閘道器, d 內沒綁定時回傳 nil
+func open(t *testing.T, tr *serverTransport, d time.Duration) io.ReadWriteCloser {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	conn, err := tr.Open(ctx)
+	if err != nil {
+		return nil
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func dialGateway(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// sendStatus 由閘道器送一個站點 01 的狀態幀, 回傳送出的 frame
+func sendStatus(t *testing.T, gw net.Conn, voltage float64) []byte {
+	t.Helper()
+
+	frame, err := tool.EncodeStatus("01", tool.Status{Voltage: voltage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gw.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func readFrame(t *testing.T, conn io.Reader) []byte {
+	t.Helper()
+
+	got := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := conn.Read(buf)
+		got <- buf[:n]
+	}()
+	select {
+	case b := <-got:
+		return b
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame delivered")
+		return nil
+	}
+}
+
+func TestServerStationKeepsBinding(t *testing.T) {
+	tr, addr := newTestServer(t, Options{StationId: "01"})
+
+	gw1 := dialGateway(t, addr)
+	want := sendStatus(t, gw1, 400)
+	conn := open(t, tr, 2*time.Second)
+	if conn == nil {
+		t.Fatal("station not bound to the first gateway")
+	}
+	if got := readFrame(t, conn); !bytes.Equal(got, want) {
+		t.Fatalf("Read = % x, want % x", got, want)
+	}
+
+	// 另一條連線送同一個站號不會搶走綁定, frame 也不會交給站點
+	gw2 := dialGateway(t, addr)
+	sendStatus(t, gw2, 500)
+	if c := open(t, tr, 200*time.Millisecond); c != nil {
+		t.Fatal("second gateway took over the station")
+	}
+	want = sendStatus(t, gw1, 410)
+	if got := readFrame(t, conn); !bytes.Equal(got, want) {
+		t.Fatalf("Read = % x, want the first gateway's % x", got, want)
+	}
+
+	// 舊連線斷線後新連線才改綁
+	gw1.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	var next io.ReadWriteCloser
+	for next == nil && time.Now().Before(deadline) {
+		want = sendStatus(t, gw2, 420)
+		next = open(t, tr, 100*time.Millisecond)
+	}
+	if next == nil {
+		t.Fatal("station not rebound after the first gateway disconnected")
+	}
+	if got := readFrame(t, next); !bytes.Equal(got, want) {
+		t.Fatalf("Read = % x, want % x", got, want)
+	}
+}
+
+func TestServerIdentifyHostName(t *testing.T) {
+	tr, addr := newTestServer(t, Options{StationId: "01", Identify: IdentifyIP, Remote: "localhost"})
+
+	dialGateway(t, addr)
+	if open(t, tr, 2*time.Second) == nil {
+		t.Fatal("gateway from localhost not identified")
+	}
+}
+
+func TestMatchIP(t *testing.T) {
+	peer := &net.TCPAddr{IP: net.ParseIP("192.168.1.20"), Port: 40000}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"192.168.1.20", true},
+		{"192.168.1.20:9000", true},
+		{"192.168.1.21", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := matchIP(resolveIP(tt.host), peer); got != tt.want {
+			t.Errorf("matchIP(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
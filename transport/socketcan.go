@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"kenmec/jimmy/charge_core/tool"
+)
+
+// Linux struct can_frame: can_id (host byte order, 含旗標) + len + pad + res0 + len8_dlc + data[8]
+const (
+	canFrameLen = 16
+
+	canEFFFlag = 0x80000000 // 擴展幀
+	canRTRFlag = 0x40000000 // 遠程幀
+	canERRFlag = 0x20000000 // 錯誤幀
+	canEFFMask = 0x1FFFFFFF
+)
+
+// canConn 在 SocketCAN 的 can_frame 與 driver 的 frame 格式之間轉換,
+// 讓 CANClient 不用知道底下是閘道器還是直接接在 CAN bus 上;
+// rw 每次讀寫剛好一個 can_frame (raw CAN socket, 或測試用的 SOCK_SEQPACKET socketpair)
+type canConn struct {
+	rw     io.ReadWriteCloser
+	driver tool.ProtocolDriver
+	buf    [canFrameLen]byte
+}
+
+func newCANConn(rw io.ReadWriteCloser, driver tool.ProtocolDriver) *canConn {
+	if driver == nil {
+		driver = tool.DefaultDriver
+	}
+	return &canConn{rw: rw, driver: driver}
+}
+
+// Read 讀一個 can_frame 並轉成 driver 的 frame, 錯誤幀直接略過
+func (c *canConn) Read(p []byte) (int, error) {
+	for {
+		n, err := c.rw.Read(c.buf[:])
+		if err != nil {
+			return 0, err
+		}
+		f, ok := decodeCANFrame(c.buf[:n])
+		if !ok {
+			continue
+		}
+
+		pkt, err := c.driver.EncodeFrame(f)
+		if err != nil {
+			continue
+		}
+		if len(p) < len(pkt) {
+			return 0, io.ErrShortBuffer
+		}
+		return copy(p, pkt), nil
+	}
+}
+
+// Write 把 driver 的 frame 轉成 can_frame 送出, p 必須剛好是一個 frame
+func (c *canConn) Write(p []byte) (int, error) {
+	f, err := c.driver.DecodeFrame(p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.rw.Write(encodeCANFrame(f)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *canConn) Close() error {
+	return c.rw.Close()
+}
+
+func encodeCANFrame(f tool.Frame) []byte {
+	id := f.CANID
+	if f.Extended {
+		id = id&canEFFMask | canEFFFlag
+	}
+	if f.Remote {
+		id |= canRTRFlag
+	}
+
+	b := make([]byte, canFrameLen)
+	binary.NativeEndian.PutUint32(b[0:4], id)
+	b[4] = byte(f.DLC)
+	if !f.Remote {
+		copy(b[8:], f.Data[:f.DLC])
+	}
+	return b
+}
+
+func decodeCANFrame(b []byte) (tool.Frame, bool) {
+	if len(b) != canFrameLen {
+		return tool.Frame{}, false
+	}
+	id := binary.NativeEndian.Uint32(b[0:4])
+	if id&canERRFlag != 0 || b[4] > 8 {
+		return tool.Frame{}, false
+	}
+
+	f := tool.Frame{
+		CANID:    id & canEFFMask,
+		Extended: id&canEFFFlag != 0,
+		Remote:   id&canRTRFlag != 0,
+		DLC:      int(b[4]),
+	}
+	copy(f.Data[:], b[8:8+f.DLC])
+	f.StationId = fmt.Sprintf("%02x", f.Data[0])
+	return f, true
+}
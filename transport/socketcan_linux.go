@@ -0,0 +1,59 @@
+//go:build linux
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"kenmec/jimmy/charge_core/tool"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketCAN 直接使用工控機上的 CAN 介面 (例如 can0, 測試時可用 vcan0),
+// bitrate 等設定由系統的 ip link 處理
+type socketCAN struct {
+	iface  string
+	driver tool.ProtocolDriver
+	open   func(iface string) (io.ReadWriteCloser, error) // 測試時換成 socketpair
+}
+
+func newSocketCAN(iface string, driver tool.ProtocolDriver) *socketCAN {
+	return &socketCAN{iface: iface, driver: driver, open: openCANSocket}
+}
+
+func (t *socketCAN) Open(ctx context.Context) (io.ReadWriteCloser, error) {
+	rw, err := t.open(t.iface)
+	if err != nil {
+		return nil, err
+	}
+	return newCANConn(rw, t.driver), nil
+}
+
+// Datagram: 每次 Read 是一個 frame, CAN bus 也沒有連線狀態, 以是否收到 frame 判斷
+func (t *socketCAN) Datagram() bool { return true }
+func (t *socketCAN) Close() error   { return nil }
+func (t *socketCAN) String() string { return "socketcan://" + t.iface }
+
+// openCANSocket 開一個綁定到 iface 的 raw CAN socket;
+// non-blocking 的 fd 交給 os.NewFile 後走 runtime poller, Close 可以中斷阻塞中的 Read
+func openCANSocket(iface string) (io.ReadWriteCloser, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("socketcan interface %s: %w", iface, err)
+	}
+
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.CAN_RAW)
+	if err != nil {
+		return nil, fmt.Errorf("socketcan: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrCAN{Ifindex: ifi.Index}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("socketcan bind %s: %w", iface, err)
+	}
+	return os.NewFile(uintptr(fd), "can:"+iface), nil
+}
@@ -0,0 +1,144 @@
+//go:build linux
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"kenmec/jimmy/charge_core/tool"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPair 讓 socketCAN 開在 SOCK_SEQPACKET socketpair 的一端, 回傳的另一端扮演 CAN bus;
+// 與 openCANSocket 一樣用 non-blocking fd, 走 runtime poller
+func openPair(t *testing.T, addr tool.CANAddress) (io.ReadWriteCloser, *os.File, tool.ProtocolDriver) {
+	t.Helper()
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := os.NewFile(uintptr(fds[1]), "bus")
+	t.Cleanup(func() { bus.Close() })
+
+	driver, err := tool.NewDriver("", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := newSocketCAN("vcan0", driver)
+	tr.open = func(iface string) (io.ReadWriteCloser, error) {
+		return os.NewFile(uintptr(fds[0]), "can:"+iface), nil
+	}
+	conn, err := tr.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bus, driver
+}
+
+func TestSocketCANRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		addr tool.CANAddress
+	}{
+		{"standard", tool.CANAddress{ID: 0x123, RxID: 0x124}},
+		{"extended", tool.CANAddress{ID: 0x18ff50e5, RxID: 0x18ff51e5, Extended: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, bus, driver := openPair(t, tt.addr)
+
+			// 服務送出的 frame 在 bus 上是一個 can_frame
+			cmd, err := driver.EncodeCommand("01", "start")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err := conn.Write(cmd); err != nil || n != len(cmd) {
+				t.Fatalf("Write = %d, %v", n, err)
+			}
+
+			raw := make([]byte, 64)
+			n, err := bus.Read(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != canFrameLen {
+				t.Fatalf("can_frame is %d bytes, want %d", n, canFrameLen)
+			}
+			wantId := tt.addr.ID
+			if tt.addr.Extended {
+				wantId |= canEFFFlag
+			}
+			if id := binary.NativeEndian.Uint32(raw[0:4]); id != wantId {
+				t.Fatalf("can_id = %#x, want %#x", id, wantId)
+			}
+			sent, _ := driver.DecodeFrame(cmd)
+			if raw[4] != byte(sent.DLC) || !bytes.Equal(raw[8:8+sent.DLC], sent.Data[:sent.DLC]) {
+				t.Fatalf("can_frame % x does not carry % x", raw[:n], sent.Data[:sent.DLC])
+			}
+
+			// 充電機的回覆: 錯誤幀略過, 狀態幀轉回 driver 的格式
+			status := tool.Status{Charging: true, Voltage: 400.5, Current: 32, Temperature: 41, FaultBits: 0x02}
+			reply, err := driver.EncodeStatus("01", status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// EncodeStatus 用的是送給充電機的 ID, 充電機回覆時用 rx_id
+			f, _ := driver.DecodeFrame(reply)
+			f.CANID = tt.addr.RxID
+			if reply, err = driver.EncodeFrame(f); err != nil {
+				t.Fatal(err)
+			}
+
+			errFrame := make([]byte, canFrameLen)
+			binary.NativeEndian.PutUint32(errFrame[0:4], canERRFlag|0x04)
+			if _, err := bus.Write(errFrame); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := bus.Write(encodeCANFrame(f)); err != nil {
+				t.Fatal(err)
+			}
+
+			buf := make([]byte, 64)
+			n, err = conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], reply) {
+				t.Fatalf("Read = % x, want % x", buf[:n], reply)
+			}
+
+			got, err := driver.DecodeFrame(buf[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !driver.MatchFrame(got) || got.StationId != "01" || tool.DecodeStatus(got) != status {
+				t.Fatalf("decoded %+v / %+v, want station 01 %+v", got, tool.DecodeStatus(got), status)
+			}
+		})
+	}
+}
+
+func TestSocketCANCloseUnblocksRead(t *testing.T) {
+	conn, _, _ := openPair(t, tool.DefaultCANAddress)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 64))
+		done <- err
+	}()
+
+	conn.Close()
+	if err := <-done; err == nil {
+		t.Fatal("Read returned no error after Close")
+	}
+}
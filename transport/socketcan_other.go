@@ -0,0 +1,28 @@
+//go:build !linux
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"kenmec/jimmy/charge_core/tool"
+)
+
+// socketCAN 只有 Linux 支援, 其他平台 Open 一律失敗
+type socketCAN struct {
+	iface string
+}
+
+func newSocketCAN(iface string, driver tool.ProtocolDriver) *socketCAN {
+	return &socketCAN{iface: iface}
+}
+
+func (t *socketCAN) Open(ctx context.Context) (io.ReadWriteCloser, error) {
+	return nil, errors.New("socketcan is only supported on Linux")
+}
+
+func (t *socketCAN) Datagram() bool { return true }
+func (t *socketCAN) Close() error   { return nil }
+func (t *socketCAN) String() string { return "socketcan://" + t.iface }
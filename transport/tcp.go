@@ -0,0 +1,24 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// KeepAlive 是 TCP keepalive 的間隔, 閘道器斷電時讓讀取端在一分鐘內發現
+const KeepAlive = 15 * time.Second
+
+type tcpClient struct {
+	addr string
+}
+
+func (t *tcpClient) Open(ctx context.Context) (io.ReadWriteCloser, error) {
+	d := net.Dialer{Timeout: 10 * time.Second, KeepAlive: KeepAlive}
+	return d.DialContext(ctx, "tcp", t.addr)
+}
+
+func (t *tcpClient) Datagram() bool { return false }
+func (t *tcpClient) Close() error   { return nil }
+func (t *tcpClient) String() string { return "tcp://" + t.addr }
@@ -0,0 +1,69 @@
+// Package transport 是 CANClient 與閘道器之間的連線方式: 主動連線的 TCP、
+// 由閘道器連進來並識別站點的 TCP server 與無連線的 UDP
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"kenmec/jimmy/charge_core/tool"
+
+	"go.uber.org/zap"
+)
+
+const (
+	KindTCP       = "tcp"        // 服務主動連到閘道器 (預設)
+	KindTCPServer = "tcp_server" // 閘道器連到服務
+	KindUDP       = "udp"        // 每個 CAN frame 是一個 datagram
+	KindSocketCAN = "socketcan"  // 直接使用本機的 CAN 介面 (Linux)
+)
+
+// Kinds 是所有支援的 transport
+var Kinds = []string{KindTCP, KindTCPServer, KindUDP, KindSocketCAN}
+
+// Transport 建立與閘道器之間的連線, 斷線後 CANClient 會再呼叫 Open 重連
+type Transport interface {
+	// Open 建立一條連線, 阻塞到連上、失敗或 ctx 結束
+	Open(ctx context.Context) (io.ReadWriteCloser, error)
+	// Datagram 為 true 時每次 Read 是一個完整的 datagram, 也沒有連線狀態,
+	// 由 CANClient 以是否持續收到 frame 判斷閘道器是否還在
+	Datagram() bool
+	// Close 釋放 listener 等跨連線的資源
+	Close() error
+	// String 是給 log 看的位址
+	String() string
+}
+
+// Options 是建立 Transport 需要的位址
+type Options struct {
+	Kind   string // 空白表示 KindTCP
+	Remote string // 閘道器的 host:port; tcp_server 是 ip 識別用的 host
+	Listen string // tcp_server 的監聽位址, udp 的本機綁定位址 (空白表示隨機 port)
+
+	// 以下是 tcp_server 用的, 同一個 Listen 的站點共用一個 listener
+	StationId  string
+	Identify   string // 空白時有 Remote 用 IdentifyIP, 否則 IdentifyStation
+	RegisterID string // IdentifyRegister 的註冊封包
+
+	Interface string              // socketcan 的介面名稱, 例如 can0
+	Driver    tool.ProtocolDriver // tcp_server 切 frame、socketcan 轉換格式用, nil 表示 tool.DefaultDriver
+}
+
+func New(o Options, logger *zap.Logger) (Transport, error) {
+	switch o.Kind {
+	case "", KindTCP:
+		return &tcpClient{addr: o.Remote}, nil
+	case KindTCPServer:
+		t, err := newServerTransport(o, logger)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case KindUDP:
+		return &udpTransport{remote: o.Remote, local: o.Listen, logger: logger}, nil
+	case KindSocketCAN:
+		return newSocketCAN(o.Interface, o.Driver), nil
+	}
+	return nil, fmt.Errorf("unknown transport %q", o.Kind)
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"go.uber.org/zap"
+)
+
+// udpTransport 每個 CAN frame 是一個 datagram, 送到閘道器的 host:port,
+// 只收閘道器 IP 送來的 datagram (閘道器送出用的 port 常常和它監聽的不同)
+type udpTransport struct {
+	remote string
+	local  string
+	logger *zap.Logger
+}
+
+func (t *udpTransport) Open(ctx context.Context) (io.ReadWriteCloser, error) {
+	raddr, err := net.ResolveUDPAddr("udp", t.remote)
+	if err != nil {
+		return nil, err
+	}
+
+	var laddr *net.UDPAddr
+	if t.local != "" {
+		if laddr, err = net.ResolveUDPAddr("udp", t.local); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpConn{conn: conn, remote: raddr, logger: t.logger}, nil
+}
+
+func (t *udpTransport) Datagram() bool { return true }
+func (t *udpTransport) Close() error   { return nil }
+
+func (t *udpTransport) String() string {
+	if t.local == "" {
+		return "udp://" + t.remote
+	}
+	return "udp://" + t.remote + " (local " + t.local + ")"
+}
+
+type udpConn struct {
+	conn   *net.UDPConn
+	remote *net.UDPAddr
+	logger *zap.Logger
+}
+
+func (c *udpConn) Read(p []byte) (int, error) {
+	for {
+		n, from, err := c.conn.ReadFromUDP(p)
+		if err != nil {
+			return n, err
+		}
+		if !from.IP.Equal(c.remote.IP) {
+			c.logger.Debug("ignore datagram from unknown peer", zap.String("peer", from.String()))
+			continue
+		}
+		return n, nil
+	}
+}
+
+func (c *udpConn) Write(p []byte) (int, error) {
+	return c.conn.WriteToUDP(p, c.remote)
+}
+
+func (c *udpConn) Close() error {
+	return c.conn.Close()
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type QamsCommand struct {
 	StationId string
 	Cmd       string
@@ -10,3 +12,63 @@ type ConnectionTcp struct {
 	IsConnect bool   `json:"isConnect"`
 	Msg       string `json:"msg"`
 }
+
+// CommandResult.Status
+const (
+	CommandQueued   = "queued"   // 已排入站點的寫入佇列, 不代表充電機已經執行, 要看遙測確認
+	CommandRejected = "rejected" // 指令或參數無效, 或站點已關閉
+	CommandOffline  = "offline"  // 站點未連線
+)
+
+type CommandResult struct {
+	StationId string `json:"stationId"`
+	Cmd       string `json:"cmd"`
+	Ok        bool   `json:"ok"` // 等同 Status == CommandQueued
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ChargerTelemetry struct {
+	StationId   string    `json:"stationId"`
+	Charging    bool      `json:"charging"`
+	Voltage     float64   `json:"voltage"`
+	Current     float64   `json:"current"`
+	Temperature int       `json:"temperature"`
+	FaultBits   uint8     `json:"faultBits"`
+	EnergyWh    float64   `json:"energyWh"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// Alarm 是一筆告警, 條件存在時為 active, 條件解除後只留在歷史紀錄
+type Alarm struct {
+	StationId string     `json:"stationId"`
+	Code      string     `json:"code"`
+	Name      string     `json:"name"`
+	Severity  string     `json:"severity"`
+	RaisedAt  time.Time  `json:"raisedAt"`
+	ClearedAt *time.Time `json:"clearedAt,omitempty"`
+	Acked     bool       `json:"acked"`
+	AckedAt   *time.Time `json:"ackedAt,omitempty"`
+	AckedBy   string     `json:"ackedBy,omitempty"`
+}
+
+// AlarmList 是站點目前 active 的告警, 發佈為 retained
+type AlarmList struct {
+	StationId string    `json:"stationId"`
+	Alarms    []Alarm   `json:"alarms"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AlarmAck 是操作員確認告警, Code 空白或 "all" 表示確認該站全部告警
+type AlarmAck struct {
+	StationId string `json:"stationId"`
+	Code      string `json:"code"`
+	By        string `json:"by"`
+}
+
+// AlarmRecord 是告警歷史的一筆: raised / cleared / acked
+type AlarmRecord struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Alarm Alarm     `json:"alarm"`
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version 由建置時的 -ldflags "-X main.version=v1.2.3" 設定
+var version = "dev"
+
+func printVersion() {
+	fmt.Printf("chargestationcore %s\n", version)
+	fmt.Printf("go        %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified":
+			fmt.Printf("%-9s %s\n", s.Key[len("vcs."):], s.Value)
+		}
+	}
+}